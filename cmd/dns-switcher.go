@@ -1,37 +1,38 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/Mreza2020/DNS-Switcher/internal/daemon"
+	"github.com/Mreza2020/DNS-Switcher/internal/output"
 	platformall "github.com/Mreza2020/DNS-Switcher/internal/platform-all"
+	"github.com/Mreza2020/DNS-Switcher/internal/proxy"
 	"github.com/Mreza2020/DNS-Switcher/internal/resolver"
+	"github.com/Mreza2020/DNS-Switcher/internal/settings"
 	"github.com/spf13/cobra"
 )
 
-var (
-	DomainTesting string
-)
-
-func init() {
-	DomainTesting = os.Getenv("DomainTesting")
-
-	if DomainTesting == "" {
-		log.Fatal("Environment variable DomainTesting is not set. Please set it before running.")
-		return
-	}
-}
+// appSettings are the settings of the running command, loaded before it runs
+var appSettings = settings.Defaults()
 
 func normalizeDNS(list []string) []string {
 	var ips []string
 	for _, entry := range list {
 		trimmed := strings.TrimSpace(entry)
+		trimmed, _, _ = strings.Cut(trimmed, "%")
 		if net.ParseIP(trimmed) != nil {
 			ips = append(ips, trimmed)
 		}
@@ -56,12 +57,622 @@ func equalDNS(a, b []string) bool {
 	return true
 }
 
+// printSteps lists the steps of a failed apply, e.g. "  apply    failed  netsh error: ..."
+func printSteps(steps []platformall.ApplyStep) {
+	for _, s := range steps {
+		status := "ok"
+		if !s.Ok {
+			status = "failed"
+		}
+		fmt.Printf("  %-8s %-6s  %s\n", s.Name, status, s.Message)
+	}
+}
+
+// proxyServer returns the address interfaces are pointed at for a proxy
+// listening on host. A wildcard or empty host listens on every address, so
+// the loopback address of its family is used.
+func proxyServer(host string) (string, error) {
+	if host == "" {
+		return "127.0.0.1", nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("the proxy must listen on an IP address to be used as DNS server, not '%s'", host)
+	}
+	if ip.IsUnspecified() {
+		if ip.To4() != nil {
+			return "127.0.0.1", nil
+		}
+		return "::1", nil
+	}
+	return ip.String(), nil
+}
+
+// dashIfEmpty returns "-" for an empty table cell
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// benchSetup reads the benchmark flags shared by test and auto and resolves
+// the queries: the --suite flag for every server, otherwise the suite of each
+// profile, otherwise resolver.DefaultQueries.
+// The returned cancel func must always be called.
+func benchSetup(cmd *cobra.Command, repeat int, profiles []config.Profile) (context.Context, context.CancelFunc, resolver.BenchOptions, error) {
+	dohMethod, _ := cmd.Flags().GetString("doh-method")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	deadline, _ := cmd.Flags().GetDuration("deadline")
+	cacheBustZone, _ := cmd.Flags().GetString("cache-bust-zone")
+	suiteName, _ := cmd.Flags().GetString("suite")
+
+	opts := resolver.BenchOptions{
+		Queries:       resolver.DefaultQueries(appSettings.Domain),
+		Repeat:        repeat,
+		Timeout:       2 * time.Second,
+		Concurrency:   concurrency,
+		DoHMethod:     dohMethod,
+		CacheBustZone: cacheBustZone,
+	}
+
+	noop := func() {}
+	suites, err := config.LoadSuites()
+	if err != nil {
+		return nil, noop, opts, err
+	}
+	queriesOf := func(name string) ([]resolver.Query, error) {
+		suite, err := config.FindSuite(suites, name)
+		if err != nil {
+			return nil, err
+		}
+		return resolver.SuiteQueries(suite)
+	}
+
+	if suiteName != "" {
+		if opts.Queries, err = queriesOf(suiteName); err != nil {
+			return nil, noop, opts, err
+		}
+	} else {
+		for _, p := range profiles {
+			if p.Suite == "" {
+				continue
+			}
+			q, err := queriesOf(p.Suite)
+			if err != nil {
+				return nil, noop, opts, fmt.Errorf("profile '%s': %v", p.Name, err)
+			}
+			if opts.ProfileQueries == nil {
+				opts.ProfileQueries = map[string][]resolver.Query{}
+			}
+			opts.ProfileQueries[p.Name] = q
+		}
+	}
+
+	if deadline > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), deadline)
+		return ctx, cancel, opts, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return ctx, cancel, opts, nil
+}
+
+// printServerResult prints every sample of a server followed by its statistics.
+// Cache-busting samples, if any, are printed and summarized separately.
+func printServerResult(sr resolver.ServerResult) {
+	printSamples(sr.Target, "", sr.Samples)
+	if avg, ok := sr.Average(); ok {
+		fmt.Printf("%s -> average RTT: %v\n", sr.Target, avg)
+	}
+
+	if len(sr.Uncached) == 0 {
+		fmt.Printf("%s -> %v\n", sr.Target, sr.Stats())
+		return
+	}
+	printSamples(sr.Target, " uncached", sr.Uncached)
+	fmt.Printf("%s -> cached: %v\n", sr.Target, sr.Stats())
+	fmt.Printf("%s -> uncached: %v\n", sr.Target, sr.UncachedStats())
+}
+
+// printSamples prints one line per sample, label marks the kind of sample
+func printSamples(target resolver.Target, label string, samples []resolver.Result) {
+	for i, r := range samples {
+		if r.Error != nil {
+			fmt.Printf("%s ->%s error %s: %v\n", target, label, r.Query, r.Error)
+			continue
+		}
+		if r.Handshake > 0 {
+			fmt.Printf("%s ->%s RTT[%d] %s: %v (handshake %v)\n", target, label, i+1, r.Query, r.RTT, r.Handshake)
+		} else {
+			fmt.Printf("%s ->%s RTT[%d] %s: %v\n", target, label, i+1, r.Query, r.RTT)
+		}
+	}
+}
+
+// printProfileStats prints the statistics of a whole profile
+func printProfileStats(pr resolver.ProfileResult) {
+	if len(pr.UncachedSamples()) == 0 {
+		fmt.Printf("Profile '%s': %v\n", pr.Profile.Name, pr.Stats())
+		return
+	}
+	fmt.Printf("Profile '%s' cached: %v\n", pr.Profile.Name, pr.Stats())
+	fmt.Printf("Profile '%s' uncached: %v\n", pr.Profile.Name, pr.UncachedStats())
+}
+
+// printSummary prints one row per server with its latency statistics and,
+// when dnssec is not nil, its DNSSEC validation status
+func printSummary(servers []resolver.ServerResult, dnssec []resolver.DNSSECResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "SERVER\tAVG\tP50\tP90\tLOSS"
+	if dnssec != nil {
+		header += "\tDNSSEC"
+	}
+	fmt.Fprintln(w, header)
+
+	for i, sr := range servers {
+		st := sr.Stats()
+		avg, p50, p90 := "-", "-", "-"
+		if st.Received > 0 {
+			avg, p50, p90 = st.Mean.String(), st.Median.String(), st.P90.String()
+		}
+		row := fmt.Sprintf("%s\t%s\t%s\t%s\t%.0f%%", sr.Target, avg, p50, p90, st.Loss*100)
+		if dnssec != nil {
+			row += "\t" + string(dnssec[i].Status)
+		}
+		fmt.Fprintln(w, row)
+	}
+	w.Flush()
+}
+
+// validatingProfiles checks DNSSEC on every server and keeps the profiles
+// whose servers all validate; skipped maps the others to the reason
+func validatingProfiles(ctx context.Context, results []resolver.ProfileResult, opts resolver.BenchOptions) (kept []resolver.ProfileResult, skipped map[string]string) {
+	var targets []resolver.Target
+	for _, pr := range results {
+		for _, sr := range pr.Servers {
+			targets = append(targets, sr.Target)
+		}
+	}
+	checks := resolver.CheckDNSSECAll(ctx, targets, opts.Timeout, opts.DoHMethod, opts.Concurrency)
+
+	skipped = map[string]string{}
+	i := 0
+	for _, pr := range results {
+		for range pr.Servers {
+			if c := checks[i]; c.Status != resolver.DNSSECValidating && skipped[pr.Profile.Name] == "" {
+				reason := fmt.Sprintf("%s is %s", c.Server, c.Status)
+				if c.Detail != "" {
+					reason += fmt.Sprintf(" (%s)", c.Detail)
+				}
+				skipped[pr.Profile.Name] = reason
+			}
+			i++
+		}
+		if skipped[pr.Profile.Name] == "" {
+			kept = append(kept, pr)
+		}
+	}
+	return kept, skipped
+}
+
+// outputFormat is the --output format of the running command
+var outputFormat = output.Text
+
+// legacyJSON is set when -j was given to one of legacyJSONCommands. It
+// selects the JSON these commands printed before --output existed, kept
+// byte for byte for existing scripts; --output json has the result types.
+var legacyJSON bool
+
+var legacyJSONCommands = map[string]bool{"status": true, "delete-profile": true}
+
+// prompt receives interactive prompts, stderr when stdout carries a
+// machine-readable result
+var prompt io.Writer = os.Stdout
+
+// machine reports whether the result is written in a machine-readable format
+func machine() bool {
+	return outputFormat != output.Text
+}
+
+// emit writes the result of a command in the --output format
+func emit(v interface{}) {
+	if err := output.Write(os.Stdout, outputFormat, v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// emitLegacy prints values the way -j printed them before --output existed:
+// each indented on its own
+func emitLegacy(values ...interface{}) {
+	for _, v := range values {
+		data, _ := json.MarshalIndent(v, "", "  ")
+		fmt.Println(string(data))
+	}
+}
+
+// fail reports why a command failed, as a line of text or in a
+// machine-readable format as an output.ErrorResult, and exits with status 1
+func fail(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if !machine() {
+		fmt.Println(msg)
+		os.Exit(1)
+	}
+	emit(output.ErrorResult{Error: msg})
+	os.Exit(1)
+}
+
+// selectInterfaces resolves the --iface and --all-interfaces flags of cmd,
+// prompting unless the settings forbid it; change tells whether cmd is
+// about to change the DNS of the interfaces. ok is false, after reporting
+// why, when no interface was selected
+func selectInterfaces(cmd *cobra.Command, change bool) (ifaces []string, ok bool) {
+	spec, _ := cmd.Flags().GetString("iface")
+	all, _ := cmd.Flags().GetBool("all-interfaces")
+	ifaces, err := platformall.ResolveInterfaces(platformall.InterfaceSelection{
+		Spec:           spec,
+		All:            all,
+		NonInteractive: appSettings.NonInteractive,
+		Change:         change,
+	})
+	if err != nil {
+		fail("%v", err)
+		return nil, false
+	}
+	return ifaces, true
+}
+
+// addInterfaceFlags adds the flags read by selectInterfaces
+func addInterfaceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("iface", "i", "",
+		fmt.Sprintf("Network interface: a name, %q for the default route's interface or a glob pattern such as \"Wi-Fi*\"", platformall.AutoInterface))
+	cmd.Flags().Bool("all-interfaces", false, "Act on every active network interface")
+}
+
+// healthQueries returns the queries of suite, or resolver.DefaultQueries
+// when suite is empty
+func healthQueries(suite string) ([]resolver.Query, error) {
+	if suite == "" {
+		return resolver.DefaultQueries(appSettings.Domain), nil
+	}
+	suites, err := config.LoadSuites()
+	if err != nil {
+		return nil, err
+	}
+	s, err := config.FindSuite(suites, suite)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.SuiteQueries(s)
+}
+
+// checkHealth resolves the queries of suite through targets and through
+// the system resolver
+func checkHealth(targets []resolver.Target, suite string) (resolver.HealthReport, error) {
+	queries, err := healthQueries(suite)
+	if err != nil {
+		return resolver.HealthReport{}, err
+	}
+	return resolver.CheckHealth(context.Background(), targets, resolver.HealthOptions{
+		Queries:     queries,
+		Timeout:     2 * time.Second,
+		Concurrency: 8,
+		System:      true,
+	}), nil
+}
+
+// printHealth prints the outcome of a health check and the checks that failed
+func printHealth(r resolver.HealthReport) {
+	verdict := "passed"
+	if !r.Passed {
+		verdict = "FAILED"
+	}
+	failed := r.Failed()
+	fmt.Printf("Verification %s: %d of %d checks ok\n", verdict, len(r.Checks)-len(failed), len(r.Checks))
+	for _, c := range failed {
+		fmt.Printf("  %s %s: %v\n", c.Path, c.Result.Query, c.Result.Error)
+	}
+}
+
+// awaitConfirmation waits until the pending applies of ifaces are confirmed,
+// with `dns-switcher confirm` from another session or by pressing Enter.
+// It returns false when deadline passes or the command is interrupted.
+func awaitConfirmation(ifaces []string, deadline time.Time) bool {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	poll := time.NewTicker(500 * time.Millisecond)
+	defer poll.Stop()
+
+	enter := make(chan struct{})
+	if !appSettings.NonInteractive {
+		go func() {
+			// a closed stdin must not count as confirmation
+			if _, err := bufio.NewReader(platformall.PromptIn).ReadString('\n'); err == nil {
+				close(enter)
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-enter:
+			for _, iface := range ifaces {
+				platformall.ConfirmPending(iface)
+			}
+			return true
+		case <-poll.C:
+			pending, err := platformall.LoadPending()
+			if err != nil || time.Now().After(deadline) {
+				continue
+			}
+			waiting := false
+			for _, p := range pending {
+				for _, iface := range ifaces {
+					waiting = waiting || (p.Owner == "" && p.Interface == iface)
+				}
+			}
+			if !waiting {
+				return true
+			}
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// finishApply verifies the interfaces of result that were applied by
+// resolving the profile's suite through its servers and the system
+// resolver. A failed verification restores the previous settings with
+// rollbackOnFail or confirmWithin; with confirmWithin a passed one then
+// waits for the user to confirm, and restores them when nobody does.
+// The error tells why the command failed.
+func finishApply(result *output.ApplyResult, p config.Profile, confirmWithin time.Duration, rollbackOnFail bool) error {
+	applied := false
+	for _, i := range result.Interfaces {
+		applied = applied || i.Applied
+	}
+	if !applied {
+		return nil
+	}
+
+	deadline := time.Now().Add(confirmWithin)
+	report, err := checkHealth(resolver.TargetsOf(p), p.Suite)
+	if err != nil {
+		return fmt.Errorf("cannot verify: %v", err)
+	}
+	result.Verification = &report
+	if !machine() {
+		printHealth(report)
+	}
+
+	var cause error
+	switch {
+	case !report.Passed && (rollbackOnFail || confirmWithin > 0):
+		cause = fmt.Errorf("verification failed")
+	case !report.Passed:
+		return fmt.Errorf("verification failed")
+	case confirmWithin > 0:
+		var ifaces []string
+		for _, i := range result.Interfaces {
+			if i.Applied {
+				ifaces = append(ifaces, i.Interface)
+			}
+		}
+		fmt.Fprintf(prompt, "Keep these settings? Run `dns-switcher confirm` or press Enter within %s, otherwise they are reverted.\n", confirmWithin)
+		if awaitConfirmation(ifaces, deadline) {
+			result.Confirmation = "confirmed"
+			fmt.Fprintln(prompt, "Confirmed – keeping the new DNS settings")
+			return nil
+		}
+		cause = fmt.Errorf("not confirmed within %s", confirmWithin)
+	default:
+		return nil
+	}
+
+	fmt.Fprintf(prompt, "%v – reverting\n", cause)
+	if confirmWithin > 0 {
+		result.Confirmation = "reverted"
+	}
+	for i := range result.Interfaces {
+		entry := &result.Interfaces[i]
+		if !entry.Applied {
+			continue
+		}
+		var reverted []platformall.ApplyResult
+		var rerr error
+		if confirmWithin > 0 {
+			reverted, rerr = platformall.RevertPending(entry.Interface)
+		} else {
+			var res platformall.ApplyResult
+			if res, rerr = platformall.RollbackTo(entry.Snapshot); rerr == nil {
+				reverted = append(reverted, res)
+			}
+		}
+		switch {
+		case rerr != nil:
+			entry.Message = fmt.Sprintf("Error reverting: %v", rerr)
+		case len(reverted) > 0:
+			entry.RolledBack, entry.Message = true, reverted[0].Message
+		default:
+			// reverted by another run in the meantime
+			entry.RolledBack, entry.Message = true, "Reverted"
+		}
+		if !machine() {
+			fmt.Println(entry.Message)
+		}
+	}
+	return cause
+}
+
+// rollbackApplied restores the snapshots of the applied entries, newest
+// first, once applying failed on a later interface, and forgets those that
+// were awaiting confirmation
+func rollbackApplied(entries []output.InterfaceApply) {
+	var snapshots []int
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := &entries[i]
+		if !entry.Applied || entry.Snapshot == 0 {
+			continue
+		}
+		res, err := platformall.RollbackTo(entry.Snapshot)
+		if err != nil {
+			entry.Message = fmt.Sprintf("Error reverting: %v", err)
+		} else {
+			entry.RolledBack, entry.Message = true, res.Message
+			snapshots = append(snapshots, entry.Snapshot)
+		}
+		if !machine() {
+			fmt.Println(entry.Message)
+		}
+	}
+	if err := platformall.RemovePending(snapshots); err != nil {
+		fmt.Fprintln(prompt, err)
+	}
+}
+
+// verifyCurrent is apply --verify-only: it checks resolution through the
+// servers the selected interfaces use and through the system resolver
+func verifyCurrent(cmd *cobra.Command) {
+	ifaces, ok := selectInterfaces(cmd, false)
+	if !ok {
+		return
+	}
+
+	res := output.HealthResult{Interfaces: []output.InterfaceDNS{}}
+	var targets []resolver.Target
+	seen := map[string]bool{}
+	for _, iface := range ifaces {
+		servers, err := platformall.GetCurrentDNS(iface)
+		if err != nil {
+			fail("Error getting current DNS: %v", err)
+			return
+		}
+		servers = normalizeDNS(servers)
+		res.Interfaces = append(res.Interfaces, output.NewInterfaceDNS(iface, servers))
+		for _, s := range servers {
+			if !seen[s] {
+				seen[s] = true
+				targets = append(targets, resolver.Target{Server: s})
+			}
+		}
+	}
+
+	report, err := checkHealth(targets, "")
+	if err != nil {
+		fail("Cannot verify: %v", err)
+		return
+	}
+	res.Verification = report
+
+	if machine() {
+		emit(res)
+	} else {
+		for _, i := range res.Interfaces {
+			fmt.Printf("%s: %s\n", i.Interface, strings.Join(append(append([]string{}, i.IPv4...), i.IPv6...), " "))
+		}
+		printHealth(report)
+	}
+	if !report.Passed {
+		os.Exit(1)
+	}
+}
+
+// printProxyState prints the local proxy part of status
+func printProxyState(st proxy.State) {
+	fmt.Printf("Local proxy on %s forwarding to profile '%s' (pid %d, since %s)\n",
+		st.Listen, st.Profile, st.PID, st.StartedAt.Format(time.RFC3339))
+	if st.Cache != nil {
+		fmt.Printf("Cache: %s\n", st.Cache)
+	} else {
+		fmt.Println("Cache: disabled")
+	}
+	fmt.Println("Upstreams:")
+	for _, u := range st.Upstreams {
+		state := "up"
+		if !u.Up {
+			state = "down"
+		}
+		fmt.Printf(" - %s %s, %v, %d failures in a row\n", u.Upstream, state, u.RTT.Round(time.Microsecond), u.Fails)
+	}
+}
+
+// stateCommands read or change the DNS configuration, so the applies left
+// unconfirmed are reverted before they run
+var stateCommands = map[string]bool{
+	"apply": true, "status": true, "rollback": true, "confirm": true, "auto": true,
+	"delete-profile": true, "interfaces": true, "daemon": true, "serve": true,
+}
+
+// revertExpired restores the applies left unconfirmed by an
+// `apply --confirm-within` that crashed or was killed
+func revertExpired() {
+	results, err := platformall.RevertExpired(time.Now())
+	for _, r := range results {
+		fmt.Fprintf(prompt, "Unconfirmed apply reverted: %s\n", r.Message)
+	}
+	if err != nil {
+		fmt.Fprintf(prompt, "Error reverting unconfirmed apply: %v\n", err)
+	}
+}
+
 func main() {
 	var rootCmd = &cobra.Command{
 		Use:   "dns-switcher",
 		Short: "DNS switcher",
 		Long:  "DNS Switcher lets you manage DNS profiles, test latency, apply settings, and rollback safely.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("output")
+			f, err := output.ParseFormat(format)
+			if err != nil {
+				return err
+			}
+			if jsonOut, _ := cmd.Flags().GetBool("json"); jsonOut {
+				legacyJSON = legacyJSONCommands[cmd.Name()] && !cmd.Flags().Changed("output")
+				f = output.JSON
+			}
+			outputFormat = f
+			if machine() {
+				prompt = os.Stderr
+			}
+			platformall.PromptOut = prompt
+
+			configFile, _ := cmd.Flags().GetString("config")
+			s, err := settings.Load(configFile, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			appSettings = s
+			config.Path = s.ProfilesPath
+			platformall.SnapshotPath = s.SnapshotPath
+			proxy.StatePath = filepath.Join(filepath.Dir(s.SnapshotPath), "proxy.json")
+			if err := platformall.UseBackend(s.Backend); err != nil {
+				return err
+			}
+			if stateCommands[cmd.Name()] {
+				revertExpired()
+			}
+			return nil
+		},
 	}
+	rootCmd.PersistentFlags().String("config", "",
+		fmt.Sprintf("Settings file (default $%s or %s)", settings.ConfigEnv, filepath.Join(settings.ConfigDir(), "config.yaml")))
+	rootCmd.PersistentFlags().String(settings.KeyProfiles, "",
+		fmt.Sprintf("Profiles file (default $%s or %s)", settings.Env[settings.KeyProfiles], settings.Defaults().ProfilesPath))
+	rootCmd.PersistentFlags().String(settings.KeySnapshots, "",
+		fmt.Sprintf("Snapshot history file (default $%s or %s)", settings.Env[settings.KeySnapshots], settings.Defaults().SnapshotPath))
+	rootCmd.PersistentFlags().String(settings.KeyDomain, "",
+		fmt.Sprintf("Benchmark this single domain instead of the built-in suite (default $%s)", settings.Env[settings.KeyDomain]))
+	rootCmd.PersistentFlags().String(settings.KeyBackend, "auto",
+		fmt.Sprintf("DNS backend to use: auto, %s", strings.Join(platformall.BackendNames(), ", ")))
+	rootCmd.PersistentFlags().Bool(settings.KeyNonInteractive, false,
+		fmt.Sprintf("Fail instead of prompting for an interface or confirmation (default $%s)", settings.Env[settings.KeyNonInteractive]))
+	rootCmd.PersistentFlags().StringP("output", "o", string(output.Text),
+		fmt.Sprintf("Output format: %v", output.Formats))
 
 	// List Command
 	var listCmd = &cobra.Command{
@@ -69,6 +680,15 @@ func main() {
 		Short: "List available DNS profiles",
 		Run: func(cmd *cobra.Command, args []string) {
 			profiles := config.LoadProfilesDns()
+			if machine() {
+				res := output.ListResult{Profiles: []output.ProfileInfo{}}
+				for _, p := range profiles {
+					res.Profiles = append(res.Profiles, output.NewProfileInfo(p))
+				}
+				emit(res)
+				return
+			}
+
 			if len(profiles) == 0 {
 				fmt.Println("No profiles found")
 				return
@@ -78,7 +698,7 @@ func main() {
 
 			for _, p := range profiles {
 				if verbose {
-					fmt.Printf(" - %s : %v\n", p.Name, p.Servers)
+					fmt.Printf(" - %s : %v\n", p.Name, p.Upstreams())
 				} else {
 					fmt.Printf(" - %s\n", p.Name)
 				}
@@ -98,121 +718,191 @@ func main() {
 			if repeat <= 0 {
 				repeat = 1
 			}
-
 			target := args[0]
 			profiles := config.LoadProfilesDns()
+			p, isProfile := config.FindProfile(profiles, target)
 
-			if p, ok := config.FindProfile(profiles, target); ok {
-				fmt.Printf("Testing profile '%s'\n", p.Name)
-				for _, server := range p.Servers {
-					var sum time.Duration
-					var success int
-
-					for i := 0; i < repeat; i++ {
-						r := resolver.MeasureRTT(server, DomainTesting, 2*time.Second)
-						if r.Error != nil {
-							fmt.Printf("%s -> error: %v\n", server, r.Error)
-							continue
-						}
-						fmt.Printf("%s -> RTT[%d]: %v\n", server, i+1, r.RTT)
-						sum += r.RTT
-						success++
-					}
+			var tested []config.Profile
+			if isProfile {
+				tested = append(tested, *p)
+			}
+			ctx, cancel, opts, err := benchSetup(cmd, repeat, tested)
+			defer cancel()
+			if err != nil {
+				fail("%v", err)
+				return
+			}
 
-					if success > 0 {
-						avg := sum / time.Duration(success)
-						fmt.Printf("%s -> average RTT: %v\n", server, avg)
+			res := output.TestResult{Target: target, Servers: []output.ServerReport{}}
+			var servers []resolver.ServerResult
+			if isProfile {
+				if !machine() {
+					fmt.Printf("Testing profile '%s'\n", p.Name)
+				}
+				pr := resolver.ProfileResult{Profile: *p, Servers: resolver.Benchmark(ctx, resolver.TargetsOf(*p), opts)}
+				if !machine() {
+					for _, sr := range pr.Servers {
+						printServerResult(sr)
 					}
+					printProfileStats(pr)
 				}
+				servers = pr.Servers
+				report := output.NewProfileReport(pr)
+				res.Kind, res.Stats, res.UncachedStats = "profile", report.Stats, report.UncachedStats
 			} else {
-				fmt.Printf("Testing server '%s'\n", target)
-				var sum time.Duration
-				var success int
-
-				for i := 0; i < repeat; i++ {
-					r := resolver.MeasureRTT(target, DomainTesting, 2*time.Second)
-					if r.Error != nil {
-						fmt.Printf("%s -> error: %v\n", target, r.Error)
-						continue
-					}
-					fmt.Printf("%s -> RTT[%d]: %v\n", target, i+1, r.RTT)
-					sum += r.RTT
-					success++
+				t, err := resolver.ParseTarget(target)
+				if err != nil {
+					fail("Invalid server '%s': %v", target, err)
+					return
+				}
+				if !machine() {
+					fmt.Printf("Testing server '%s'\n", target)
+				}
+				servers = resolver.Benchmark(ctx, []resolver.Target{t}, opts)
+				if !machine() {
+					printServerResult(servers[0])
+				}
+				report := output.NewServerReport(servers[0])
+				res.Kind, res.Stats, res.UncachedStats = "server", report.Stats, report.UncachedStats
+			}
+
+			var dnssec []resolver.DNSSECResult
+			if checkDNSSEC, _ := cmd.Flags().GetBool("dnssec"); checkDNSSEC {
+				targets := make([]resolver.Target, len(servers))
+				for i, sr := range servers {
+					targets[i] = sr.Target
 				}
+				dnssec = resolver.CheckDNSSECAll(ctx, targets, opts.Timeout, opts.DoHMethod, opts.Concurrency)
+			}
 
-				if success > 0 {
-					avg := sum / time.Duration(success)
-					fmt.Printf("%s -> average RTT: %v\n", target, avg)
+			if machine() {
+				for i, sr := range servers {
+					report := output.NewServerReport(sr)
+					if dnssec != nil {
+						report.DNSSEC = dnssec[i].Status
+					}
+					res.Servers = append(res.Servers, report)
 				}
+				emit(res)
+				return
 			}
+			fmt.Println()
+			printSummary(servers, dnssec)
 		},
 	}
 	testCmd.Flags().IntP("repeat", "r", 1, "Number of times to repeat RTT test")
+	testCmd.Flags().String("doh-method", "GET", "HTTP method for DNS-over-HTTPS upstreams (GET or POST)")
+	testCmd.Flags().IntP("concurrency", "c", 8, "Number of probes run in parallel")
+	testCmd.Flags().Duration("deadline", 0, "Stop the whole benchmark after this duration (e.g. 10s, 0 = no limit)")
+	testCmd.Flags().String("suite", "", "Benchmark suite to query every server with (default: the profile's suite or the built-in suite)")
+	testCmd.Flags().Bool("dnssec", false, "Also check whether each server validates DNSSEC")
+	testCmd.Flags().String("cache-bust-zone", "", "Also query random names under this zone to measure uncached latency (e.g. example.com)")
 
 	// Apply Command
 	var applyCmd = &cobra.Command{
 		Use:   "apply [profile]",
 		Short: "Apply a DNS profile",
-		Args:  cobra.ExactArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			verifyOnly, _ := cmd.Flags().GetBool("verify-only")
+			switch {
+			case verifyOnly && len(args) > 0:
+				return fmt.Errorf("--verify-only checks the current DNS settings and takes no profile")
+			case verifyOnly:
+				return nil
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			force, _ := cmd.Flags().GetBool("force")
-			iface, _ := cmd.Flags().GetString("iface")
+			confirmWithin, _ := cmd.Flags().GetDuration("confirm-within")
+			rollbackOnFail, _ := cmd.Flags().GetBool("rollback-on-fail")
+
+			if verifyOnly, _ := cmd.Flags().GetBool("verify-only"); verifyOnly {
+				verifyCurrent(cmd)
+				return
+			}
 
 			profileName := args[0]
 			profiles := config.LoadProfilesDns()
 			p, ok := config.FindProfile(profiles, profileName)
 			if !ok {
-				fmt.Printf("Profile '%s' not found\n", profileName)
+				fail("Profile '%s' not found", profileName)
 				return
 			}
 
-			if iface == "" {
-				interfaces, err := platformall.GetNetworkInterfaces()
-				if err != nil || len(interfaces) == 0 {
-					fmt.Println("No network interfaces detected.")
-					return
-				}
+			ifaces, ok := selectInterfaces(cmd, true)
+			if !ok {
+				return
+			}
 
-				fmt.Println("Available network interfaces:")
-				for i, name := range interfaces {
-					fmt.Printf(" [%d] %s\n", i+1, name)
-				}
+			result := output.ApplyResult{Profile: p.Name, Servers: p.AllServers()}
+			for _, iface := range ifaces {
+				p.Interface = iface
+				entry := output.InterfaceApply{Interface: iface}
 
-				fmt.Print("Select interface number: ")
-				var choice int
-				fmt.Scanln(&choice)
+				if !force {
+					current, err := platformall.GetCurrentDNS(iface)
+					if err == nil && len(current) > 0 && equalDNS(normalizeDNS(current), p.AllServers()) {
+						entry.AlreadyActive = true
+						entry.Message = fmt.Sprintf("Profile '%s' is already active on interface '%s'. Use -f to force reapply.", profileName, iface)
+					}
+				}
 
-				if choice < 1 || choice > len(interfaces) {
-					fmt.Println("Invalid choice")
-					return
+				if !entry.AlreadyActive {
+					res, err := platformall.ApplyProfile(*p)
+					entry.Steps, entry.RolledBack = res.Steps, res.RolledBack
+					if err != nil {
+						entry.Message = fmt.Sprintf("Error applying profile: %v", err)
+						result.Interfaces = append(result.Interfaces, entry)
+						if !machine() {
+							printSteps(res.Steps)
+							fmt.Println(entry.Message)
+						}
+						rollbackApplied(result.Interfaces)
+						if machine() {
+							emit(result)
+						}
+						os.Exit(1)
+					}
+					entry.Applied, entry.Snapshot, entry.Message = true, res.Snapshot, res.Message
+
+					if confirmWithin > 0 {
+						err := platformall.AddPending(platformall.PendingApply{Interface: iface, Profile: p.Name,
+							Snapshot: res.Snapshot, Deadline: time.Now().Add(confirmWithin)})
+						if err != nil {
+							fail("Error saving pending apply: %v", err)
+							return
+						}
+					}
 				}
 
-				iface = interfaces[choice-1]
+				result.Interfaces = append(result.Interfaces, entry)
+				if !machine() {
+					fmt.Println(entry.Message)
+				}
 			}
 
-			p.Interface = iface
-
-			if !force {
-				current, err := platformall.GetCurrentDNS(iface)
-				if err == nil && len(current) > 0 {
-					normalized := normalizeDNS(current)
-					if equalDNS(normalized, p.Servers) {
-						fmt.Printf("Profile '%s' is already active on interface '%s'. Use -f to force reapply.\n", profileName, iface)
-						return
-					}
+			if err := finishApply(&result, *p, confirmWithin, rollbackOnFail); err != nil {
+				if machine() {
+					emit(result)
+				} else if result.Verification == nil {
+					// the outcome of a verification is printed already
+					fmt.Println(err)
 				}
+				os.Exit(1)
 			}
 
-			res, err := platformall.ApplyProfile(*p)
-			if err != nil {
-				fmt.Printf("Error applying profile: %v\n", err)
-			} else {
-				fmt.Println(res.Message)
+			if machine() {
+				emit(result)
 			}
 		},
 	}
 	applyCmd.Flags().BoolP("force", "f", false, "Force apply even if already active")
-	applyCmd.Flags().StringP("iface", "i", "", "Specify network interface")
+	applyCmd.Flags().Duration("confirm-within", 0,
+		"Revert unless confirmed with 'dns-switcher confirm' or Enter within this time, e.g. 60s")
+	applyCmd.Flags().Bool("verify-only", false, "Only verify that resolution works with the current DNS settings, applying nothing")
+	applyCmd.Flags().Bool("rollback-on-fail", false, "Restore the previous settings when verification after applying fails")
+	addInterfaceFlags(applyCmd)
 
 	// Status Command
 	var statusCmd = &cobra.Command{
@@ -220,55 +910,60 @@ func main() {
 		Short: "Show current DNS settings",
 		Run: func(cmd *cobra.Command, args []string) {
 
-			jsonOutput, _ := cmd.Flags().GetBool("json")
-			iface, _ := cmd.Flags().GetString("iface")
-
-			if iface == "" {
-				interfaces, err := platformall.GetNetworkInterfaces()
-				if err != nil || len(interfaces) == 0 {
-					fmt.Println("No network interfaces detected.")
-					return
-				}
-
-				fmt.Println("Available network interfaces:")
-				for i, name := range interfaces {
-					fmt.Printf(" [%d] %s\n", i+1, name)
-				}
-
-				fmt.Print("Select interface number: ")
-				var choice int
-				fmt.Scanln(&choice)
+			ifaces, ok := selectInterfaces(cmd, false)
+			if !ok {
+				return
+			}
 
-				if choice < 1 || choice > len(interfaces) {
-					fmt.Println("Invalid choice")
+			res := output.StatusResult{Interfaces: []output.InterfaceDNS{}}
+			var servers []string
+			for _, iface := range ifaces {
+				dnsList, err := platformall.GetCurrentDNS(iface)
+				if err != nil {
+					fail("Error getting current DNS: %v", err)
 					return
 				}
-
-				iface = interfaces[choice-1]
+				normalized := normalizeDNS(dnsList)
+				servers = append(servers, normalized...)
+				res.Interfaces = append(res.Interfaces, output.NewInterfaceDNS(iface, normalized))
 			}
-
-			dnsList, err := platformall.GetCurrentDNS(iface)
-			if err != nil {
-				fmt.Printf("Error getting current DNS: %v\n", err)
+			if legacyJSON {
+				emitLegacy(servers)
 				return
 			}
+			st, err := proxy.LoadState()
+			if err != nil {
+				fmt.Fprintln(prompt, err)
+			}
+			res.Proxy = st
 
-			normalized := normalizeDNS(dnsList)
-
-			if jsonOutput {
-				data, _ := json.MarshalIndent(normalized, "", "  ")
-				fmt.Println(string(data))
-			} else {
+			if machine() {
+				emit(res)
+				return
+			}
+			for _, status := range res.Interfaces {
+				if len(res.Interfaces) > 1 {
+					fmt.Printf("Interface '%s':\n", status.Interface)
+				}
 				fmt.Println("Current DNS servers:")
-				for _, d := range normalized {
+				for _, d := range status.IPv4 {
 					fmt.Println(" -", d)
 				}
+				if len(status.IPv6) > 0 {
+					fmt.Println("Current IPv6 DNS servers:")
+					for _, d := range status.IPv6 {
+						fmt.Println(" -", d)
+					}
+				}
+			}
+			if st != nil {
+				printProxyState(*st)
 			}
 		},
 	}
 
-	statusCmd.Flags().StringP("iface", "i", "", "Select network interface")
-	statusCmd.Flags().BoolP("json", "j", false, "Output status in JSON format")
+	addInterfaceFlags(statusCmd)
+	statusCmd.Flags().BoolP("json", "j", false, "Output the DNS servers as a JSON list (--output json for the full result)")
 
 	// Rollback Command
 	var rollbackCmd = &cobra.Command{
@@ -277,43 +972,106 @@ func main() {
 		Run: func(cmd *cobra.Command, args []string) {
 			quiet, _ := cmd.Flags().GetBool("quiet")
 			iface, _ := cmd.Flags().GetString("iface")
+			list, _ := cmd.Flags().GetBool("list")
+			to, _ := cmd.Flags().GetInt("to")
 
-			if iface == "" {
-				interfaces, err := platformall.GetNetworkInterfaces()
-				if err != nil || len(interfaces) == 0 {
-					fmt.Println("No network interfaces detected.")
+			if list {
+				snaps, err := platformall.ListSnapshots(iface)
+				if err != nil {
+					fail("Error reading snapshots: %v", err)
 					return
 				}
-
-				fmt.Println("Available network interfaces:")
-				for i, name := range interfaces {
-					fmt.Printf(" [%d] %s\n", i+1, name)
+				if machine() {
+					if snaps == nil {
+						snaps = []platformall.Snapshot{}
+					}
+					emit(output.SnapshotsResult{Snapshots: snaps})
+					return
 				}
+				if len(snaps) == 0 {
+					fmt.Println("No snapshots found")
+					return
+				}
+				fmt.Println("Available snapshots (newest first):")
+				for _, s := range snaps {
+					fmt.Printf(" [%d] %s %s (%s) before '%s': %s\n", s.ID,
+						s.CreatedAt.Format("2006-01-02 15:04:05"), s.State.Interface,
+						s.State.Backend, s.Profile, s.State)
+				}
+				return
+			}
 
-				fmt.Print("Select interface number: ")
-				var choice int
-				fmt.Scanln(&choice)
-
-				if choice < 1 || choice > len(interfaces) {
-					fmt.Println("Invalid choice")
+			result := output.RollbackResult{Snapshot: to, Interfaces: []output.InterfaceRollback{}}
+			if to > 0 {
+				snap, err := platformall.FindSnapshot(to)
+				if err == nil {
+					var res platformall.ApplyResult
+					if res, err = platformall.RollbackTo(to); err == nil {
+						result.Interfaces = append(result.Interfaces, output.InterfaceRollback{Interface: snap.State.Interface, Message: res.Message})
+					}
+				}
+				if err != nil {
+					fail("Rollback error: %v", err)
+					return
+				}
+			} else {
+				ifaces, ok := selectInterfaces(cmd, true)
+				if !ok {
 					return
 				}
+				for _, iface := range ifaces {
+					res, err := platformall.Rollback(iface)
+					if err != nil {
+						fail("Rollback error: %v", err)
+						return
+					}
+					result.Interfaces = append(result.Interfaces, output.InterfaceRollback{Interface: iface, Message: res.Message})
+				}
+			}
 
-				iface = interfaces[choice-1]
+			if machine() {
+				emit(result)
+			} else if !quiet {
+				for _, r := range result.Interfaces {
+					fmt.Println(r.Message)
+				}
 			}
+		},
+	}
+	rollbackCmd.Flags().BoolP("quiet", "q", false, "Suppress success message")
+	addInterfaceFlags(rollbackCmd)
+	rollbackCmd.Flags().BoolP("list", "l", false, "List saved DNS snapshots")
+	rollbackCmd.Flags().Int("to", 0, "Restore the snapshot with this id")
+
+	// Confirm Command
+	var confirmCmd = &cobra.Command{
+		Use:   "confirm",
+		Short: "Keep DNS settings applied with apply --confirm-within",
+		Run: func(cmd *cobra.Command, args []string) {
+			iface, _ := cmd.Flags().GetString("iface")
 
-			res, err := platformall.Rollback(iface)
+			confirmed, err := platformall.ConfirmPending(iface)
 			if err != nil {
-				fmt.Printf("Rollback error: %v\n", err)
-			} else {
-				if !quiet {
-					fmt.Println(res.Message)
+				fail("Error confirming: %v", err)
+				return
+			}
+			if machine() {
+				if confirmed == nil {
+					confirmed = []platformall.PendingApply{}
 				}
+				emit(output.ConfirmResult{Confirmed: confirmed})
+				return
+			}
+			if len(confirmed) == 0 {
+				fmt.Println("Nothing to confirm")
+				return
+			}
+			for _, c := range confirmed {
+				fmt.Printf("Confirmed profile '%s' on interface '%s'\n", c.Profile, c.Interface)
 			}
 		},
 	}
-	rollbackCmd.Flags().BoolP("quiet", "q", false, "Suppress success message")
-	rollbackCmd.Flags().StringP("iface", "i", "", "Select network interface")
+	confirmCmd.Flags().StringP("iface", "i", "", "Confirm only the apply on this interface (default: all)")
 
 	// Add-profile Command
 	var addProfileCmd = &cobra.Command{
@@ -323,16 +1081,25 @@ func main() {
 			name, _ := cmd.Flags().GetString("name")
 			servers, _ := cmd.Flags().GetString("servers")
 			if name == "" || servers == "" {
-				fmt.Println("Please provide --name and --servers")
+				fail("Please provide --name and --servers")
 				return
 			}
 			serverList := strings.Split(servers, ",")
-			err := config.AddProfile(name, serverList)
-			if err != nil {
-				fmt.Printf("Error adding profile: %v\n", err)
-			} else {
+			if err := config.AddProfile(name, serverList); err != nil {
+				fail("Error adding profile: %v", err)
+				return
+			}
+
+			if !machine() {
 				fmt.Printf("Profile '%s' added: %v\n", name, serverList)
+				return
 			}
+			p, ok := config.FindProfile(config.LoadProfilesDns(), name)
+			if !ok {
+				fail("Profile '%s' not found after adding it", name)
+				return
+			}
+			emit(output.AddProfileResult{Profile: output.NewProfileInfo(*p)})
 		},
 	}
 	addProfileCmd.Flags().StringP("name", "n", "", "Profile name")
@@ -345,109 +1112,157 @@ func main() {
 		Run: func(cmd *cobra.Command, args []string) {
 			repeat, _ := cmd.Flags().GetInt("repeat")
 			apply, _ := cmd.Flags().GetBool("apply")
-			iface, _ := cmd.Flags().GetString("iface")
+			rankFlag, _ := cmd.Flags().GetString("rank-by")
+			requireDNSSEC, _ := cmd.Flags().GetBool("require-dnssec")
 
 			if repeat <= 0 {
 				repeat = 5
 			}
 
-			if iface == "" {
-				interfaces, err := platformall.GetNetworkInterfaces()
-				if err != nil || len(interfaces) == 0 {
-					fmt.Println("No network interfaces detected.")
-					return
-				}
-
-				fmt.Println("Available network interfaces:")
-				for i, name := range interfaces {
-					fmt.Printf(" [%d] %s\n", i+1, name)
-				}
-
-				fmt.Print("Select interface number: ")
-				var choice int
-				fmt.Scanln(&choice)
+			rankBy, err := resolver.ParseRankMetric(rankFlag)
+			if err != nil {
+				fail("%v", err)
+				return
+			}
 
-				if choice < 1 || choice > len(interfaces) {
-					fmt.Println("Invalid choice")
+			var ifaces []string
+			if apply {
+				var ok bool
+				if ifaces, ok = selectInterfaces(cmd, true); !ok {
 					return
 				}
-
-				iface = interfaces[choice-1]
 			}
 
 			profiles := config.LoadProfilesDns()
 			if len(profiles) == 0 {
-				fmt.Println("No profiles found")
+				fail("No profiles found")
 				return
 			}
 
-			var bestProfile *config.Profile
-			bestAvg := time.Duration(1<<63 - 1)
+			ctx, cancel, opts, err := benchSetup(cmd, repeat, profiles)
+			defer cancel()
+			if err != nil {
+				fail("%v", err)
+				return
+			}
 
-			for _, p := range profiles {
-				fmt.Printf("Testing profile '%s'\n", p.Name)
+			results := resolver.BenchmarkProfiles(ctx, profiles, opts)
+			reports := make(map[string]*output.ProfileReport, len(results))
+			for _, pr := range results {
+				report := output.NewProfileReport(pr)
+				reports[pr.Profile.Name] = &report
+				if machine() {
+					continue
+				}
 
-				var total time.Duration
-				var count int
+				fmt.Printf("Testing profile '%s'\n", pr.Profile.Name)
 
-				for _, server := range p.Servers {
-					var sum time.Duration
-					var success int
+				for _, sr := range pr.Servers {
+					printServerResult(sr)
+				}
 
-					for i := 0; i < repeat; i++ {
-						r := resolver.MeasureRTT(server, DomainTesting, 2*time.Second)
-						if r.Error != nil {
-							fmt.Printf("%s -> error: %v\n", server, r.Error)
-							continue
-						}
-						fmt.Printf("%s -> RTT[%d]: %v\n", server, i+1, r.RTT)
-						sum += r.RTT
-						success++
-					}
+				if profileAvg, ok := pr.Average(); ok {
+					fmt.Printf("Profile '%s' average RTT: %v\n", pr.Profile.Name, profileAvg)
+				}
+				printProfileStats(pr)
+				fmt.Println()
+			}
 
-					if success > 0 {
-						avg := sum / time.Duration(success)
-						fmt.Printf("%s -> average RTT: %v\n", server, avg)
-						total += avg
-						count++
+			all := results
+			if requireDNSSEC {
+				var skipped map[string]string
+				results, skipped = validatingProfiles(ctx, results, opts)
+				for _, pr := range all {
+					if reason, ok := skipped[pr.Profile.Name]; ok {
+						reports[pr.Profile.Name].Skipped = reason
+						if !machine() {
+							fmt.Printf("Profile '%s' skipped: %s\n", pr.Profile.Name, reason)
+						}
 					}
 				}
+				if len(results) == 0 {
+					fail("No profile with DNSSEC validating servers found")
+					return
+				}
+			}
 
-				if count > 0 {
-					profileAvg := total / time.Duration(count)
-					fmt.Printf("Profile '%s' average RTT: %v\n\n", p.Name, profileAvg)
+			ranked := resolver.RankProfiles(results, rankBy)
+			if len(ranked) == 0 {
+				fail("No valid servers found")
+				return
+			}
 
-					if profileAvg < bestAvg {
-						bestAvg = profileAvg
-						bestProfile = &p
+			res := output.AutoResult{RankBy: rankBy, Best: ranked[0].Profile.Name}
+			for i, r := range ranked {
+				report := reports[r.Profile.Name]
+				report.Rank, report.ScoreNS = i+1, r.Score.Nanoseconds()
+				res.Profiles = append(res.Profiles, *report)
+			}
+			for _, pr := range all {
+				if report := reports[pr.Profile.Name]; report.Rank == 0 {
+					if report.Skipped == "" {
+						report.Skipped = "no successful samples"
 					}
+					res.Profiles = append(res.Profiles, *report)
 				}
 			}
 
-			if bestProfile == nil {
-				fmt.Println("No valid servers found")
-				return
+			if !machine() {
+				fmt.Printf("Ranking by %s:\n", rankBy)
+				for i, r := range ranked {
+					fmt.Printf(" %d. %s (%v)\n", i+1, r.Profile.Name, r.Score)
+				}
 			}
+			best := ranked[0]
 
 			if apply {
-
-				bestProfile.Interface = iface
-				res, err := platformall.ApplyProfile(*bestProfile)
-				if err != nil {
-					fmt.Printf("Error applying profile: %v\n", err)
-					return
+				for _, iface := range ifaces {
+					best.Profile.Interface = iface
+					applied, err := platformall.ApplyProfile(best.Profile)
+					if err != nil {
+						entry := output.InterfaceApply{Interface: iface, RolledBack: applied.RolledBack,
+							Message: fmt.Sprintf("Error applying profile: %v", err), Steps: applied.Steps}
+						res.Interfaces = append(res.Interfaces, entry)
+						if !machine() {
+							printSteps(applied.Steps)
+							fmt.Println(entry.Message)
+						}
+						rollbackApplied(res.Interfaces)
+						res.Applied = false
+						if machine() {
+							emit(res)
+						}
+						os.Exit(1)
+					}
+					res.Applied = true
+					res.Interfaces = append(res.Interfaces, output.InterfaceApply{Interface: iface, Applied: true,
+						Snapshot: applied.Snapshot, Message: applied.Message, Steps: applied.Steps})
+					if !machine() {
+						fmt.Println(applied.Message)
+						fmt.Printf("Applied fastest profile '%s' with %s %v on interface '%s'\n", best.Profile.Name, rankBy, best.Score, iface)
+					}
 				}
-				fmt.Println(res.Message)
-				fmt.Printf("Applied fastest profile '%s' with average RTT %v on interface '%s'\n", bestProfile.Name, bestAvg, iface)
-			} else {
-				fmt.Printf("Fastest profile is '%s' with average RTT %v (not applied)\n", bestProfile.Name, bestAvg)
+			} else if !machine() {
+				fmt.Printf("Fastest profile is '%s' with %s %v (not applied)\n", best.Profile.Name, rankBy, best.Score)
+			}
+
+			if machine() {
+				emit(res)
 			}
 		},
 	}
 
 	autoCmd.Flags().IntP("repeat", "r", 5, "Number of times to test each server")
 	autoCmd.Flags().BoolP("apply", "a", false, "Apply fastest profile automatically")
-	autoCmd.Flags().StringP("iface", "i", "", "Select network interface")
+	addInterfaceFlags(autoCmd)
+	autoCmd.Flags().String("doh-method", "GET", "HTTP method for DNS-over-HTTPS upstreams (GET or POST)")
+	autoCmd.Flags().IntP("concurrency", "c", 8, "Number of probes run in parallel")
+	autoCmd.Flags().Duration("deadline", 0, "Stop the whole benchmark after this duration (e.g. 10s, 0 = no limit)")
+	autoCmd.Flags().String("suite", "", "Benchmark suite to query every server with (default: the profile's suite or the built-in suite)")
+	autoCmd.Flags().String("cache-bust-zone", "", "Also query random names under this zone to measure uncached latency (e.g. example.com)")
+	autoCmd.Flags().Bool("require-dnssec", false, "Only consider profiles whose servers all validate DNSSEC")
+	autoCmd.Flags().String("rank-by", string(resolver.RankLossWeighted),
+		fmt.Sprintf("Metric profiles are ranked by: %v", resolver.RankMetrics))
 
 	// Delete-profile Command
 	var deleteProfileCmd = &cobra.Command{
@@ -458,99 +1273,567 @@ func main() {
 
 			yes, _ := cmd.Flags().GetBool("yes")
 			force, _ := cmd.Flags().GetBool("force")
-			jsonOut, _ := cmd.Flags().GetBool("json")
 			quiet, _ := cmd.Flags().GetBool("quiet")
-			iface, _ := cmd.Flags().GetString("iface")
-
-			if iface == "" {
-				interfaces, err := platformall.GetNetworkInterfaces()
-				if err != nil || len(interfaces) == 0 {
-					fmt.Println("No network interfaces detected.")
+			spec, _ := cmd.Flags().GetString("iface")
+			all, _ := cmd.Flags().GetBool("all-interfaces")
+
+			// Without an interface given, nothing is prompted for when it
+			// cannot be answered or would not change the outcome: the
+			// profile is checked against every active interface. With
+			// --force a failure to list them does not stop the deletion.
+			var ifaces []string
+			if spec == "" && !all && (force || appSettings.NonInteractive) {
+				var err error
+				ifaces, err = platformall.ResolveInterfaces(platformall.InterfaceSelection{All: true})
+				if err != nil && !force {
+					fail("%v", err)
 					return
 				}
-
-				fmt.Println("Available network interfaces:")
-				for i, name := range interfaces {
-					fmt.Printf(" [%d] %s\n", i+1, name)
-				}
-
-				fmt.Print("Select interface number: ")
-				var choice int
-				fmt.Scanln(&choice)
-
-				if choice < 1 || choice > len(interfaces) {
-					fmt.Println("Invalid choice")
+			} else {
+				var ok bool
+				if ifaces, ok = selectInterfaces(cmd, false); !ok {
 					return
 				}
-
-				iface = interfaces[choice-1]
 			}
 
+			res := output.DeleteResult{Profiles: []output.DeleteEntry{}}
 			for _, name := range args {
+				entry := output.DeleteEntry{Name: name, Forced: force}
+				res.Profiles = append(res.Profiles, entry)
+				last := &res.Profiles[len(res.Profiles)-1]
 
 				profiles := config.LoadProfilesDns()
 				p, ok := config.FindProfile(profiles, name)
 				if !ok {
-					fmt.Printf("Profile '%s' not found\n", name)
+					last.Reason = "not found"
+					if !machine() {
+						fmt.Printf("Profile '%s' not found\n", name)
+					}
 					continue
 				}
 
-				current, err := platformall.GetCurrentDNS(iface)
-				normalized := normalizeDNS(current)
-				isActive := err == nil && len(normalized) > 0 && equalDNS(normalized, p.Servers)
+				for _, iface := range ifaces {
+					current, err := platformall.GetCurrentDNS(iface)
+					normalized := normalizeDNS(current)
+					if err == nil && len(normalized) > 0 && equalDNS(normalized, p.AllServers()) {
+						last.Active = true
+					}
+				}
 
-				if isActive && !force {
-					fmt.Printf("Profile '%s' is currently active. Use --force to delete.\n", name)
+				if last.Active && !force {
+					last.Reason = "active"
+					if !machine() {
+						fmt.Printf("Profile '%s' is currently active. Use --force to delete.\n", name)
+					}
 					continue
 				}
 
+				if !yes && appSettings.NonInteractive {
+					last.Reason = "confirmation required"
+					if !machine() {
+						fmt.Printf("Not deleting '%s' without confirmation. Use --yes.\n", name)
+					}
+					continue
+				}
 				if !yes {
-					fmt.Printf("Are you sure you want to delete profile '%s'? [y/N]: ", name)
+					fmt.Fprintf(prompt, "Are you sure you want to delete profile '%s'? [y/N]: ", name)
 					var reply string
 					fmt.Scanln(&reply)
 					reply = strings.ToLower(strings.TrimSpace(reply))
 					if reply != "y" && reply != "yes" {
-						fmt.Printf("Aborted deletion of '%s'.\n", name)
+						last.Reason = "aborted"
+						if !machine() {
+							fmt.Printf("Aborted deletion of '%s'.\n", name)
+						}
 						continue
 					}
 				}
 
 				if err := config.DeleteProfile(name); err != nil {
-					fmt.Printf("Error deleting profile '%s': %v\n", name, err)
-					continue
-				}
-
-				if jsonOut {
-					payload := map[string]interface{}{
-						"deleted": true,
-						"name":    name,
-						"forced":  force,
-						"active":  isActive,
+					last.Reason = err.Error()
+					if !machine() {
+						fmt.Printf("Error deleting profile '%s': %v\n", name, err)
 					}
-					b, _ := json.MarshalIndent(payload, "", "  ")
-					fmt.Println(string(b))
 					continue
 				}
+				last.Deleted = true
 
-				if !quiet {
+				if !machine() && !quiet {
 					fmt.Printf("Profile '%s' deleted\n", name)
 				}
 			}
+
+			if legacyJSON {
+				for _, e := range res.Profiles {
+					if e.Deleted {
+						emitLegacy(map[string]interface{}{"deleted": true, "name": e.Name, "forced": e.Forced, "active": e.Active})
+					}
+				}
+			} else if machine() {
+				emit(res)
+			}
 		},
 	}
 
 	deleteProfileCmd.Flags().BoolP("yes", "y", false, "Skip confirmation prompt")
 	deleteProfileCmd.Flags().BoolP("force", "f", false, "Delete even if profile is active")
-	deleteProfileCmd.Flags().BoolP("json", "j", false, "Output result in JSON")
+	deleteProfileCmd.Flags().BoolP("json", "j", false, "Output one JSON object per deleted profile (--output json for the full result)")
 	deleteProfileCmd.Flags().BoolP("quiet", "q", false, "Suppress success message")
-	deleteProfileCmd.Flags().StringP("iface", "i", "", "Select network interface")
+	addInterfaceFlags(deleteProfileCmd)
+
+	// Interfaces Command
+	var interfacesCmd = &cobra.Command{
+		Use:   "interfaces",
+		Short: "List network interfaces with their addresses and DNS configuration",
+		Run: func(cmd *cobra.Command, args []string) {
+			infos, err := platformall.ListInterfaces()
+			if err != nil {
+				fail("Error listing interfaces: %v", err)
+				return
+			}
+			if machine() {
+				emit(output.InterfacesResult{Interfaces: infos})
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tINDEX\tSTATE\tTYPE\tMAC\tADDRESSES\tDNS")
+			for _, i := range infos {
+				state := i.State
+				if i.AdminState == platformall.AdminDisabled {
+					state = platformall.AdminDisabled
+				}
+				addrs := strings.Join(append(append([]string{}, i.IPv4...), i.IPv6...), " ")
+				dns := strings.Join(i.DNS, " ")
+				if i.DNSConfig != nil {
+					dns = i.DNSConfig.String()
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", i.Name, i.Index, state, i.Type,
+					dashIfEmpty(i.MAC), dashIfEmpty(addrs), dashIfEmpty(dns))
+			}
+			w.Flush()
+		},
+	}
+	interfacesCmd.Flags().BoolP("json", "j", false, "Output interfaces in JSON format (same as --output json)")
+
+	// Verify Command
+	var verifyCmd = &cobra.Command{
+		Use:   "verify [profile...]",
+		Short: "Detect DNS poisoning and hijacking by comparing answers with a trusted reference",
+		Run: func(cmd *cobra.Command, args []string) {
+			refName, _ := cmd.Flags().GetString("reference")
+			suiteName, _ := cmd.Flags().GetString("suite")
+			nxZone, _ := cmd.Flags().GetString("nx-zone")
+			window, _ := cmd.Flags().GetDuration("injection-window")
+			dohMethod, _ := cmd.Flags().GetString("doh-method")
+			export, _ := cmd.Flags().GetString("export")
+			minDisagreements, _ := cmd.Flags().GetInt("min-disagreements")
+
+			profiles := config.LoadProfilesDns()
+
+			var reference resolver.Target
+			if p, ok := config.FindProfile(profiles, refName); ok {
+				t, ok := resolver.ReferenceTarget(*p)
+				if !ok {
+					fail("Reference profile '%s' has no servers", refName)
+					return
+				}
+				reference = t
+			} else {
+				t, err := resolver.ParseTarget(refName)
+				if err != nil {
+					fail("Invalid reference '%s': %v", refName, err)
+					return
+				}
+				reference = t
+			}
+
+			suites, err := config.LoadSuites()
+			if err != nil {
+				fail("%v", err)
+				return
+			}
+			suite, err := config.FindSuite(suites, suiteName)
+			if err != nil {
+				fail("%v", err)
+				return
+			}
+			queries, err := resolver.SuiteQueries(suite)
+			if err != nil {
+				fail("%v", err)
+				return
+			}
+
+			var targets []resolver.Target
+			if len(args) == 0 {
+				for _, p := range profiles {
+					if p.Name != refName {
+						targets = append(targets, resolver.TargetsOf(p)...)
+					}
+				}
+			}
+			for _, name := range args {
+				p, ok := config.FindProfile(profiles, name)
+				if !ok {
+					fail("Profile '%s' not found", name)
+					return
+				}
+				targets = append(targets, resolver.TargetsOf(*p)...)
+			}
+			if len(targets) == 0 {
+				fail("No profiles found")
+				return
+			}
+
+			results := resolver.Verify(context.Background(), targets, resolver.VerifyOptions{
+				Queries:          queries,
+				Reference:        reference,
+				Timeout:          2 * time.Second,
+				DoHMethod:        dohMethod,
+				NXZone:           nxZone,
+				InjectionWindow:  window,
+				MinDisagreements: minDisagreements,
+			})
+			report := output.NewVerifyReport(reference, results)
+
+			if export != "" {
+				f, err := os.Create(export)
+				if err == nil {
+					err = output.Write(f, output.JSON, report)
+					if cerr := f.Close(); err == nil {
+						err = cerr
+					}
+				}
+				if err != nil {
+					fail("Error exporting results: %v", err)
+					return
+				}
+			}
+			if machine() {
+				emit(report)
+				return
+			}
+
+			fmt.Printf("Reference: %s\n", reference)
+			for _, r := range results {
+				line := fmt.Sprintf("%s -> %s [%s] %s", r.Result.Server, r.Result.Query, r.Check, r.Verdict)
+				if len(r.Answers) > 0 || len(r.Reference) > 0 {
+					line += fmt.Sprintf(" %v", r.Answers)
+					if len(r.Reference) > 0 {
+						line += fmt.Sprintf(" vs %v", r.Reference)
+					}
+				}
+				if r.Detail != "" {
+					line += " (" + r.Detail + ")"
+				}
+				fmt.Println(line)
+			}
+
+			if report.Suspicious == 0 {
+				fmt.Println("No tampering detected")
+			} else {
+				fmt.Printf("%d suspicious result(s) found\n", report.Suspicious)
+			}
+			if report.Incomplete > 0 {
+				fmt.Printf("%d check(s) could not be completed\n", report.Incomplete)
+			}
+		},
+	}
+	verifyCmd.Flags().String("reference", "https://cloudflare-dns.com/dns-query", "Trusted reference: a profile name, server, DoH URL or tls:// server")
+	verifyCmd.Flags().String("suite", config.CanarySuiteName, "Suite of canary domains to compare")
+	verifyCmd.Flags().String("nx-zone", "example.com", "Zone for random non-existent names checking NXDOMAIN rewriting (empty to skip)")
+	verifyCmd.Flags().Duration("injection-window", 300*time.Millisecond, "How long to wait for a second UDP response revealing injection (0 to skip)")
+	verifyCmd.Flags().Int("min-disagreements", resolver.DefaultMinDisagreements, "Canaries of a server that must be mismatched or blocked before they count as suspicious")
+	verifyCmd.Flags().String("doh-method", "GET", "HTTP method for DNS-over-HTTPS upstreams (GET or POST)")
+	verifyCmd.Flags().BoolP("json", "j", false, "Output results in JSON format (same as --output json)")
+	verifyCmd.Flags().String("export", "", "Also write the results as JSON to this file")
+
+	// Daemon Command
+	var daemonCmd = &cobra.Command{
+		Use:   "daemon",
+		Short: "Keep the fastest healthy profile applied, benchmarking periodically",
+		Run: func(cmd *cobra.Command, args []string) {
+			repeat, _ := cmd.Flags().GetInt("repeat")
+			interval, _ := cmd.Flags().GetDuration("interval")
+			checkInterval, _ := cmd.Flags().GetDuration("check-interval")
+			margin, _ := cmd.Flags().GetFloat64("margin")
+			rounds, _ := cmd.Flags().GetInt("rounds")
+			rankFlag, _ := cmd.Flags().GetString("rank-by")
+			deadline, _ := cmd.Flags().GetDuration("deadline")
+
+			if outputFormat != output.Text && outputFormat != output.JSON {
+				fail("daemon logs decisions as text or JSON lines, not %s", outputFormat)
+				return
+			}
+			if interval <= 0 || margin < 0 || margin >= 1 {
+				fail("--interval must be positive and --margin between 0 and 1")
+				return
+			}
+			rankBy, err := resolver.ParseRankMetric(rankFlag)
+			if err != nil {
+				fail("%v", err)
+				return
+			}
+
+			ifaces, ok := selectInterfaces(cmd, true)
+			if !ok {
+				return
+			}
+			profiles := config.LoadProfilesDns()
+			if len(profiles) == 0 {
+				fail("No profiles found")
+				return
+			}
+
+			// The deadline bounds every round rather than the whole run
+			_, cancel, opts, err := benchSetup(cmd, repeat, profiles)
+			cancel()
+			if err != nil {
+				fail("%v", err)
+				return
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			d := daemon.New(daemon.Options{
+				Profiles:      profiles,
+				Interfaces:    ifaces,
+				Interval:      interval,
+				CheckInterval: checkInterval,
+				Margin:        margin,
+				Rounds:        rounds,
+				Metric:        rankBy,
+				Bench:         opts,
+				Deadline:      deadline,
+				Log: func(dec daemon.Decision) {
+					if machine() {
+						enc.Encode(dec)
+						return
+					}
+					fmt.Println(dec)
+				},
+			})
+			if !machine() {
+				fmt.Printf("Watching %d profiles on %s every %v, active profile: '%s' (Ctrl+C to stop)\n",
+					len(profiles), strings.Join(ifaces, ", "), interval, d.Active())
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			d.Run(ctx)
+		},
+	}
+	daemonCmd.Flags().Duration("interval", 5*time.Minute, "Time between benchmark rounds")
+	daemonCmd.Flags().Duration("check-interval", 30*time.Second, "How often the active servers are checked between rounds (0 to disable)")
+	daemonCmd.Flags().Float64("margin", 0.1, "Fraction a profile must be faster than the active one by to replace it (e.g. 0.1 = 10%)")
+	daemonCmd.Flags().Int("rounds", 3, "Consecutive rounds a profile must stay faster by the margin before it is applied")
+	daemonCmd.Flags().IntP("repeat", "r", 3, "Number of times to test each server per round")
+	addInterfaceFlags(daemonCmd)
+	daemonCmd.Flags().String("doh-method", "GET", "HTTP method for DNS-over-HTTPS upstreams (GET or POST)")
+	daemonCmd.Flags().IntP("concurrency", "c", 8, "Number of probes run in parallel")
+	daemonCmd.Flags().Duration("deadline", 0, "Stop the benchmark of a round after this duration (e.g. 10s, 0 = no limit)")
+	daemonCmd.Flags().String("suite", "", "Benchmark suite to query every server with (default: the profile's suite or the built-in suite)")
+	daemonCmd.Flags().String("cache-bust-zone", "", "Also query random names under this zone to measure uncached latency (e.g. example.com)")
+	daemonCmd.Flags().String("rank-by", string(resolver.RankLossWeighted),
+		fmt.Sprintf("Metric profiles are ranked by: %v", resolver.RankMetrics))
+
+	// Serve Command
+	var serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Run a local DNS proxy forwarding to a profile and point the interfaces at it",
+		Run: func(cmd *cobra.Command, args []string) {
+			name, _ := cmd.Flags().GetString("profile")
+			listen, _ := cmd.Flags().GetString("listen")
+			race, _ := cmd.Flags().GetInt("race")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			maxFails, _ := cmd.Flags().GetInt("max-fails")
+			cooldown, _ := cmd.Flags().GetDuration("cooldown")
+			dohMethod, _ := cmd.Flags().GetString("doh-method")
+			noApply, _ := cmd.Flags().GetBool("no-apply")
+			cacheSize, _ := cmd.Flags().GetInt("cache-size")
+			minTTL, _ := cmd.Flags().GetDuration("min-ttl")
+			maxTTL, _ := cmd.Flags().GetDuration("max-ttl")
+			maxNegativeTTL, _ := cmd.Flags().GetDuration("max-negative-ttl")
+			prefetch, _ := cmd.Flags().GetBool("prefetch")
+
+			if outputFormat != output.Text && outputFormat != output.JSON {
+				fail("serve logs upstream events as text or JSON lines, not %s", outputFormat)
+				return
+			}
+			host, port, err := net.SplitHostPort(listen)
+			if err != nil {
+				fail("Invalid --listen address: %v", err)
+				return
+			}
+			if port != "53" && !noApply {
+				fail("The operating system only sends queries to port 53; use --no-apply to run the proxy on %s", listen)
+				return
+			}
+			server, err := proxyServer(host)
+			if err != nil && !noApply {
+				fail("Invalid --listen address: %v", err)
+				return
+			}
+
+			p, ok := config.FindProfile(config.LoadProfilesDns(), name)
+			if !ok {
+				fail("Profile '%s' not found", name)
+				return
+			}
+
+			var ifaces []string
+			if !noApply {
+				if ifaces, ok = selectInterfaces(cmd, true); !ok {
+					return
+				}
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			px, err := proxy.New(proxy.Options{
+				Profile:   *p,
+				Listen:    listen,
+				Timeout:   timeout,
+				Race:      race,
+				DoHMethod: dohMethod,
+				MaxFails:  maxFails,
+				Cooldown:  cooldown,
+				Cache: proxy.CacheOptions{
+					Size:           cacheSize,
+					MinTTL:         minTTL,
+					MaxTTL:         maxTTL,
+					MaxNegativeTTL: maxNegativeTTL,
+					Prefetch:       prefetch,
+				},
+				Log: func(e proxy.Event) {
+					if machine() {
+						enc.Encode(e)
+						return
+					}
+					fmt.Println(e)
+				},
+			})
+			if err != nil {
+				fail("%v", err)
+				return
+			}
+			if err := px.Start(); err != nil {
+				fail("Cannot listen on %s: %v", listen, err)
+				return
+			}
+			defer px.Shutdown()
+
+			// point the interfaces at the proxy, restoring them on the way out.
+			// The applies are pending until a deadline renewed while serving,
+			// so the next run restores them if this process is killed.
+			var snapshots []int
+			deadline := func() time.Time { return time.Now().Add(3 * proxy.StateInterval) }
+			restore := func() {
+				for i := len(snapshots) - 1; i >= 0; i-- {
+					res, err := platformall.RollbackTo(snapshots[i])
+					if err != nil {
+						fmt.Fprintf(prompt, "Error restoring DNS settings: %v\n", err)
+						continue
+					}
+					fmt.Fprintln(prompt, res.Message)
+				}
+				if err := platformall.RemovePending(snapshots); err != nil {
+					fmt.Fprintln(prompt, err)
+				}
+			}
+			for _, iface := range ifaces {
+				res, err := platformall.ApplyProfile(config.Profile{Name: p.Name, Servers: []string{server}, Interface: iface})
+				if err == nil {
+					err = platformall.AddPending(platformall.PendingApply{Interface: iface, Profile: p.Name, Snapshot: res.Snapshot, Deadline: deadline(), Owner: "serve"})
+					if err != nil {
+						platformall.RollbackTo(res.Snapshot)
+					}
+				}
+				if err != nil {
+					restore()
+					fail("Error pointing '%s' at the proxy: %v", iface, err)
+					return
+				}
+				snapshots = append(snapshots, res.Snapshot)
+			}
+
+			fmt.Fprintf(prompt, "Forwarding DNS queries on %s to profile '%s' (%s)\n", px.Addr(), p.Name, strings.Join(p.Upstreams(), ", "))
+			if len(ifaces) > 0 {
+				fmt.Fprintf(prompt, "Interfaces using the proxy: %s (Ctrl+C to stop and restore them)\n", strings.Join(ifaces, ", "))
+			}
+
+			// publish the state for status and renew the pending applies,
+			// reporting the first failure only
+			started, reported := time.Now(), false
+			report := func(err error) {
+				if err != nil && !reported {
+					reported = true
+					fmt.Fprintln(prompt, err)
+				}
+			}
+			publish := func() {
+				report(proxy.WriteState(px.State(started)))
+				if len(snapshots) > 0 {
+					report(platformall.RenewPending(snapshots, deadline()))
+				}
+			}
+			publish()
+			defer proxy.RemoveState()
+			tick := time.NewTicker(proxy.StateInterval)
+			defer tick.Stop()
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			reload := make(chan os.Signal, 1)
+			signal.Notify(reload, syscall.SIGHUP)
+			defer signal.Stop(reload)
+			for ctx.Err() == nil {
+				select {
+				case <-ctx.Done():
+				case <-tick.C:
+					publish()
+				case <-reload:
+					// the interfaces keep pointing at the proxy, only the upstreams change
+					if p, ok := config.FindProfile(config.LoadProfilesDns(), name); !ok {
+						fmt.Fprintf(prompt, "Profile '%s' not found, keeping the upstreams\n", name)
+					} else if err := px.SetProfile(*p); err != nil {
+						fmt.Fprintf(prompt, "%v, keeping the upstreams\n", err)
+					} else {
+						fmt.Fprintf(prompt, "Reloaded profile '%s' (%s)\n", p.Name, strings.Join(p.Upstreams(), ", "))
+					}
+					publish()
+				}
+			}
+			if stats, ok := px.CacheStats(); ok {
+				fmt.Fprintf(prompt, "Cache: %s\n", stats)
+			}
+			restore()
+		},
+	}
+	serveCmd.Flags().StringP("profile", "p", "", "Profile whose servers queries are forwarded to")
+	serveCmd.MarkFlagRequired("profile")
+	serveCmd.Flags().String("listen", proxy.DefaultListen, "Address the proxy listens on over UDP and TCP")
+	serveCmd.Flags().Int("race", 2, "Number of upstreams asked at once for every query")
+	serveCmd.Flags().Duration("timeout", 2*time.Second, "Time to wait for an upstream before asking the next one")
+	serveCmd.Flags().Int("max-fails", 3, "Consecutive failures after which an upstream is asked last")
+	serveCmd.Flags().Duration("cooldown", 30*time.Second, "How long an upstream that failed --max-fails times is asked last")
+	serveCmd.Flags().String("doh-method", "GET", "HTTP method for DNS-over-HTTPS upstreams (GET or POST)")
+	serveCmd.Flags().Int("cache-size", 10000, "Number of answers the proxy caches (0 disables the cache)")
+	serveCmd.Flags().Duration("min-ttl", 0, "Cache answers at least this long, whatever their TTL")
+	serveCmd.Flags().Duration("max-ttl", 24*time.Hour, "Cache answers at most this long")
+	serveCmd.Flags().Duration("max-negative-ttl", time.Hour, "Cache NXDOMAIN and empty answers at most this long")
+	serveCmd.Flags().Bool("prefetch", false, "Refresh cached answers asked for repeatedly before they expire")
+	serveCmd.Flags().Bool("no-apply", false, "Only run the proxy, leaving the DNS settings of the interfaces alone")
+	addInterfaceFlags(serveCmd)
 
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
-	rootCmd.AddCommand(listCmd, testCmd, applyCmd, statusCmd, rollbackCmd, addProfileCmd, autoCmd, deleteProfileCmd)
+	rootCmd.AddCommand(listCmd, testCmd, applyCmd, confirmCmd, statusCmd, rollbackCmd, addProfileCmd, autoCmd, deleteProfileCmd, interfacesCmd, verifyCmd, daemonCmd, serveCmd)
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		if machine() {
+			emit(output.ErrorResult{Error: err.Error()})
+		} else {
+			fmt.Println(err)
+		}
 		os.Exit(1)
 	}
 }
@@ -3,31 +3,46 @@ package config
 import (
 	"errors"
 	"fmt"
-	"log"
+	"maps"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
+// Path is the profiles.yaml file. It is set by the command layer from the
+// settings (see package settings); nothing is loaded while it is empty.
 var Path string
 
-// init: initializes the configuration path for DNS profiles
-// Reads from environment variable or defaults to "profiles.yaml"
-func init() {
-	Path = os.Getenv("DNS_SWITCHER_PATH")
-
-	if Path == "" {
-		log.Fatal("Environment variable DNS_SWITCHER_PATH is not set. Please set it before running.")
-		return
-	}
-}
-
 type Profile struct {
 	Name      string
 	Servers   []string
+	IPv6      []string
+	DoH       []string
+	DoT       []DoTServer
+	Suite     string // benchmark suite, see LoadSuites
 	Interface string
 }
 
+// AllServers returns the IPv4 servers followed by the IPv6 servers
+func (p Profile) AllServers() []string {
+	out := make([]string, 0, len(p.Servers)+len(p.IPv6))
+	out = append(out, p.Servers...)
+	return append(out, p.IPv6...)
+}
+
+// Upstreams returns every upstream that can be benchmarked: the plain
+// servers followed by the DNS-over-HTTPS URLs and the DNS-over-TLS servers
+func (p Profile) Upstreams() []string {
+	out := append(p.AllServers(), p.DoH...)
+	for _, d := range p.DoT {
+		out = append(out, d.String())
+	}
+	return out
+}
+
 // LoadProfilesDns reads profiles from profiles.yaml and returns a slice of Profile
 func LoadProfilesDns() []Profile {
 	var out []Profile
@@ -62,16 +77,27 @@ func LoadProfilesDns() []Profile {
 
 		// ipv4
 		if ipsRaw, exists := vv["ipv4"]; exists {
-			switch ips := ipsRaw.(type) {
-			case []interface{}:
-				for _, ip := range ips {
-					if s, ok := ip.(string); ok {
-						p.Servers = append(p.Servers, s)
-					}
-				}
-			case []string:
-				p.Servers = append(p.Servers, ips...)
-			}
+			p.Servers = append(p.Servers, stringList(ipsRaw)...)
+		}
+
+		// ipv6
+		if ipsRaw, exists := vv["ipv6"]; exists {
+			p.IPv6 = append(p.IPv6, stringList(ipsRaw)...)
+		}
+
+		// doh: a single URL or a list of URLs
+		if urlsRaw, exists := vv["doh"]; exists {
+			p.DoH = append(p.DoH, stringList(urlsRaw)...)
+		}
+
+		// dot: a single entry or a list of entries
+		if dotRaw, exists := vv["dot"]; exists {
+			p.DoT = append(p.DoT, dotList(dotRaw)...)
+		}
+
+		// suite: name of the benchmark suite for this profile
+		if suite, ok := vv["suite"].(string); ok {
+			p.Suite = suite
 		}
 
 		out = append(out, p)
@@ -80,6 +106,43 @@ func LoadProfilesDns() []Profile {
 	return out
 }
 
+// stringList converts a YAML list (or single string) value into a slice of strings
+func stringList(raw interface{}) []string {
+	var out []string
+	switch items := raw.(type) {
+	case string:
+		out = append(out, items)
+	case []interface{}:
+		for _, item := range items {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+	case []string:
+		out = append(out, items...)
+	}
+	return out
+}
+
+// SplitFamilies separates IPv4 addresses, IPv6 addresses, DNS-over-HTTPS
+// URLs and tls:// DNS-over-TLS servers, keeping their order.
+// Other entries are treated as IPv4.
+func SplitFamilies(servers []string) (v4, v6, doh, dot []string) {
+	for _, s := range servers {
+		s = strings.TrimSpace(s)
+		if strings.HasPrefix(s, "https://") {
+			doh = append(doh, s)
+		} else if strings.HasPrefix(s, "tls://") {
+			dot = append(dot, s)
+		} else if ip := net.ParseIP(s); ip != nil && ip.To4() == nil {
+			v6 = append(v6, s)
+		} else {
+			v4 = append(v4, s)
+		}
+	}
+	return v4, v6, doh, dot
+}
+
 // FindProfile searches for a profile by name in the slice
 func FindProfile(profiles []Profile, name string) (*Profile, bool) {
 	for i := range profiles {
@@ -92,6 +155,10 @@ func FindProfile(profiles []Profile, name string) (*Profile, bool) {
 
 // AddProfile adds a new profile to YAML
 // AddProfile adds a new DNS profile to profiles.yaml
+// IPv6 addresses in servers are stored under the profile's ipv6 key,
+// https:// URLs under its doh key and tls:// servers under its dot key.
+// Adding an existing profile replaces its servers and keeps its other
+// keys, such as suite.
 func AddProfile(name string, servers []string) error {
 	if Path == "" {
 		return fmt.Errorf("config path not set")
@@ -117,13 +184,41 @@ func AddProfile(name string, servers []string) error {
 	}
 
 	// Add or overwrite profile
-	profiles[name] = map[string]interface{}{
-		"ipv4": servers,
+	v4, v6, doh, dot := SplitFamilies(servers)
+	var dotEntries []interface{}
+	for _, s := range dot {
+		d, err := ParseDoT(s)
+		if err != nil {
+			return err
+		}
+		dotEntries = append(dotEntries, dotEntry(d))
 	}
 
+	entry := map[string]interface{}{}
+	if existing, ok := profiles[name].(map[string]interface{}); ok {
+		maps.Copy(entry, existing)
+		delete(entry, "ipv6")
+		delete(entry, "doh")
+		delete(entry, "dot")
+	}
+	entry["ipv4"] = v4
+	if len(v6) > 0 {
+		entry["ipv6"] = v6
+	}
+	if len(doh) > 0 {
+		entry["doh"] = doh
+	}
+	if len(dotEntries) > 0 {
+		entry["dot"] = dotEntries
+	}
+	profiles[name] = entry
+
 	viper.Set("profiles", profiles)
 
 	// Save config properly
+	if err := os.MkdirAll(filepath.Dir(Path), 0o755); err != nil {
+		return fmt.Errorf("cannot create config directory: %v", err)
+	}
 	if err := viper.WriteConfig(); err != nil {
 		if err := viper.SafeWriteConfigAs(Path); err != nil {
 			return fmt.Errorf("cannot write config: %v", err)
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -68,3 +69,162 @@ func TestDeleteProfile(t *testing.T) {
 		t.Fatal("Profile still found after deletion")
 	}
 }
+
+// TestAddProfileIPv6: verifies IPv6 servers are stored and loaded separately from IPv4
+func TestAddProfileIPv6(t *testing.T) {
+	setupTestConfig(t)
+
+	if err := AddProfile("cf", []string{"1.1.1.1", "2606:4700:4700::1111"}); err != nil {
+		t.Fatalf("AddProfile failed: %v", err)
+	}
+
+	p, ok := FindProfile(LoadProfilesDns(), "cf")
+	if !ok {
+		t.Fatal("Profile not found after adding")
+	}
+
+	if len(p.Servers) != 1 || p.Servers[0] != "1.1.1.1" {
+		t.Fatalf("unexpected IPv4 servers: %v", p.Servers)
+	}
+	if len(p.IPv6) != 1 || p.IPv6[0] != "2606:4700:4700::1111" {
+		t.Fatalf("unexpected IPv6 servers: %v", p.IPv6)
+	}
+	if len(p.AllServers()) != 2 {
+		t.Fatalf("expected 2 servers in total, got %v", p.AllServers())
+	}
+}
+
+// TestAddProfileDoH: verifies DoH URLs are stored under the doh key and are benchmarkable upstreams
+func TestAddProfileDoH(t *testing.T) {
+	setupTestConfig(t)
+
+	if err := AddProfile("cf", []string{"1.1.1.1", "https://cloudflare-dns.com/dns-query"}); err != nil {
+		t.Fatalf("AddProfile failed: %v", err)
+	}
+
+	p, ok := FindProfile(LoadProfilesDns(), "cf")
+	if !ok {
+		t.Fatal("Profile not found after adding")
+	}
+
+	if len(p.DoH) != 1 || p.DoH[0] != "https://cloudflare-dns.com/dns-query" {
+		t.Fatalf("unexpected DoH upstreams: %v", p.DoH)
+	}
+	if len(p.AllServers()) != 1 || len(p.Upstreams()) != 2 {
+		t.Fatalf("DoH must only be part of Upstreams: %v / %v", p.AllServers(), p.Upstreams())
+	}
+}
+
+// TestAddProfileDoT: verifies tls:// servers round-trip through the dot key
+func TestAddProfileDoT(t *testing.T) {
+	setupTestConfig(t)
+
+	if err := AddProfile("cf", []string{"tls://1.1.1.1#cloudflare-dns.com", "tls://[2606:4700:4700::1111]:8853"}); err != nil {
+		t.Fatalf("AddProfile failed: %v", err)
+	}
+
+	p, ok := FindProfile(LoadProfilesDns(), "cf")
+	if !ok {
+		t.Fatal("Profile not found after adding")
+	}
+
+	if len(p.DoT) != 2 {
+		t.Fatalf("expected 2 DoT servers, got %+v", p.DoT)
+	}
+	if p.DoT[0] != (DoTServer{Host: "1.1.1.1", Port: 853, AuthName: "cloudflare-dns.com"}) {
+		t.Fatalf("unexpected DoT server: %+v", p.DoT[0])
+	}
+	if p.DoT[1].Address() != "[2606:4700:4700::1111]:8853" {
+		t.Fatalf("unexpected DoT address: %s", p.DoT[1].Address())
+	}
+}
+
+// TestAddProfileReplace: verifies re-adding a profile replaces its servers
+// and keeps its other keys
+func TestAddProfileReplace(t *testing.T) {
+	path := setupTestConfig(t)
+	content := "profiles:\n  cf:\n    ipv4: [1.1.1.1]\n    ipv6: [\"2606:4700:4700::1111\"]\n    suite: streaming\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddProfile("cf", []string{"1.0.0.1"}); err != nil {
+		t.Fatalf("AddProfile failed: %v", err)
+	}
+
+	p, ok := FindProfile(LoadProfilesDns(), "cf")
+	if !ok {
+		t.Fatal("Profile not found after adding")
+	}
+	if len(p.Servers) != 1 || p.Servers[0] != "1.0.0.1" || len(p.IPv6) != 0 {
+		t.Fatalf("servers not replaced: %+v", p)
+	}
+	if p.Suite != "streaming" {
+		t.Fatalf("suite dropped: %+v", p)
+	}
+}
+
+// TestDoTValidate: verifies malformed SPKI pins are rejected
+func TestDoTValidate(t *testing.T) {
+	good := DoTServer{Host: "1.1.1.1", SPKIPin: "GP8Knf7qBae+aIfythytMbYnL+yowaWVeD6MoLHkVRg="}
+	if err := good.Validate(); err != nil {
+		t.Fatalf("valid pin rejected: %v", err)
+	}
+
+	bad := DoTServer{Host: "1.1.1.1", SPKIPin: "not-a-pin"}
+	if err := bad.Validate(); err == nil {
+		t.Fatal("expected error for malformed pin")
+	}
+}
+
+// TestLoadSuites: verifies inline suites, suite files and profile references
+func TestLoadSuites(t *testing.T) {
+	path := setupTestConfig(t)
+	dir := filepath.Dir(path)
+
+	suiteFile := "# internal names\nintranet.corp\nmail.corp MX\n\n"
+	if err := os.WriteFile(filepath.Join(dir, "internal.txt"), []byte(suiteFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	yaml := `profiles:
+  office:
+    ipv4: [10.0.0.53]
+    suite: internal
+suites:
+  cdn:
+    - cdn.example.net
+    - name: img.example.net
+      type: aaaa
+  internal: internal.txt
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	suites, err := LoadSuites()
+	if err != nil {
+		t.Fatalf("LoadSuites failed: %v", err)
+	}
+	if _, err := FindSuite(suites, DefaultSuiteName); err != nil {
+		t.Fatal("built-in default suite missing")
+	}
+
+	cdn, err := FindSuite(suites, "cdn")
+	if err != nil || len(cdn.Queries) != 2 || cdn.Queries[1] != (SuiteQuery{Name: "img.example.net", Type: "AAAA"}) {
+		t.Fatalf("unexpected cdn suite %+v (%v)", cdn, err)
+	}
+
+	internal, err := FindSuite(suites, "internal")
+	if err != nil || len(internal.Queries) != 2 || internal.Queries[1].String() != "mail.corp MX" {
+		t.Fatalf("unexpected internal suite %+v (%v)", internal, err)
+	}
+
+	p, ok := FindProfile(LoadProfilesDns(), "office")
+	if !ok || p.Suite != "internal" {
+		t.Fatalf("profile suite not loaded: %+v", p)
+	}
+
+	if _, err := FindSuite(suites, "missing"); err == nil {
+		t.Fatal("expected error for unknown suite")
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultDoTPort is the DNS-over-TLS port from RFC 7858
+const DefaultDoTPort = 853
+
+// DoTServer is a DNS-over-TLS upstream of a profile.
+// AuthName is the TLS authentication name checked against the certificate
+// (and sent as SNI); SPKIPin optionally pins the base64 SHA-256 hash of the
+// server's SubjectPublicKeyInfo.
+type DoTServer struct {
+	Host     string
+	Port     int
+	AuthName string
+	SPKIPin  string
+}
+
+// Address returns the host:port to dial
+func (d DoTServer) Address() string {
+	port := d.Port
+	if port == 0 {
+		port = DefaultDoTPort
+	}
+	return net.JoinHostPort(d.Host, strconv.Itoa(port))
+}
+
+// String returns the tls:// form used in output and by add-profile,
+// e.g. "tls://1.1.1.1:853#cloudflare-dns.com"
+func (d DoTServer) String() string {
+	s := "tls://" + d.Address()
+	if d.AuthName != "" {
+		s += "#" + d.AuthName
+	}
+	return s
+}
+
+// Validate checks the host and the format of the SPKI pin
+func (d DoTServer) Validate() error {
+	if d.Host == "" {
+		return fmt.Errorf("DoT server without host")
+	}
+	if d.Port < 0 || d.Port > 65535 {
+		return fmt.Errorf("DoT server %s: invalid port %d", d.Host, d.Port)
+	}
+	if d.SPKIPin != "" {
+		pin, err := base64.StdEncoding.DecodeString(d.SPKIPin)
+		if err != nil || len(pin) != sha256.Size {
+			return fmt.Errorf("DoT server %s: spki_pin must be a base64 encoded SHA-256 hash", d.Host)
+		}
+	}
+	return nil
+}
+
+// ParseDoT parses the tls://host[:port][#auth-name] form
+func ParseDoT(s string) (DoTServer, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s), "tls://")
+	if !ok {
+		return DoTServer{}, fmt.Errorf("DoT server %q must start with tls://", s)
+	}
+
+	var d DoTServer
+	rest, d.AuthName, _ = strings.Cut(rest, "#")
+
+	if host, port, err := net.SplitHostPort(rest); err == nil {
+		d.Host = host
+		d.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return DoTServer{}, fmt.Errorf("DoT server %q: invalid port", s)
+		}
+	} else {
+		d.Host = strings.Trim(rest, "[]")
+		d.Port = DefaultDoTPort
+	}
+
+	return d, d.Validate()
+}
+
+// dotList converts the YAML value of a profile's dot key (a single entry or
+// a list; each entry a map or a tls:// string) into DoT servers.
+// Invalid entries are skipped like other malformed profile data.
+func dotList(raw interface{}) []DoTServer {
+	var items []interface{}
+	switch v := raw.(type) {
+	case []interface{}:
+		items = v
+	default:
+		items = []interface{}{v}
+	}
+
+	var out []DoTServer
+	for _, item := range items {
+		var d DoTServer
+		switch v := item.(type) {
+		case string:
+			parsed, err := ParseDoT(v)
+			if err != nil {
+				continue
+			}
+			d = parsed
+		case map[string]interface{}:
+			d.Host, _ = v["host"].(string)
+			d.AuthName, _ = v["auth_name"].(string)
+			d.SPKIPin, _ = v["spki_pin"].(string)
+			d.Port = DefaultDoTPort
+			if port, ok := v["port"].(int); ok {
+				d.Port = port
+			}
+		default:
+			continue
+		}
+		if d.Validate() != nil {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// dotEntry converts a DoT server into its YAML form
+func dotEntry(d DoTServer) map[string]interface{} {
+	entry := map[string]interface{}{
+		"host": d.Host,
+		"port": d.Port,
+	}
+	if d.AuthName != "" {
+		entry["auth_name"] = d.AuthName
+	}
+	if d.SPKIPin != "" {
+		entry["spki_pin"] = d.SPKIPin
+	}
+	return entry
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultSuiteName is the suite used when neither --suite nor the profile names one
+const DefaultSuiteName = "default"
+
+// CanarySuiteName is the suite verify checks by default
+const CanarySuiteName = "canary"
+
+// SuiteQuery is one domain of a benchmark suite with its record type
+type SuiteQuery struct {
+	Name string
+	Type string // record type such as "A" or "AAAA"; "A" when empty
+}
+
+// String returns the "name TYPE" form also used in suite files
+func (q SuiteQuery) String() string {
+	t := q.Type
+	if t == "" {
+		t = "A"
+	}
+	return q.Name + " " + t
+}
+
+// Suite is a named list of queries every server is benchmarked with
+type Suite struct {
+	Name    string
+	Queries []SuiteQuery
+}
+
+// DefaultSuite is the built-in suite: popular sites, CDN names and an IPv6 lookup
+var DefaultSuite = Suite{
+	Name: DefaultSuiteName,
+	Queries: []SuiteQuery{
+		{Name: "google.com", Type: "A"},
+		{Name: "youtube.com", Type: "A"},
+		{Name: "wikipedia.org", Type: "A"},
+		{Name: "github.com", Type: "A"},
+		{Name: "www.cloudflare.com", Type: "A"},
+		{Name: "d1.awsstatic.com", Type: "A"},
+		{Name: "www.microsoft.com", Type: "A"},
+		{Name: "google.com", Type: "AAAA"},
+	},
+}
+
+// CanarySuite is the built-in suite of domains that are often tampered with
+var CanarySuite = Suite{
+	Name: CanarySuiteName,
+	Queries: []SuiteQuery{
+		{Name: "twitter.com", Type: "A"},
+		{Name: "facebook.com", Type: "A"},
+		{Name: "instagram.com", Type: "A"},
+		{Name: "youtube.com", Type: "A"},
+		{Name: "wikipedia.org", Type: "A"},
+		{Name: "telegram.org", Type: "A"},
+		{Name: "signal.org", Type: "A"},
+		{Name: "www.bbc.com", Type: "A"},
+	},
+}
+
+// LoadSuites returns the built-in default and canary suites together with the suites of
+// the top-level suites key of profiles.yaml, which may replace them.
+//
+// A suite is either an inline list of entries ("example.com", "example.com AAAA"
+// or {name: example.com, type: AAAA}) or the path of a suite file, relative
+// to profiles.yaml, with one "name [TYPE]" entry per line and # comments.
+func LoadSuites() (map[string]Suite, error) {
+	suites := map[string]Suite{DefaultSuiteName: DefaultSuite, CanarySuiteName: CanarySuite}
+	if Path == "" {
+		return suites, nil
+	}
+
+	viper.Reset()
+	viper.SetConfigFile(Path)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		return suites, nil
+	}
+
+	suitesMap, ok := viper.Get("suites").(map[string]interface{})
+	if !ok {
+		return suites, nil
+	}
+
+	for name, raw := range suitesMap {
+		var queries []SuiteQuery
+		var err error
+		switch v := raw.(type) {
+		case string:
+			file := v
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(Path), file)
+			}
+			queries, err = readSuiteFile(file)
+		case []interface{}:
+			queries, err = suiteList(v)
+		default:
+			err = fmt.Errorf("must be a list of domains or a file name")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("suite '%s': %v", name, err)
+		}
+		if len(queries) == 0 {
+			return nil, fmt.Errorf("suite '%s' has no domains", name)
+		}
+		suites[name] = Suite{Name: name, Queries: queries}
+	}
+
+	return suites, nil
+}
+
+// SuiteNames returns the names of suites in sorted order
+func SuiteNames(suites map[string]Suite) []string {
+	names := make([]string, 0, len(suites))
+	for name := range suites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FindSuite looks up a suite by name
+func FindSuite(suites map[string]Suite, name string) (Suite, error) {
+	s, ok := suites[name]
+	if !ok {
+		return Suite{}, fmt.Errorf("suite '%s' not found (available: %s)", name, strings.Join(SuiteNames(suites), ", "))
+	}
+	return s, nil
+}
+
+// ParseSuiteQuery parses a "name [TYPE]" entry
+func ParseSuiteQuery(s string) (SuiteQuery, error) {
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		return SuiteQuery{Name: fields[0], Type: "A"}, nil
+	case 2:
+		return SuiteQuery{Name: fields[0], Type: strings.ToUpper(fields[1])}, nil
+	default:
+		return SuiteQuery{}, fmt.Errorf("invalid entry %q, expected \"name [TYPE]\"", s)
+	}
+}
+
+// suiteList converts the inline YAML form of a suite
+func suiteList(items []interface{}) ([]SuiteQuery, error) {
+	var out []SuiteQuery
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			q, err := ParseSuiteQuery(v)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, q)
+		case map[string]interface{}:
+			name, _ := v["name"].(string)
+			qtype, _ := v["type"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("entry without name")
+			}
+			if qtype == "" {
+				qtype = "A"
+			}
+			out = append(out, SuiteQuery{Name: name, Type: strings.ToUpper(qtype)})
+		default:
+			return nil, fmt.Errorf("invalid entry %v", v)
+		}
+	}
+	return out, nil
+}
+
+// readSuiteFile reads a suite file with one "name [TYPE]" entry per line
+func readSuiteFile(path string) ([]SuiteQuery, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []SuiteQuery
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		q, err := ParseSuiteQuery(line)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, sc.Err()
+}
@@ -0,0 +1,294 @@
+// Package daemon keeps the fastest healthy DNS profile applied: it
+// benchmarks the profiles periodically, switches only to a profile that
+// stays ahead of the active one by a margin for several rounds, and fails
+// over at once when the active servers stop answering.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	platformall "github.com/Mreza2020/DNS-Switcher/internal/platform-all"
+	"github.com/Mreza2020/DNS-Switcher/internal/resolver"
+)
+
+// Clock is the time source of a Daemon, replaced by a fake clock in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock of the operating system
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time                         { return time.Now() }
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// applyProfile is the function profiles are applied with, replaceable in tests
+var applyProfile = platformall.ApplyProfile
+
+// rollbackTo restores a snapshot taken by applyProfile, replaceable in tests
+var rollbackTo = platformall.RollbackTo
+
+// Action is what a Daemon decided in a round
+type Action string
+
+const (
+	ActionKeep     Action = "keep"     // the active profile stays
+	ActionWait     Action = "wait"     // a faster profile must stay ahead for more rounds
+	ActionSwitch   Action = "switch"   // a faster profile was applied
+	ActionFailover Action = "failover" // the active servers stopped answering, the best profile was applied
+)
+
+// Decision is the outcome of one round, logged through Options.Log.
+// Active is the profile in use after the decision; Error is set when
+// applying the chosen profile failed, in which case Active did not change.
+type Decision struct {
+	Time        time.Time     `json:"time"`
+	Round       int           `json:"round"`
+	Action      Action        `json:"action"`
+	Active      string        `json:"active"`
+	Best        string        `json:"best,omitempty"`
+	ActiveScore time.Duration `json:"active_score_ns,omitempty"`
+	BestScore   time.Duration `json:"best_score_ns,omitempty"`
+	Streak      int           `json:"streak,omitempty"`
+	Reason      string        `json:"reason"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// String describes the decision in one log line
+func (d Decision) String() string {
+	s := fmt.Sprintf("%s round %d: %s, active '%s': %s", d.Time.Format(time.RFC3339), d.Round, d.Action, d.Active, d.Reason)
+	if d.Error != "" {
+		s += " (error: " + d.Error + ")"
+	}
+	return s
+}
+
+// Options configure a Daemon
+type Options struct {
+	Profiles   []config.Profile
+	Interfaces []string // the profile is applied to each of them
+
+	Interval time.Duration // between benchmark rounds
+	// CheckInterval is how often the active servers are probed between
+	// rounds; a failed probe starts a round at once. 0 disables the probes.
+	CheckInterval time.Duration
+	// Margin is the fraction a profile's score must beat the active one's
+	// by, e.g. 0.1 for 10% faster, for Rounds consecutive rounds
+	Margin float64
+	Rounds int
+
+	Metric   resolver.RankMetric
+	Bench    resolver.BenchOptions
+	Deadline time.Duration // bounds the benchmark of a round, 0 = no limit
+
+	Clock Clock
+	Log   func(Decision)
+}
+
+// Daemon is the state of a watch loop, see New
+type Daemon struct {
+	opts       Options
+	active     string
+	challenger string
+	streak     int
+	round      int
+}
+
+// New returns a Daemon whose active profile is the one the first interface
+// currently uses, if any
+func New(opts Options) *Daemon {
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Minute
+	}
+	if opts.Rounds <= 0 {
+		opts.Rounds = 1
+	}
+	if opts.Metric == "" {
+		opts.Metric = resolver.RankLossWeighted
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock{}
+	}
+	if opts.Log == nil {
+		opts.Log = func(Decision) {}
+	}
+
+	d := &Daemon{opts: opts}
+	if len(opts.Interfaces) > 0 {
+		if current, err := platformall.GetCurrentDNS(opts.Interfaces[0]); err == nil {
+			d.active = matchProfile(opts.Profiles, current)
+		}
+	}
+	return d
+}
+
+// Active returns the name of the applied profile, empty when unknown
+func (d *Daemon) Active() string {
+	return d.active
+}
+
+// Run runs a round every Interval, and earlier when a check of the active
+// servers fails, until ctx is done
+func (d *Daemon) Run(ctx context.Context) error {
+	for {
+		d.Round(ctx)
+		if err := d.sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits until the next round is due: Interval from now, or the first
+// check of the active servers that fails
+func (d *Daemon) sleep(ctx context.Context) error {
+	next := d.opts.Clock.Now().Add(d.opts.Interval)
+	for {
+		wait := next.Sub(d.opts.Clock.Now())
+		if c := d.opts.CheckInterval; c > 0 && c < wait {
+			wait = c
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.opts.Clock.After(wait):
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.opts.Clock.Now().Before(next) || !d.activeAnswers(ctx) {
+			return nil
+		}
+	}
+}
+
+// activeAnswers probes the servers of the active profile once
+func (d *Daemon) activeAnswers(ctx context.Context) bool {
+	p, ok := config.FindProfile(d.opts.Profiles, d.active)
+	if !ok {
+		return true
+	}
+	report := resolver.CheckHealth(ctx, resolver.TargetsOf(*p), resolver.HealthOptions{
+		Queries:   d.opts.Bench.Queries,
+		Timeout:   d.opts.Bench.Timeout,
+		DoHMethod: d.opts.Bench.DoHMethod,
+	})
+	return report.Passed
+}
+
+// Round benchmarks every profile, decides whether to switch and logs the decision
+func (d *Daemon) Round(ctx context.Context) Decision {
+	d.round++
+	dec := Decision{Time: d.opts.Clock.Now(), Round: d.round, Active: d.active}
+
+	bctx, cancel := ctx, context.CancelFunc(func() {})
+	if d.opts.Deadline > 0 {
+		bctx, cancel = context.WithTimeout(ctx, d.opts.Deadline)
+	}
+	results := resolver.BenchmarkProfiles(bctx, d.opts.Profiles, d.opts.Bench)
+	cancel()
+
+	ranked := resolver.RankProfiles(results, d.opts.Metric)
+	if len(ranked) == 0 {
+		dec.Action, dec.Reason = ActionKeep, "no profile answered"
+		d.opts.Log(dec)
+		return dec
+	}
+	best := ranked[0]
+	dec.Best, dec.BestScore = best.Profile.Name, best.Score
+
+	var current *resolver.RankedProfile
+	for i := range ranked {
+		if ranked[i].Profile.Name == d.active {
+			current = &ranked[i]
+			dec.ActiveScore = current.Score
+		}
+	}
+
+	switch {
+	case d.active == "":
+		dec.Action, dec.Reason = ActionSwitch, fmt.Sprintf("no profile active, '%s' is the fastest (%v)", best.Profile.Name, best.Score)
+	case current == nil:
+		dec.Action, dec.Reason = ActionFailover, fmt.Sprintf("servers of '%s' stopped answering, '%s' is the fastest (%v)", d.active, best.Profile.Name, best.Score)
+	case best.Profile.Name == d.active:
+		d.challenger, d.streak = "", 0
+		dec.Action, dec.Reason = ActionKeep, fmt.Sprintf("'%s' is the fastest (%v)", d.active, current.Score)
+	case float64(best.Score) > float64(current.Score)*(1-d.opts.Margin):
+		d.challenger, d.streak = "", 0
+		dec.Action, dec.Reason = ActionKeep, fmt.Sprintf("'%s' (%v) is not %.0f%% faster than '%s' (%v)",
+			best.Profile.Name, best.Score, d.opts.Margin*100, d.active, current.Score)
+	default:
+		if d.challenger != best.Profile.Name {
+			d.challenger, d.streak = best.Profile.Name, 0
+		}
+		d.streak++
+		dec.Streak = d.streak
+		reason := fmt.Sprintf("'%s' (%v) beats '%s' (%v) by more than %.0f%% for %d of %d rounds",
+			best.Profile.Name, best.Score, d.active, current.Score, d.opts.Margin*100, d.streak, d.opts.Rounds)
+		dec.Action, dec.Reason = ActionWait, reason
+		if d.streak >= d.opts.Rounds {
+			dec.Action = ActionSwitch
+		}
+	}
+
+	if dec.Action == ActionSwitch || dec.Action == ActionFailover {
+		if err := d.apply(best.Profile); err != nil {
+			dec.Error = err.Error()
+		} else {
+			d.active, d.challenger, d.streak = best.Profile.Name, "", 0
+			dec.Active = d.active
+		}
+	}
+
+	d.opts.Log(dec)
+	return dec
+}
+
+// apply applies p to every interface. When one of them fails, the
+// interfaces already switched are rolled back to their snapshots, newest
+// first, so they never disagree.
+func (d *Daemon) apply(p config.Profile) error {
+	var snapshots []int
+	for _, iface := range d.opts.Interfaces {
+		p.Interface = iface
+		res, err := applyProfile(p)
+		if err != nil {
+			for i := len(snapshots) - 1; i >= 0; i-- {
+				if _, rerr := rollbackTo(snapshots[i]); rerr != nil {
+					err = fmt.Errorf("%v; rolling back snapshot %d failed: %v", err, snapshots[i], rerr)
+				}
+			}
+			return err
+		}
+		if res.Snapshot > 0 {
+			snapshots = append(snapshots, res.Snapshot)
+		}
+	}
+	return nil
+}
+
+// matchProfile returns the name of the profile whose servers are exactly
+// current, in any order, or "" when there is none
+func matchProfile(profiles []config.Profile, current []string) string {
+	key := func(servers []string) string {
+		s := make([]string, 0, len(servers))
+		for _, v := range servers {
+			s = append(s, strings.TrimSpace(v))
+		}
+		sort.Strings(s)
+		return strings.Join(s, " ")
+	}
+
+	want := key(current)
+	for _, p := range profiles {
+		if servers := p.AllServers(); len(servers) > 0 && key(servers) == want {
+			return p.Name
+		}
+	}
+	return ""
+}
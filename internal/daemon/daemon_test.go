@@ -0,0 +1,301 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	platformall "github.com/Mreza2020/DNS-Switcher/internal/platform-all"
+	"github.com/Mreza2020/DNS-Switcher/internal/resolver"
+	"github.com/miekg/dns"
+)
+
+// stub is a local DNS server answering A queries for example.com after
+// delay, or never while down
+type stub struct {
+	addr  string
+	delay atomic.Int64
+	down  atomic.Bool
+}
+
+func startStub(t *testing.T, delay time.Duration) *stub {
+	s := &stub{}
+	s.delay.Store(int64(delay))
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+			if s.down.Load() {
+				return
+			}
+			time.Sleep(time.Duration(s.delay.Load()))
+			resp := new(dns.Msg)
+			resp.SetReply(q)
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("93.184.216.34"),
+			})
+			w.WriteMsg(resp)
+		})}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+
+	s.addr = pc.LocalAddr().String()
+	return s
+}
+
+// fakeBackend keeps the servers of every interface in memory
+type fakeBackend struct {
+	mu      sync.Mutex
+	servers map[string][]string
+	applied []string        // names of the applied profiles
+	fail    map[string]bool // interfaces applying fails on
+}
+
+func (b *fakeBackend) Name() string { return "fake" }
+
+func (b *fakeBackend) ApplyProfile(p config.Profile) (platformall.ApplyResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail[p.Interface] {
+		return platformall.ApplyResult{Ok: false}, fmt.Errorf("cannot apply to %s", p.Interface)
+	}
+	b.servers[p.Interface] = p.AllServers()
+	b.applied = append(b.applied, p.Name)
+	return platformall.ApplyResult{Ok: true}, nil
+}
+
+func (b *fakeBackend) Rollback(iface string) (platformall.ApplyResult, error) {
+	return platformall.ApplyResult{Ok: true}, nil
+}
+
+func (b *fakeBackend) GetCurrentDNS(iface string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.servers[iface], nil
+}
+
+func (b *fakeBackend) GetNetworkInterfaces() ([]string, error) { return []string{"eth0"}, nil }
+
+func (b *fakeBackend) Snapshot(iface string) (platformall.DNSState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return platformall.DNSState{Interface: iface, Backend: "fake", IPv4: platformall.FamilyState{Servers: b.servers[iface]}}, nil
+}
+
+func (b *fakeBackend) Restore(state platformall.DNSState) (platformall.ApplyResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.servers[state.Interface] = state.IPv4.Servers
+	return platformall.ApplyResult{Ok: true}, nil
+}
+
+// useFakeBackend installs a fakeBackend where eth0 uses servers and
+// applies profiles through it directly
+func useFakeBackend(t *testing.T, servers ...string) *fakeBackend {
+	b := &fakeBackend{servers: map[string][]string{"eth0": servers}}
+	orig, origPath := applyProfile, platformall.SnapshotPath
+	t.Cleanup(func() {
+		applyProfile, platformall.SnapshotPath = orig, origPath
+		platformall.SetBackend(nil)
+	})
+	platformall.SnapshotPath = filepath.Join(t.TempDir(), "snapshots.json")
+	platformall.SetBackend(b)
+	applyProfile = b.ApplyProfile
+	return b
+}
+
+// fakeClock advances by the duration waited for, at once
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// testOptions returns options benchmarking the profiles served by fast and slow
+func testOptions(fast, slow *stub) Options {
+	return Options{
+		Profiles: []config.Profile{
+			{Name: "fast", Servers: []string{fast.addr}},
+			{Name: "slow", Servers: []string{slow.addr}},
+		},
+		Interfaces: []string{"eth0"},
+		Margin:     0.2,
+		Rounds:     3,
+		Metric:     resolver.RankMean,
+		Bench: resolver.BenchOptions{
+			Queries:     []resolver.Query{{Name: "example.com"}},
+			Repeat:      3,
+			Timeout:     300 * time.Millisecond,
+			Concurrency: 4,
+		},
+		Clock: &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+}
+
+// actions returns the actions of decisions, in order
+func actions(decisions []Decision) []Action {
+	var out []Action
+	for _, d := range decisions {
+		out = append(out, d.Action)
+	}
+	return out
+}
+
+// TestRoundHysteresis: verifies a faster profile is applied only after
+// beating the active one by the margin for the configured number of rounds
+func TestRoundHysteresis(t *testing.T) {
+	fast, slow := startStub(t, 0), startStub(t, 40*time.Millisecond)
+	b := useFakeBackend(t, slow.addr)
+
+	var logged []Decision
+	opts := testOptions(fast, slow)
+	opts.Log = func(d Decision) { logged = append(logged, d) }
+	d := New(opts)
+	if d.Active() != "slow" {
+		t.Fatalf("expected the active profile to be detected, got '%s'", d.Active())
+	}
+
+	for i := 0; i < 4; i++ {
+		d.Round(context.Background())
+	}
+	want := []Action{ActionWait, ActionWait, ActionSwitch, ActionKeep}
+	if !reflect.DeepEqual(actions(logged), want) {
+		t.Fatalf("expected %v, got %+v", want, logged)
+	}
+	if logged[1].Streak != 2 || logged[2].Active != "fast" || logged[1].Active != "slow" {
+		t.Fatalf("unexpected decisions %+v", logged)
+	}
+	if !reflect.DeepEqual(b.applied, []string{"fast"}) || b.servers["eth0"][0] != fast.addr {
+		t.Fatalf("expected only 'fast' to be applied, got %v", b.applied)
+	}
+}
+
+// TestRoundMargin: verifies a profile faster by less than the margin never
+// replaces the active one
+func TestRoundMargin(t *testing.T) {
+	fast, slow := startStub(t, 15*time.Millisecond), startStub(t, 20*time.Millisecond)
+	b := useFakeBackend(t, slow.addr)
+
+	opts := testOptions(fast, slow)
+	opts.Margin, opts.Rounds = 0.5, 1
+	d := New(opts)
+	for i := 0; i < 2; i++ {
+		if dec := d.Round(context.Background()); dec.Action != ActionKeep || dec.Best != "fast" {
+			t.Fatalf("expected to keep 'slow', got %+v", dec)
+		}
+	}
+	if len(b.applied) != 0 {
+		t.Fatalf("nothing should be applied, got %v", b.applied)
+	}
+}
+
+// TestRoundFailover: verifies the best profile is applied at once, without
+// waiting for more rounds, when the active servers stop answering
+func TestRoundFailover(t *testing.T) {
+	fast, slow := startStub(t, 0), startStub(t, 20*time.Millisecond)
+	b := useFakeBackend(t, fast.addr)
+
+	d := New(testOptions(fast, slow))
+	fast.down.Store(true)
+	dec := d.Round(context.Background())
+	if dec.Action != ActionFailover || dec.Active != "slow" || d.Active() != "slow" {
+		t.Fatalf("expected a failover to 'slow', got %+v", dec)
+	}
+	if !reflect.DeepEqual(b.applied, []string{"slow"}) {
+		t.Fatalf("expected 'slow' to be applied, got %v", b.applied)
+	}
+}
+
+// TestRun: verifies rounds follow the interval of the fake clock and a
+// failed check of the active servers starts a round at once
+func TestRun(t *testing.T) {
+	fast, slow := startStub(t, 0), startStub(t, 20*time.Millisecond)
+	useFakeBackend(t, fast.addr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var logged []Decision
+	opts := testOptions(fast, slow)
+	opts.Interval, opts.CheckInterval = time.Minute, 25*time.Second
+	opts.Log = func(d Decision) {
+		logged = append(logged, d)
+		switch len(logged) {
+		case 2:
+			fast.down.Store(true)
+		case 3:
+			cancel()
+		}
+	}
+	clock := opts.Clock.(*fakeClock)
+	start := clock.Now()
+
+	if err := New(opts).Run(ctx); err != context.Canceled {
+		t.Fatalf("expected Run to stop with the context, got %v", err)
+	}
+	if want := []Action{ActionKeep, ActionKeep, ActionFailover}; !reflect.DeepEqual(actions(logged), want) {
+		t.Fatalf("expected %v, got %+v", want, logged)
+	}
+	if logged[1].Time.Sub(start) != time.Minute || logged[2].Time.Sub(start) != time.Minute+25*time.Second {
+		t.Fatalf("unexpected round times %v, %v", logged[1].Time, logged[2].Time)
+	}
+	if want := []time.Duration{25 * time.Second, 25 * time.Second, 10 * time.Second, 25 * time.Second}; !reflect.DeepEqual(clock.waits[:4], want) {
+		t.Fatalf("expected waits %v, got %v", want, clock.waits)
+	}
+}
+
+// TestApplyRollsBack: verifies a profile failing on a later interface is
+// rolled back on the interfaces it was already applied to
+func TestApplyRollsBack(t *testing.T) {
+	b := useFakeBackend(t, "192.0.2.53")
+	b.servers["eth1"] = []string{"192.0.2.54"}
+	b.servers["eth2"] = []string{"192.0.2.55"}
+	b.fail = map[string]bool{"eth2": true}
+	applyProfile = platformall.ApplyProfile
+
+	d := New(Options{
+		Profiles:   []config.Profile{{Name: "cf", Servers: []string{"1.1.1.1"}}},
+		Interfaces: []string{"eth0", "eth1", "eth2"},
+		Clock:      &fakeClock{},
+	})
+	if err := d.apply(d.opts.Profiles[0]); err == nil {
+		t.Fatal("expected the failure on eth2")
+	}
+
+	want := map[string][]string{"eth0": {"192.0.2.53"}, "eth1": {"192.0.2.54"}, "eth2": {"192.0.2.55"}}
+	if !reflect.DeepEqual(b.servers, want) {
+		t.Fatalf("expected every interface restored, got %v", b.servers)
+	}
+	if snaps, err := platformall.ListSnapshots(""); err != nil || len(snaps) != 0 {
+		t.Fatalf("expected the snapshots to be dropped, got %v, %v", snaps, err)
+	}
+}
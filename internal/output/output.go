@@ -0,0 +1,144 @@
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Format selects how a command writes its result
+type Format string
+
+const (
+	Text Format = "text" // human readable, printed by the commands themselves
+	JSON Format = "json"
+	YAML Format = "yaml"
+	CSV  Format = "csv"
+)
+
+// Formats lists the valid formats, default first
+var Formats = []Format{Text, JSON, YAML, CSV}
+
+// ParseFormat validates a format name
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == strings.ToLower(s) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format '%s' (available: %v)", s, Formats)
+}
+
+// Table is a result that can be written as CSV: a header row followed by
+// one row per record
+type Table interface {
+	Header() []string
+	Rows() [][]string
+}
+
+// Write encodes v to w in format f.
+// JSON is indented; YAML has the same keys, in the same order, as JSON;
+// CSV requires v to implement Table. Text is printed by the commands and
+// cannot be written here.
+func Write(w io.Writer, f Format, v interface{}) error {
+	switch f {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(v)
+	case YAML:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		node, err := yamlNode(json.NewDecoder(bytes.NewReader(data)))
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(node); err != nil {
+			return err
+		}
+		return enc.Close()
+	case CSV:
+		t, ok := v.(Table)
+		if !ok {
+			return fmt.Errorf("%T cannot be written as csv", v)
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.Write(t.Header()); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(t.Rows()); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("output format '%s' cannot be written", f)
+	}
+}
+
+// yamlNode converts the next JSON value of dec into a YAML node, keeping
+// the order of object keys
+func yamlNode(dec *json.Decoder) (*yaml.Node, error) {
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := yamlNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content,
+					&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string)}, value)
+			}
+			_, err := dec.Token() // '}'
+			return node, err
+		case '[':
+			node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			for dec.More() {
+				value, err := yamlNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, value)
+			}
+			_, err := dec.Token() // ']'
+			return node, err
+		}
+		return nil, fmt.Errorf("unexpected %v", v)
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(v.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(v)}, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+	return nil, fmt.Errorf("unexpected token %v", tok)
+}
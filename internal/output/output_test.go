@@ -0,0 +1,162 @@
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/Mreza2020/DNS-Switcher/internal/resolver"
+)
+
+// TestParseFormat: verifies format names are case-insensitive and unknown ones rejected
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("YAML"); err != nil || f != YAML {
+		t.Fatalf("ParseFormat(YAML) = %v, %v", f, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
+
+// TestWriteJSON: verifies the documented keys, empty lists and nanosecond durations
+func TestWriteJSON(t *testing.T) {
+	sr := resolver.ServerResult{
+		Target:  resolver.Target{Server: "1.1.1.1"},
+		Samples: []resolver.Result{{Server: "1.1.1.1", Query: "example.com A", Protocol: "do53", RTT: 10 * time.Millisecond}},
+	}
+	res := TestResult{Target: "1.1.1.1", Kind: "server", Stats: sr.Stats(), Servers: []ServerReport{NewServerReport(sr)}}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, JSON, res); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Kind    string `json:"kind"`
+		Servers []struct {
+			Stats struct {
+				Median int64 `json:"p50_ns"`
+			} `json:"stats"`
+			Samples []map[string]interface{} `json:"samples"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if got.Kind != "server" || len(got.Servers) != 1 || got.Servers[0].Stats.Median != int64(10*time.Millisecond) {
+		t.Fatalf("unexpected result: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "uncached") {
+		t.Fatalf("uncached fields without cache busting: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := Write(&buf, JSON, ListResult{Profiles: []ProfileInfo{NewProfileInfo(config.Profile{Name: "empty"})}}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "null") {
+		t.Fatalf("lists must not be null: %s", buf.String())
+	}
+}
+
+// TestWriteYAML: verifies YAML keeps the JSON keys in order
+func TestWriteYAML(t *testing.T) {
+	var buf bytes.Buffer
+	res := ApplyResult{Profile: "cf", Servers: []string{"1.1.1.1"}, Interfaces: []InterfaceApply{{Interface: "eth0", Applied: true}}}
+	if err := Write(&buf, YAML, res); err != nil {
+		t.Fatal(err)
+	}
+	want := "profile: cf\ninterface: eth0\nservers:\n  - 1.1.1.1\napplied: true\nalready_active: false\n" +
+		"interfaces:\n  - interface: eth0\n    applied: true\n    already_active: false\n"
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+// summary is the top-level fields results had for a single interface
+type summary struct {
+	Interface     string   `json:"interface"`
+	Applied       bool     `json:"applied"`
+	AlreadyActive bool     `json:"already_active"`
+	Message       string   `json:"message"`
+	IPv4          []string `json:"ipv4"`
+	IPv6          []string `json:"ipv6"`
+}
+
+// writeSummary writes v as JSON and decodes its top-level fields
+func writeSummary(t *testing.T, v interface{}) (summary, string) {
+	var buf bytes.Buffer
+	if err := Write(&buf, JSON, v); err != nil {
+		t.Fatal(err)
+	}
+	var got summary
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if strings.Contains(buf.String(), "null") {
+		t.Fatalf("lists must not be null: %s", buf.String())
+	}
+	return got, buf.String()
+}
+
+// TestWriteSingleInterface: verifies results covering several interfaces
+// keep the top-level fields of a single one, copied from the only
+// interface and otherwise summing up all of them
+func TestWriteSingleInterface(t *testing.T) {
+	eth0 := NewInterfaceDNS("eth0", []string{"1.1.1.1", "2606:4700:4700::1111"})
+	if got, out := writeSummary(t, StatusResult{Interfaces: []InterfaceDNS{eth0}}); got.Interface != "eth0" || len(got.IPv4) != 1 || len(got.IPv6) != 1 {
+		t.Fatalf("unexpected status: %s", out)
+	}
+	if got, out := writeSummary(t, StatusResult{Interfaces: []InterfaceDNS{eth0, NewInterfaceDNS("wlan0", nil)}}); got.Interface != "" || len(got.IPv4) != 0 {
+		t.Fatalf("several interfaces must not be summed up as the first: %s", out)
+	}
+	writeSummary(t, StatusResult{Interfaces: []InterfaceDNS{}})
+
+	rollback := RollbackResult{Interfaces: []InterfaceRollback{{Interface: "eth0", Message: "restored"}}}
+	if got, out := writeSummary(t, rollback); got.Interface != "eth0" || got.Message != "restored" {
+		t.Fatalf("unexpected rollback: %s", out)
+	}
+
+	apply := ApplyResult{Profile: "cf", Servers: []string{"1.1.1.1"}, Interfaces: []InterfaceApply{
+		{Interface: "eth0", AlreadyActive: true, Message: "already active"},
+		{Interface: "wlan0", Applied: true, Message: "applied"},
+	}}
+	if got, out := writeSummary(t, apply); !got.Applied || got.AlreadyActive || got.Interface != "" || got.Message != "" {
+		t.Fatalf("expected applied on any interface, got %s", out)
+	}
+	apply.Interfaces = apply.Interfaces[:1]
+	if got, out := writeSummary(t, apply); got.Applied || !got.AlreadyActive || got.Interface != "eth0" || got.Message != "already active" {
+		t.Fatalf("unexpected apply: %s", out)
+	}
+}
+
+// TestWriteCSV: verifies the header and one row per record, and that
+// results without a table are rejected
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	res := StatusResult{Interfaces: []InterfaceDNS{NewInterfaceDNS("eth0", []string{"1.1.1.1", "2606:4700:4700::1111"})}}
+	if err := Write(&buf, CSV, res); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"interface", "family", "server"}, {"eth0", "ipv4", "1.1.1.1"}, {"eth0", "ipv6", "2606:4700:4700::1111"}}
+	if len(rows) != len(want) || strings.Join(rows[2], ",") != strings.Join(want[2], ",") {
+		t.Fatalf("got %v, want %v", rows, want)
+	}
+
+	for _, table := range []Table{TestResult{}, AutoResult{}, ApplyResult{}, RollbackResult{}, StatusResult{}, InterfacesResult{}, VerifyReport{}, SnapshotsResult{}, ConfirmResult{}, HealthResult{}, DeleteResult{}} {
+		if len(table.Rows()) != 0 || len(table.Header()) == 0 {
+			t.Fatalf("%T: unexpected empty table", table)
+		}
+	}
+
+	if err := Write(&buf, CSV, map[string]string{}); err == nil {
+		t.Fatal("expected error for a result without table")
+	}
+}
@@ -0,0 +1,529 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	platformall "github.com/Mreza2020/DNS-Switcher/internal/platform-all"
+	"github.com/Mreza2020/DNS-Switcher/internal/proxy"
+	"github.com/Mreza2020/DNS-Switcher/internal/resolver"
+)
+
+// The result types below are the documented output of the commands.
+// Fields are only ever added; durations are integer nanoseconds in fields
+// ending in _ns, lists are never null. Results that cover several
+// interfaces keep the fields they had for a single one, filled when
+// encoded: from the interface when there is only one, otherwise only those
+// that can be told for all of them.
+
+// ErrorResult is written instead of the result when a command fails
+type ErrorResult struct {
+	Error string `json:"error"`
+}
+
+func (r ErrorResult) Header() []string { return []string{"error"} }
+func (r ErrorResult) Rows() [][]string { return [][]string{{r.Error}} }
+
+// ProfileInfo describes a profile
+type ProfileInfo struct {
+	Name  string   `json:"name"`
+	IPv4  []string `json:"ipv4"`
+	IPv6  []string `json:"ipv6"`
+	DoH   []string `json:"doh"`
+	DoT   []string `json:"dot"` // "tls://server#name" form
+	Suite string   `json:"suite,omitempty"`
+}
+
+// NewProfileInfo describes p
+func NewProfileInfo(p config.Profile) ProfileInfo {
+	info := ProfileInfo{
+		Name:  p.Name,
+		IPv4:  list(p.Servers),
+		IPv6:  list(p.IPv6),
+		DoH:   list(p.DoH),
+		DoT:   []string{},
+		Suite: p.Suite,
+	}
+	for _, d := range p.DoT {
+		info.DoT = append(info.DoT, d.String())
+	}
+	return info
+}
+
+var profileHeader = []string{"name", "ipv4", "ipv6", "doh", "dot", "suite"}
+
+func (p ProfileInfo) row() []string {
+	return []string{p.Name, join(p.IPv4), join(p.IPv6), join(p.DoH), join(p.DoT), p.Suite}
+}
+
+// ListResult is the output of list
+type ListResult struct {
+	Profiles []ProfileInfo `json:"profiles"`
+}
+
+func (r ListResult) Header() []string { return profileHeader }
+
+func (r ListResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, p := range r.Profiles {
+		rows = append(rows, p.row())
+	}
+	return rows
+}
+
+// AddProfileResult is the output of add-profile
+type AddProfileResult struct {
+	Profile ProfileInfo `json:"profile"`
+}
+
+func (r AddProfileResult) Header() []string { return profileHeader }
+func (r AddProfileResult) Rows() [][]string { return [][]string{r.Profile.row()} }
+
+// ServerReport is the benchmark of one server. UncachedStats and Uncached
+// are only present with cache busting, DNSSEC only when it was checked.
+type ServerReport struct {
+	Server        string                `json:"server"`
+	Profile       string                `json:"profile,omitempty"`
+	Stats         resolver.Stats        `json:"stats"`
+	UncachedStats *resolver.Stats       `json:"uncached_stats,omitempty"`
+	DNSSEC        resolver.DNSSECStatus `json:"dnssec,omitempty"`
+	Samples       []resolver.Result     `json:"samples"`
+	Uncached      []resolver.Result     `json:"uncached,omitempty"`
+}
+
+// NewServerReport reports sr
+func NewServerReport(sr resolver.ServerResult) ServerReport {
+	r := ServerReport{
+		Server:  sr.Target.String(),
+		Profile: sr.Target.Profile,
+		Stats:   sr.Stats(),
+		Samples: sr.Samples,
+	}
+	if r.Samples == nil {
+		r.Samples = []resolver.Result{}
+	}
+	if len(sr.Uncached) > 0 {
+		st := sr.UncachedStats()
+		r.UncachedStats = &st
+		r.Uncached = sr.Uncached
+	}
+	return r
+}
+
+var serverHeader = []string{"server", "profile", "sent", "received", "loss",
+	"min_ns", "p50_ns", "p90_ns", "p99_ns", "mean_ns", "stddev_ns", "jitter_ns", "uncached_p50_ns", "dnssec"}
+
+func (r ServerReport) row() []string {
+	uncached := ""
+	if r.UncachedStats != nil {
+		uncached = ns(r.UncachedStats.Median.Nanoseconds())
+	}
+	return append(append([]string{r.Server, r.Profile}, statsRow(r.Stats)...), uncached, string(r.DNSSEC))
+}
+
+// TestResult is the output of test. Target is the argument, Kind is
+// "profile" or "server"; for a profile Stats pool the samples of all servers.
+type TestResult struct {
+	Target        string          `json:"target"`
+	Kind          string          `json:"kind"`
+	Stats         resolver.Stats  `json:"stats"`
+	UncachedStats *resolver.Stats `json:"uncached_stats,omitempty"`
+	Servers       []ServerReport  `json:"servers"`
+}
+
+func (r TestResult) Header() []string { return serverHeader }
+
+func (r TestResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, s := range r.Servers {
+		rows = append(rows, s.row())
+	}
+	return rows
+}
+
+// ProfileReport is the benchmark of one profile in auto. Rank starts at 1;
+// profiles left out of the ranking have rank 0 and say why in Skipped.
+type ProfileReport struct {
+	Name          string          `json:"name"`
+	Rank          int             `json:"rank"`
+	ScoreNS       int64           `json:"score_ns"`
+	Skipped       string          `json:"skipped,omitempty"`
+	Stats         resolver.Stats  `json:"stats"`
+	UncachedStats *resolver.Stats `json:"uncached_stats,omitempty"`
+	Servers       []ServerReport  `json:"servers"`
+}
+
+// NewProfileReport reports pr, without rank
+func NewProfileReport(pr resolver.ProfileResult) ProfileReport {
+	r := ProfileReport{Name: pr.Profile.Name, Stats: pr.Stats(), Servers: []ServerReport{}}
+	if len(pr.UncachedSamples()) > 0 {
+		st := pr.UncachedStats()
+		r.UncachedStats = &st
+	}
+	for _, sr := range pr.Servers {
+		r.Servers = append(r.Servers, NewServerReport(sr))
+	}
+	return r
+}
+
+// AutoResult is the output of auto, ranked profiles first. Interfaces is
+// only present with --apply; Interface and Message repeat its entry when
+// there is only one.
+type AutoResult struct {
+	RankBy     resolver.RankMetric `json:"rank_by"`
+	Best       string              `json:"best"`
+	Applied    bool                `json:"applied"`
+	Interface  string              `json:"interface,omitempty"`
+	Message    string              `json:"message,omitempty"`
+	Interfaces []InterfaceApply    `json:"interfaces,omitempty"`
+	Profiles   []ProfileReport     `json:"profiles"`
+}
+
+// MarshalJSON fills Interface and Message from a single interface
+func (r AutoResult) MarshalJSON() ([]byte, error) {
+	type plain AutoResult
+	if len(r.Interfaces) == 1 {
+		r.Interface, r.Message = r.Interfaces[0].Interface, r.Interfaces[0].Message
+	}
+	return json.Marshal(plain(r))
+}
+
+func (r AutoResult) Header() []string {
+	return append([]string{"rank", "profile", "score_ns", "skipped"}, serverHeader[2:12]...)
+}
+
+func (r AutoResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, p := range r.Profiles {
+		score := ""
+		if p.Rank > 0 {
+			score = ns(p.ScoreNS)
+		}
+		rows = append(rows, append([]string{strconv.Itoa(p.Rank), p.Name, score, p.Skipped}, statsRow(p.Stats)...))
+	}
+	return rows
+}
+
+// InterfaceApply is the outcome of applying a profile to one interface.
+// AlreadyActive is set, and Applied not, when the interface already used
+// the profile's servers. Snapshot is the id of the snapshot taken before
+// applying. RolledBack is set when applying or verifying failed and the
+// previous settings were restored; Steps lists what was done.
+type InterfaceApply struct {
+	Interface     string                  `json:"interface"`
+	Applied       bool                    `json:"applied"`
+	AlreadyActive bool                    `json:"already_active"`
+	Snapshot      int                     `json:"snapshot,omitempty"`
+	RolledBack    bool                    `json:"rolled_back,omitempty"`
+	Message       string                  `json:"message,omitempty"`
+	Steps         []platformall.ApplyStep `json:"steps,omitempty"`
+}
+
+// ApplyResult is the output of apply, one entry per selected interface.
+// Applied is set when the profile was applied to any interface,
+// AlreadyActive when all of them used it already; Interface and Message
+// repeat the entry when there is only one.
+// Confirmation is "confirmed" or "reverted" with --confirm-within;
+// Verification is left out when nothing was applied.
+type ApplyResult struct {
+	Profile       string                 `json:"profile"`
+	Interface     string                 `json:"interface"`
+	Servers       []string               `json:"servers"`
+	Applied       bool                   `json:"applied"`
+	AlreadyActive bool                   `json:"already_active"`
+	Message       string                 `json:"message,omitempty"`
+	Confirmation  string                 `json:"confirmation,omitempty"`
+	Interfaces    []InterfaceApply       `json:"interfaces"`
+	Verification  *resolver.HealthReport `json:"verification,omitempty"`
+}
+
+// MarshalJSON fills the fields summing up the interfaces
+func (r ApplyResult) MarshalJSON() ([]byte, error) {
+	type plain ApplyResult
+	r.AlreadyActive = len(r.Interfaces) > 0
+	for _, i := range r.Interfaces {
+		r.Applied = r.Applied || i.Applied
+		r.AlreadyActive = r.AlreadyActive && i.AlreadyActive
+	}
+	if len(r.Interfaces) == 1 {
+		r.Interface, r.Message = r.Interfaces[0].Interface, r.Interfaces[0].Message
+	}
+	return json.Marshal(plain(r))
+}
+
+func (r ApplyResult) Header() []string {
+	return []string{"profile", "interface", "servers", "applied", "already_active", "message", "rolled_back"}
+}
+
+func (r ApplyResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, i := range r.Interfaces {
+		rows = append(rows, []string{r.Profile, i.Interface, join(r.Servers),
+			strconv.FormatBool(i.Applied), strconv.FormatBool(i.AlreadyActive), i.Message, strconv.FormatBool(i.RolledBack)})
+	}
+	return rows
+}
+
+// InterfaceRollback is the outcome of a rollback of one interface
+type InterfaceRollback struct {
+	Interface string `json:"interface"`
+	Message   string `json:"message"`
+}
+
+// RollbackResult is the output of rollback, one entry per selected
+// interface; Interface and Message repeat the entry when there is only
+// one. Snapshot is the id restored with --to.
+type RollbackResult struct {
+	Interface  string              `json:"interface,omitempty"`
+	Snapshot   int                 `json:"snapshot,omitempty"`
+	Message    string              `json:"message"`
+	Interfaces []InterfaceRollback `json:"interfaces"`
+}
+
+// MarshalJSON fills Interface and Message from a single interface
+func (r RollbackResult) MarshalJSON() ([]byte, error) {
+	type plain RollbackResult
+	if len(r.Interfaces) == 1 {
+		r.Interface, r.Message = r.Interfaces[0].Interface, r.Interfaces[0].Message
+	}
+	return json.Marshal(plain(r))
+}
+
+func (r RollbackResult) Header() []string { return []string{"interface", "snapshot", "message"} }
+
+func (r RollbackResult) Rows() [][]string {
+	snap := ""
+	if r.Snapshot > 0 {
+		snap = strconv.Itoa(r.Snapshot)
+	}
+	rows := [][]string{}
+	for _, i := range r.Interfaces {
+		rows = append(rows, []string{i.Interface, snap, i.Message})
+	}
+	return rows
+}
+
+// SnapshotsResult is the output of rollback --list, newest first
+type SnapshotsResult struct {
+	Snapshots []platformall.Snapshot `json:"snapshots"`
+}
+
+func (r SnapshotsResult) Header() []string {
+	return []string{"id", "created_at", "interface", "backend", "profile", "state"}
+}
+
+func (r SnapshotsResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, s := range r.Snapshots {
+		rows = append(rows, []string{strconv.Itoa(s.ID), s.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			s.State.Interface, s.State.Backend, s.Profile, s.State.String()})
+	}
+	return rows
+}
+
+// HealthResult is the output of apply --verify-only: the servers the
+// selected interfaces use and the health check through them
+type HealthResult struct {
+	Interfaces   []InterfaceDNS        `json:"interfaces"`
+	Verification resolver.HealthReport `json:"verification"`
+}
+
+func (r HealthResult) Header() []string {
+	return []string{"path", "query", "ok", "rtt_ns", "error"}
+}
+
+func (r HealthResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, c := range r.Verification.Checks {
+		errMsg := ""
+		if c.Result.Error != nil {
+			errMsg = c.Result.Error.Error()
+		}
+		rows = append(rows, []string{c.Path, c.Result.Query, strconv.FormatBool(c.Ok), ns(int64(c.Result.RTT)), errMsg})
+	}
+	return rows
+}
+
+// ConfirmResult is the output of confirm: the applies that are kept
+type ConfirmResult struct {
+	Confirmed []platformall.PendingApply `json:"confirmed"`
+}
+
+func (r ConfirmResult) Header() []string {
+	return []string{"interface", "profile", "snapshot", "deadline"}
+}
+
+func (r ConfirmResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, p := range r.Confirmed {
+		rows = append(rows, []string{p.Interface, p.Profile, strconv.Itoa(p.Snapshot), p.Deadline.Format("2006-01-02T15:04:05Z07:00")})
+	}
+	return rows
+}
+
+// InterfaceDNS is the DNS servers an interface uses
+type InterfaceDNS struct {
+	Interface string   `json:"interface"`
+	IPv4      []string `json:"ipv4"`
+	IPv6      []string `json:"ipv6"`
+}
+
+// NewInterfaceDNS splits the servers of iface by address family
+func NewInterfaceDNS(iface string, servers []string) InterfaceDNS {
+	r := InterfaceDNS{Interface: iface, IPv4: []string{}, IPv6: []string{}}
+	for _, s := range servers {
+		if strings.Contains(s, ":") {
+			r.IPv6 = append(r.IPv6, s)
+		} else {
+			r.IPv4 = append(r.IPv4, s)
+		}
+	}
+	return r
+}
+
+// StatusResult is the output of status, one entry per selected interface,
+// and the local proxy when `serve` is running. Interface, IPv4 and IPv6
+// repeat the entry when there is only one. CSV has the interfaces only.
+type StatusResult struct {
+	Interface  string         `json:"interface"`
+	IPv4       []string       `json:"ipv4"`
+	IPv6       []string       `json:"ipv6"`
+	Interfaces []InterfaceDNS `json:"interfaces"`
+	Proxy      *proxy.State   `json:"proxy,omitempty"`
+}
+
+// MarshalJSON fills Interface, IPv4 and IPv6 from a single interface
+func (r StatusResult) MarshalJSON() ([]byte, error) {
+	type plain StatusResult
+	r.IPv4, r.IPv6 = []string{}, []string{}
+	if len(r.Interfaces) == 1 {
+		first := r.Interfaces[0]
+		r.Interface, r.IPv4, r.IPv6 = first.Interface, list(first.IPv4), list(first.IPv6)
+	}
+	return json.Marshal(plain(r))
+}
+
+func (r StatusResult) Header() []string { return []string{"interface", "family", "server"} }
+
+func (r StatusResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, i := range r.Interfaces {
+		for _, s := range i.IPv4 {
+			rows = append(rows, []string{i.Interface, "ipv4", s})
+		}
+		for _, s := range i.IPv6 {
+			rows = append(rows, []string{i.Interface, "ipv6", s})
+		}
+	}
+	return rows
+}
+
+// InterfacesResult is the output of interfaces
+type InterfacesResult struct {
+	Interfaces []platformall.InterfaceInfo `json:"interfaces"`
+}
+
+func (r InterfacesResult) Header() []string {
+	return []string{"name", "index", "admin_state", "state", "type", "mac", "ipv4", "ipv6", "dns", "dns_config"}
+}
+
+func (r InterfacesResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, i := range r.Interfaces {
+		config := ""
+		if i.DNSConfig != nil {
+			config = i.DNSConfig.String()
+		}
+		rows = append(rows, []string{i.Name, strconv.Itoa(i.Index), i.AdminState, i.State, i.Type,
+			i.MAC, join(i.IPv4), join(i.IPv6), join(i.DNS), config})
+	}
+	return rows
+}
+
+// DeleteEntry is the outcome of deleting one profile; Reason says why it was not deleted
+type DeleteEntry struct {
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+	Forced  bool   `json:"forced"`
+	Active  bool   `json:"active"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// DeleteResult is the output of delete-profile, one entry per name in order
+type DeleteResult struct {
+	Profiles []DeleteEntry `json:"profiles"`
+}
+
+func (r DeleteResult) Header() []string {
+	return []string{"name", "deleted", "forced", "active", "reason"}
+}
+
+func (r DeleteResult) Rows() [][]string {
+	rows := [][]string{}
+	for _, e := range r.Profiles {
+		rows = append(rows, []string{e.Name, strconv.FormatBool(e.Deleted),
+			strconv.FormatBool(e.Forced), strconv.FormatBool(e.Active), e.Reason})
+	}
+	return rows
+}
+
+// VerifyReport is the output of verify. Suspicious counts the results
+// pointing to tampering, Incomplete those that could not be decided.
+type VerifyReport struct {
+	Reference  string                  `json:"reference"`
+	Suspicious int                     `json:"suspicious"`
+	Incomplete int                     `json:"incomplete"`
+	Results    []resolver.VerifyResult `json:"results"`
+}
+
+// NewVerifyReport counts the verdicts of results
+func NewVerifyReport(reference resolver.Target, results []resolver.VerifyResult) VerifyReport {
+	r := VerifyReport{Reference: reference.String(), Results: results}
+	if r.Results == nil {
+		r.Results = []resolver.VerifyResult{}
+	}
+	for _, v := range results {
+		if v.Verdict.Suspicious() {
+			r.Suspicious++
+		} else if v.Verdict != resolver.VerdictOK {
+			r.Incomplete++
+		}
+	}
+	return r
+}
+
+func (r VerifyReport) Header() []string {
+	return []string{"profile", "server", "query", "check", "verdict", "rtt_ns", "answers", "reference", "detail"}
+}
+
+func (r VerifyReport) Rows() [][]string {
+	rows := [][]string{}
+	for _, v := range r.Results {
+		rows = append(rows, []string{v.Profile, v.Result.Server, v.Result.Query, string(v.Check),
+			string(v.Verdict), ns(v.Result.RTT.Nanoseconds()), join(v.Answers), join(v.Reference), v.Detail})
+	}
+	return rows
+}
+
+// statsRow formats the columns sent through jitter_ns of serverHeader
+func statsRow(st resolver.Stats) []string {
+	return []string{strconv.Itoa(st.Sent), strconv.Itoa(st.Received), fmt.Sprintf("%.4f", st.Loss),
+		ns(st.Min.Nanoseconds()), ns(st.Median.Nanoseconds()), ns(st.P90.Nanoseconds()), ns(st.P99.Nanoseconds()),
+		ns(st.Mean.Nanoseconds()), ns(st.StdDev.Nanoseconds()), ns(st.Jitter.Nanoseconds())}
+}
+
+func ns(n int64) string { return strconv.FormatInt(n, 10) }
+
+// join puts a list in one CSV cell, space separated
+func join(items []string) string { return strings.Join(items, " ") }
+
+// list returns items, or an empty list instead of nil
+func list(items []string) []string {
+	if items == nil {
+		return []string{}
+	}
+	return items
+}
@@ -0,0 +1,345 @@
+package platform_all
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// Backend is a mechanism that can read and change the DNS configuration of
+// the host (netsh on Windows, resolv.conf on Linux, ...).
+// The package-level ApplyProfile, Rollback, GetCurrentDNS and
+// GetNetworkInterfaces functions dispatch to the active backend.
+type Backend interface {
+	Name() string
+	ApplyProfile(p config.Profile) (ApplyResult, error)
+	Rollback(iface string) (ApplyResult, error)
+	GetCurrentDNS(iface string) ([]string, error)
+	GetNetworkInterfaces() ([]string, error)
+
+	// Snapshot captures the DNS configuration of iface so Restore can
+	// bring it back exactly.
+	Snapshot(iface string) (DNSState, error)
+	Restore(state DNSState) (ApplyResult, error)
+}
+
+// HostWideBackend is implemented by backends whose configuration is shared
+// by every interface, e.g. resolv.conf. Snapshots of such a backend are
+// still taken per interface, so changing DNS on several interfaces at once
+// would restore the same file from several snapshots; ResolveInterfaces
+// refuses such selections.
+type HostWideBackend interface {
+	Backend
+	HostWide() bool
+}
+
+// FamilyState is the DNS configuration of one address family.
+// DHCP means servers are learned automatically (DHCP, RA, ...); Servers are
+// the statically configured servers in order.
+type FamilyState struct {
+	DHCP    bool     `json:"dhcp"`
+	Servers []string `json:"servers,omitempty"`
+}
+
+// DNSState is the DNS configuration of an interface as seen by a backend
+type DNSState struct {
+	Interface string      `json:"interface"`
+	Backend   string      `json:"backend"`
+	IPv4      FamilyState `json:"ipv4"`
+	IPv6      FamilyState `json:"ipv6"`
+}
+
+// String describes the state in one line, e.g. "IPv4 static [1.1.1.1], IPv6 DHCP"
+func (s DNSState) String() string {
+	return fmt.Sprintf("IPv4 %s, IPv6 %s", s.IPv4, s.IPv6)
+}
+
+func (f FamilyState) String() string {
+	switch {
+	case f.DHCP && len(f.Servers) > 0:
+		return fmt.Sprintf("DHCP + static %v", f.Servers)
+	case f.DHCP:
+		return "DHCP"
+	case len(f.Servers) == 0:
+		return "none"
+	default:
+		return fmt.Sprintf("static %v", f.Servers)
+	}
+}
+
+// backendFactories maps backend names to their constructors
+var backendFactories = map[string]func() Backend{
+	"netsh":      func() Backend { return NetshBackend{} },
+	"powershell": func() Backend { return PowerShellBackend{} },
+	"resolvconf": func() Backend { return NewResolvConfBackend(ResolvConfPath) },
+	"resolvectl": func() Backend { return ResolvectlBackend{} },
+	"nmcli":      func() Backend { return NmcliBackend{} },
+}
+
+// NetworkManagerRuntimeDir exists while NetworkManager is running
+var NetworkManagerRuntimeDir = "/run/NetworkManager"
+
+// ResolvedRuntimeDir exists while systemd-resolved is running
+var ResolvedRuntimeDir = "/run/systemd/resolve"
+
+var active Backend
+
+// BackendNames returns the sorted names of all known backends
+func BackendNames() []string {
+	var names []string
+	for name := range backendFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackend builds the backend registered under name.
+// An empty name or "auto" returns DefaultBackend.
+func NewBackend(name string) (Backend, error) {
+	if name == "" || name == "auto" {
+		return DefaultBackend(), nil
+	}
+	factory, ok := backendFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend '%s' (available: %v)", name, BackendNames())
+	}
+	return factory(), nil
+}
+
+// DefaultBackend picks the backend that fits the running operating system
+func DefaultBackend() Backend {
+	switch runtime.GOOS {
+	case "windows":
+		return NetshBackend{}
+	case "linux":
+		if _, err := os.Stat(NetworkManagerRuntimeDir); err == nil {
+			return NmcliBackend{}
+		}
+		if _, err := os.Stat(ResolvedRuntimeDir); err == nil {
+			return ResolvectlBackend{}
+		}
+		return NewResolvConfBackend(ResolvConfPath)
+	default:
+		return unsupportedBackend{}
+	}
+}
+
+// UseBackend selects the backend used by the package-level functions by name
+func UseBackend(name string) error {
+	b, err := NewBackend(name)
+	if err != nil {
+		return err
+	}
+	active = b
+	return nil
+}
+
+// SetBackend installs b as the active backend.
+// Mainly useful for tests that need a fake implementation.
+func SetBackend(b Backend) {
+	active = b
+}
+
+// CurrentBackend returns the active backend, falling back to DefaultBackend
+func CurrentBackend() Backend {
+	if active == nil {
+		active = DefaultBackend()
+	}
+	return active
+}
+
+// Steps of a transactional ApplyProfile, in order
+const (
+	StepSnapshot = "snapshot"
+	StepApply    = "apply"
+	StepVerify   = "verify"
+	StepRestore  = "restore" // only after a failed step
+)
+
+// ApplyStep is the outcome of one step of ApplyProfile
+type ApplyStep struct {
+	Name    string `json:"name"`
+	Ok      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+func (r *ApplyResult) step(name string, ok bool, message string) {
+	r.Steps = append(r.Steps, ApplyStep{Name: name, Ok: ok, Message: message})
+}
+
+// ApplyProfile applies the profile's DNS servers to p.Interface using the
+// active backend, as a transaction: the previous configuration of the
+// interface is saved as a snapshot, the servers are applied and read back
+// with GetCurrentDNS. When applying or verifying fails, the snapshot is
+// restored and dropped again, so the interface is never left half
+// configured. The returned result lists every step taken.
+func ApplyProfile(p config.Profile) (ApplyResult, error) {
+	b := CurrentBackend()
+	if p.Interface == "" {
+		return b.ApplyProfile(p)
+	}
+
+	var res ApplyResult
+	state, err := b.Snapshot(p.Interface)
+	if err != nil {
+		res.step(StepSnapshot, false, err.Error())
+		return res, fmt.Errorf("cannot snapshot current DNS settings: %v", err)
+	}
+	snap, err := SaveSnapshot(state, p.Name)
+	if err != nil {
+		res.step(StepSnapshot, false, err.Error())
+		return res, err
+	}
+	res.Snapshot = snap.ID
+	res.step(StepSnapshot, true, fmt.Sprintf("saved snapshot %d: %s", snap.ID, state))
+
+	applied, err := b.ApplyProfile(p)
+	if err != nil {
+		res.step(StepApply, false, err.Error())
+		return abortApply(b, res, snap, fmt.Errorf("cannot apply profile '%s' to %s: %v", p.Name, p.Interface, err))
+	}
+	res.step(StepApply, true, applied.Message)
+
+	current, err := b.GetCurrentDNS(p.Interface)
+	if err == nil {
+		err = verifyServers(p.AllServers(), current)
+	}
+	if err != nil {
+		res.step(StepVerify, false, err.Error())
+		return abortApply(b, res, snap, fmt.Errorf("profile '%s' not in effect on %s: %v", p.Name, p.Interface, err))
+	}
+	res.step(StepVerify, true, fmt.Sprintf("%s uses %v", p.Interface, validIPs(current)))
+
+	res.Ok, res.Message = true, applied.Message
+	return res, nil
+}
+
+// abortApply restores snap after a failed step of ApplyProfile and drops it
+// from the history, since the interface is back in the state it describes.
+// If restoring fails as well, the snapshot is kept for a later rollback.
+func abortApply(b Backend, res ApplyResult, snap Snapshot, cause error) (ApplyResult, error) {
+	restored, err := b.Restore(snap.State)
+	if err != nil {
+		res.step(StepRestore, false, err.Error())
+		return res, fmt.Errorf("%v; restoring the previous settings failed too: %v (snapshot %d kept)", cause, err, snap.ID)
+	}
+	res.step(StepRestore, true, restored.Message)
+	res.RolledBack = true
+
+	if err := DropSnapshotsFrom(snap); err != nil {
+		return res, fmt.Errorf("%v; previous settings restored, but %v", cause, err)
+	}
+	return res, fmt.Errorf("%v; previous settings restored", cause)
+}
+
+// verifyServers checks that every expected server is among the servers an
+// interface reports to use
+func verifyServers(expected, current []string) error {
+	inUse := make(map[string]bool)
+	for _, ip := range validIPs(current) {
+		inUse[net.ParseIP(ip).String()] = true
+	}
+
+	var missing []string
+	for _, s := range expected {
+		if ip := net.ParseIP(s); ip == nil || !inUse[ip.String()] {
+			missing = append(missing, s)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("servers %v missing, interface uses %v", missing, validIPs(current))
+	}
+	return nil
+}
+
+// Rollback restores the DNS settings iface had before the last ApplyProfile
+// and drops that snapshot, so repeated rollbacks walk back through history.
+// Without a snapshot the backend's own reset (e.g. back to DHCP) is used.
+func Rollback(iface string) (ApplyResult, error) {
+	b := CurrentBackend()
+
+	snaps, err := ListSnapshots(iface)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+	for _, s := range snaps {
+		if s.State.Backend == b.Name() {
+			return RollbackTo(s.ID)
+		}
+	}
+
+	return b.Rollback(iface)
+}
+
+// RollbackTo restores the snapshot with the given id and drops it together
+// with all newer snapshots of the same interface.
+func RollbackTo(id int) (ApplyResult, error) {
+	snap, err := FindSnapshot(id)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	b := CurrentBackend()
+	if snap.State.Backend != b.Name() {
+		return ApplyResult{Ok: false}, fmt.Errorf("snapshot %d was taken with backend '%s', current backend is '%s'", id, snap.State.Backend, b.Name())
+	}
+
+	res, err := b.Restore(snap.State)
+	if err != nil {
+		return res, err
+	}
+	if err := DropSnapshotsFrom(snap); err != nil {
+		return res, err
+	}
+
+	res.Message = fmt.Sprintf("Rollback successful – restored snapshot %d on %s (%s)", snap.ID, snap.State.Interface, snap.State)
+	return res, nil
+}
+
+// GetCurrentDNS returns the DNS servers currently configured on iface
+func GetCurrentDNS(iface string) ([]string, error) {
+	return CurrentBackend().GetCurrentDNS(iface)
+}
+
+// GetNetworkInterfaces returns the names of the active network interfaces
+func GetNetworkInterfaces() ([]string, error) {
+	return CurrentBackend().GetNetworkInterfaces()
+}
+
+// unsupportedBackend is used on operating systems without a DNS backend
+type unsupportedBackend struct{}
+
+func (unsupportedBackend) Name() string { return "unsupported" }
+
+func (unsupportedBackend) ApplyProfile(config.Profile) (ApplyResult, error) {
+	return ApplyResult{Ok: false}, errUnsupported()
+}
+
+func (unsupportedBackend) Rollback(string) (ApplyResult, error) {
+	return ApplyResult{Ok: false}, errUnsupported()
+}
+
+func (unsupportedBackend) GetCurrentDNS(string) ([]string, error) {
+	return nil, errUnsupported()
+}
+
+func (unsupportedBackend) GetNetworkInterfaces() ([]string, error) {
+	return nil, errUnsupported()
+}
+
+func (unsupportedBackend) Snapshot(string) (DNSState, error) {
+	return DNSState{}, errUnsupported()
+}
+
+func (unsupportedBackend) Restore(DNSState) (ApplyResult, error) {
+	return ApplyResult{Ok: false}, errUnsupported()
+}
+
+func errUnsupported() error {
+	return fmt.Errorf("no DNS backend available on %s", runtime.GOOS)
+}
@@ -0,0 +1,159 @@
+package platform_all
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// AutoInterface is the interface spec selecting the interface of the default route
+const AutoInterface = "auto"
+
+// InterfaceSelection describes which interfaces a command acts on
+type InterfaceSelection struct {
+	// Spec is an interface name, AutoInterface or a glob pattern such as
+	// "Wi-Fi*"; empty asks the user unless NonInteractive is set
+	Spec           string
+	All            bool // every active interface, Spec is ignored
+	NonInteractive bool // fail instead of prompting
+	// Change marks a selection whose DNS is about to be changed; a
+	// HostWideBackend then accepts a single interface only
+	Change bool
+}
+
+// DefaultRouteInterface returns the interface traffic to the internet leaves through
+var DefaultRouteInterface = defaultRouteInterface
+
+// PromptInterface asks the user to choose one of interfaces
+var PromptInterface = promptInterface
+
+// PromptIn and PromptOut are the streams PromptInterface reads and writes
+var (
+	PromptIn  io.Reader = os.Stdin
+	PromptOut io.Writer = os.Stdout
+)
+
+// ResolveInterfaces returns the interfaces sel selects, in the order of
+// GetNetworkInterfaces for patterns. A plain name is returned as is, even
+// when the backend does not list it.
+func ResolveInterfaces(sel InterfaceSelection) ([]string, error) {
+	ifaces, err := resolveInterfaces(sel)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := CurrentBackend().(HostWideBackend); ok && b.HostWide() && sel.Change && len(ifaces) > 1 {
+		return nil, fmt.Errorf("the %s backend configures DNS for the whole host, select a single interface instead of %s",
+			b.Name(), strings.Join(ifaces, ", "))
+	}
+	return ifaces, nil
+}
+
+func resolveInterfaces(sel InterfaceSelection) ([]string, error) {
+	switch {
+	case sel.All:
+		return GetNetworkInterfaces()
+	case sel.Spec == AutoInterface:
+		iface, err := DefaultRouteInterface()
+		if err != nil {
+			return nil, err
+		}
+		return []string{iface}, nil
+	case isPattern(sel.Spec):
+		interfaces, err := GetNetworkInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		var matched []string
+		for _, name := range interfaces {
+			if ok, _ := path.Match(sel.Spec, name); ok {
+				matched = append(matched, name)
+			}
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no interface matches '%s' (available: %s)", sel.Spec, strings.Join(interfaces, ", "))
+		}
+		return matched, nil
+	case sel.Spec != "":
+		return []string{sel.Spec}, nil
+	case sel.NonInteractive:
+		return nil, fmt.Errorf("no interface given: use --iface NAME, --iface %s, --iface PATTERN or --all-interfaces", AutoInterface)
+	}
+
+	interfaces, err := GetNetworkInterfaces()
+	if err != nil || len(interfaces) == 0 {
+		return nil, fmt.Errorf("No network interfaces detected.")
+	}
+	iface, err := PromptInterface(interfaces)
+	if err != nil {
+		return nil, err
+	}
+	return []string{iface}, nil
+}
+
+// isPattern reports whether spec uses glob syntax
+func isPattern(spec string) bool {
+	if !strings.ContainsAny(spec, "*?[") {
+		return false
+	}
+	_, err := path.Match(spec, "")
+	return err == nil
+}
+
+// promptInterface prints a numbered menu of interfaces and reads the choice
+func promptInterface(interfaces []string) (string, error) {
+	fmt.Fprintln(PromptOut, "Available network interfaces:")
+	for i, name := range interfaces {
+		fmt.Fprintf(PromptOut, " [%d] %s\n", i+1, name)
+	}
+
+	fmt.Fprint(PromptOut, "Select interface number: ")
+	var choice int
+	fmt.Fscanln(PromptIn, &choice)
+
+	if choice < 1 || choice > len(interfaces) {
+		return "", fmt.Errorf("Invalid choice")
+	}
+	return interfaces[choice-1], nil
+}
+
+// defaultRouteInterface finds the interface holding the source address the
+// host would use to reach a public address; connecting a UDP socket sends
+// nothing but makes the kernel pick the route
+func defaultRouteInterface() (string, error) {
+	for _, probe := range []string{"192.0.2.1:53", "[2001:db8::1]:53"} {
+		conn, err := net.Dial("udp", probe)
+		if err != nil {
+			continue
+		}
+		local := conn.LocalAddr().(*net.UDPAddr).IP
+		conn.Close()
+
+		if name, ok := interfaceWithIP(local); ok {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no interface with a default route found")
+}
+
+// interfaceWithIP returns the name of the interface ip is assigned to
+func interfaceWithIP(ip net.IP) (string, bool) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", false
+	}
+	for _, i := range ifaces {
+		addrs, err := i.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return i.Name, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,186 @@
+package platform_all
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Interface states and types of InterfaceInfo
+const (
+	AdminEnabled  = "enabled"
+	AdminDisabled = "disabled"
+
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+
+	TypeEthernet = "ethernet"
+	TypeWireless = "wireless"
+	TypeLoopback = "loopback"
+	TypeVirtual  = "virtual"
+	TypeOther    = "other"
+)
+
+// InterfaceInfo describes a network interface and its DNS configuration.
+// Addresses are in CIDR form; DNS holds the servers in use and DNSConfig
+// how they are configured, when the backend can tell.
+type InterfaceInfo struct {
+	Name       string    `json:"name"`
+	Index      int       `json:"index"`
+	AdminState string    `json:"admin_state"`
+	State      string    `json:"state"`
+	Type       string    `json:"type"`
+	MAC        string    `json:"mac,omitempty"`
+	IPv4       []string  `json:"ipv4"`
+	IPv6       []string  `json:"ipv6"`
+	DNS        []string  `json:"dns"`
+	DNSConfig  *DNSState `json:"dns_config,omitempty"`
+}
+
+// InterfaceReporter is implemented by backends that know the interfaces
+// better than the host's generic view, e.g. disabled adapters on Windows.
+// The reported names and states take precedence; index, MAC, addresses
+// and a type reported as TypeOther are filled in from HostInterfaces.
+type InterfaceReporter interface {
+	ReportInterfaces() ([]InterfaceInfo, error)
+}
+
+// HostInterfaces returns the interfaces as seen by the operating system
+var HostInterfaces = hostInterfaces
+
+// SysClassNet is where Linux describes its network interfaces
+var SysClassNet = "/sys/class/net"
+
+// ListInterfaces returns every network interface with its addresses and,
+// for connected ones, the DNS configuration reported by the active backend
+func ListInterfaces() ([]InterfaceInfo, error) {
+	infos, err := HostInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	b := CurrentBackend()
+	if r, ok := b.(InterfaceReporter); ok {
+		reported, err := r.ReportInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		infos = mergeInterfaces(reported, infos)
+	}
+
+	for i := range infos {
+		info := &infos[i]
+		if info.State != StateConnected || info.Type == TypeLoopback {
+			continue
+		}
+		if servers, err := b.GetCurrentDNS(info.Name); err == nil {
+			info.DNS = append(info.DNS, validIPs(servers)...)
+		}
+		if state, err := b.Snapshot(info.Name); err == nil {
+			info.DNSConfig = &state
+		}
+	}
+	return infos, nil
+}
+
+// mergeInterfaces completes the reported interfaces with the host data of
+// the interface of the same name, keeping the reported order
+func mergeInterfaces(reported, host []InterfaceInfo) []InterfaceInfo {
+	byName := make(map[string]InterfaceInfo, len(host))
+	for _, h := range host {
+		byName[h.Name] = h
+	}
+
+	out := make([]InterfaceInfo, 0, len(reported))
+	for _, r := range reported {
+		if h, ok := byName[r.Name]; ok {
+			r.Index, r.MAC, r.IPv4, r.IPv6 = h.Index, h.MAC, h.IPv4, h.IPv6
+			if r.Type == TypeOther {
+				r.Type = h.Type
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// hostInterfaces converts net.Interfaces
+func hostInterfaces() ([]InterfaceInfo, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]InterfaceInfo, 0, len(ifaces))
+	for _, i := range ifaces {
+		info := newInterfaceInfo(i.Name)
+		info.Index = i.Index
+		info.MAC = i.HardwareAddr.String()
+		if i.Flags&net.FlagUp != 0 {
+			info.AdminState = AdminEnabled
+		}
+		if i.Flags&net.FlagRunning != 0 {
+			info.State = StateConnected
+		}
+		info.Type = interfaceType(i)
+
+		addrs, _ := i.Addrs()
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if n.IP.To4() != nil {
+				info.IPv4 = append(info.IPv4, n.String())
+			} else {
+				info.IPv6 = append(info.IPv6, n.String())
+			}
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// newInterfaceInfo returns a disabled, disconnected interface with empty lists
+func newInterfaceInfo(name string) InterfaceInfo {
+	return InterfaceInfo{
+		Name:       name,
+		AdminState: AdminDisabled,
+		State:      StateDisconnected,
+		Type:       TypeOther,
+		IPv4:       []string{},
+		IPv6:       []string{},
+		DNS:        []string{},
+	}
+}
+
+// interfaceType guesses the type from the flags and, on Linux, from SysClassNet
+func interfaceType(i net.Interface) string {
+	if i.Flags&net.FlagLoopback != 0 {
+		return TypeLoopback
+	}
+	dir := filepath.Join(SysClassNet, i.Name)
+	if _, err := os.Stat(filepath.Join(dir, "wireless")); err == nil {
+		return TypeWireless
+	}
+	if target, err := os.Readlink(dir); err == nil && strings.Contains(target, "/virtual/") {
+		return TypeVirtual
+	}
+	if len(i.HardwareAddr) == 6 {
+		return TypeEthernet
+	}
+	return TypeOther
+}
+
+// validIPs keeps the entries that are IP addresses, without IPv6 zones
+func validIPs(list []string) []string {
+	var ips []string
+	for _, entry := range list {
+		ip, _, _ := strings.Cut(strings.TrimSpace(entry), "%")
+		if net.ParseIP(ip) != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,85 @@
+package platform_all
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockTimeout bounds how long a run waits for another one to release the
+// state lock
+var LockTimeout = 10 * time.Second
+
+// staleLock is the age after which a lock left by a run that died while
+// holding it is broken. The lock is only held while the state files are
+// read and written, never while DNS is changed.
+const staleLock = 30 * time.Second
+
+// lockPath returns the lock file guarding snapshots.json and pending.json
+func lockPath() string {
+	return filepath.Join(filepath.Dir(SnapshotPath), "state.lock")
+}
+
+// withStateLock runs fn while holding the state lock, so the
+// read-modify-write of the snapshot history and the pending applies by
+// concurrent runs (apply, a running serve or daemon, ...) do not lose each
+// other's changes. fn must not take the lock again.
+func withStateLock(fn func() error) error {
+	path := lockPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cannot create state directory: %v", err)
+	}
+
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("cannot lock %s: %v", path, err)
+		}
+		if st, err := os.Stat(path); err == nil && time.Since(st.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cannot lock %s: held by another run for more than %s", path, LockTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer os.Remove(path)
+
+	return fn()
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory, so readers never see a partly written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,187 @@
+package platform_all
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// PendingPath is the JSON file holding the applies awaiting confirmation.
+// Empty means pending.json next to SnapshotPath.
+var PendingPath string
+
+// PendingApply is a profile applied with a dead man's switch: unless it is
+// confirmed before Deadline, Snapshot is restored. It is kept on disk so
+// the next run of the tool reverts it if the waiting process died.
+//
+// Owner is set for an apply kept in effect only while a command runs, such
+// as serve, which renews its deadline. Such applies are neither confirmed
+// nor reverted by ConfirmPending and RevertPending, only by RevertExpired
+// once their owner stopped renewing them.
+type PendingApply struct {
+	Interface string    `json:"interface"`
+	Profile   string    `json:"profile"`
+	Snapshot  int       `json:"snapshot"`
+	Deadline  time.Time `json:"deadline"`
+	Owner     string    `json:"owner,omitempty"`
+}
+
+// pendingPath returns PendingPath or its default
+func pendingPath() string {
+	if PendingPath != "" {
+		return PendingPath
+	}
+	return filepath.Join(filepath.Dir(SnapshotPath), "pending.json")
+}
+
+// LoadPending reads the applies awaiting confirmation
+func LoadPending() ([]PendingApply, error) {
+	var pending []PendingApply
+	data, err := os.ReadFile(pendingPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pending, nil
+		}
+		return nil, fmt.Errorf("cannot read pending applies: %v", err)
+	}
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("cannot parse pending applies %s: %v", pendingPath(), err)
+	}
+	return pending, nil
+}
+
+// writePending replaces the pending applies on disk, removing the file
+// when none are left. Callers hold the state lock.
+func writePending(pending []PendingApply) error {
+	path := pendingPath()
+	if len(pending) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove pending applies: %v", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cannot create pending directory: %v", err)
+	}
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("cannot write pending applies: %v", err)
+	}
+	return nil
+}
+
+// AddPending records an apply awaiting confirmation
+func AddPending(p PendingApply) error {
+	return withStateLock(func() error {
+		pending, err := LoadPending()
+		if err != nil {
+			return err
+		}
+		return writePending(append(pending, p))
+	})
+}
+
+// ConfirmPending keeps the pending applies of iface, or of every interface
+// when iface is empty, and returns them. Owned applies are left alone.
+func ConfirmPending(iface string) ([]PendingApply, error) {
+	return takePending(func(p PendingApply) bool { return p.Owner == "" && (iface == "" || p.Interface == iface) })
+}
+
+// RevertPending restores the snapshots of the pending applies of iface, or
+// of every interface when iface is empty. Owned applies are left alone.
+func RevertPending(iface string) ([]ApplyResult, error) {
+	return revertPending(func(p PendingApply) bool { return p.Owner == "" && (iface == "" || p.Interface == iface) })
+}
+
+// RenewPending moves the deadline of the pending applies of the given
+// snapshots to deadline. A process keeping applies in effect only while it
+// runs renews them, so they are reverted once it died.
+func RenewPending(snapshots []int, deadline time.Time) error {
+	return withStateLock(func() error {
+		pending, err := LoadPending()
+		if err != nil {
+			return err
+		}
+		for i := range pending {
+			if slices.Contains(snapshots, pending[i].Snapshot) {
+				pending[i].Deadline = deadline
+			}
+		}
+		return writePending(pending)
+	})
+}
+
+// RemovePending forgets the pending applies of the given snapshots, once
+// their owner restored them itself
+func RemovePending(snapshots []int) error {
+	_, err := takePending(func(p PendingApply) bool { return slices.Contains(snapshots, p.Snapshot) })
+	return err
+}
+
+// RevertExpired restores the snapshots of the pending applies whose
+// deadline passed before now
+func RevertExpired(now time.Time) ([]ApplyResult, error) {
+	return revertPending(func(p PendingApply) bool { return p.Deadline.Before(now) })
+}
+
+// takePending removes the pending applies matching match from the file and
+// returns them, so concurrent runs do not act on the same entry twice
+func takePending(match func(PendingApply) bool) ([]PendingApply, error) {
+	var taken []PendingApply
+	err := withStateLock(func() error {
+		pending, err := LoadPending()
+		if err != nil {
+			return err
+		}
+
+		var kept []PendingApply
+		for _, p := range pending {
+			if match(p) {
+				taken = append(taken, p)
+			} else {
+				kept = append(kept, p)
+			}
+		}
+		if len(taken) == 0 {
+			return nil
+		}
+		return writePending(kept)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return taken, nil
+}
+
+// revertPending restores the snapshots of the matching pending applies,
+// newest first, since restoring a snapshot drops the newer ones of the
+// interface. It goes on after a failure and returns the first error.
+func revertPending(match func(PendingApply) bool) ([]ApplyResult, error) {
+	taken, err := takePending(match)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(taken, func(i, j int) bool { return taken[i].Snapshot > taken[j].Snapshot })
+
+	var results []ApplyResult
+	var first error
+	for _, p := range taken {
+		res, err := RollbackTo(p.Snapshot)
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("cannot revert profile '%s' on %s: %v", p.Profile, p.Interface, err)
+			}
+			continue
+		}
+		res.RolledBack = true
+		results = append(results, res)
+	}
+	return results, first
+}
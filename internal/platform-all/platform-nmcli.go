@@ -0,0 +1,300 @@
+package platform_all
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+var NmcliExec = runNmcli
+
+// runNmcli executes `nmcli` with given arguments and returns combined
+// stdout/stderr output as string. LC_ALL=C keeps state names untranslated.
+func runNmcli(args ...string) (string, error) {
+	cmd := exec.Command("nmcli", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// NmcliBackend configures DNS on the NetworkManager connection profile that
+// is active on an interface, so NetworkManager does not overwrite the change.
+// All commands go through NmcliExec so they can be mocked in tests.
+type NmcliBackend struct{}
+
+func (NmcliBackend) Name() string { return "nmcli" }
+
+// nmDNSSettings are the DNS related properties of a NetworkManager connection.
+// TouchIPv4 and TouchIPv6 record whether the properties of a family are
+// written at all.
+type nmDNSSettings struct {
+	Connection     string
+	IPv4DNS        []string
+	IPv4IgnoreAuto bool
+	TouchIPv4      bool
+	IPv6DNS        []string
+	IPv6IgnoreAuto bool
+	TouchIPv6      bool
+}
+
+// ApplyProfile writes the profile's servers into the active connection of
+// p.Interface, disables DNS from DHCP/RA and reactivates the connection.
+// A family the profile has no servers for is left untouched.
+func (NmcliBackend) ApplyProfile(p config.Profile) (ApplyResult, error) {
+	iface := p.Interface
+	if iface == "" {
+		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
+	}
+
+	if len(p.AllServers()) == 0 {
+		return ApplyResult{Ok: false}, fmt.Errorf("no DNS servers provided")
+	}
+
+	uuid, err := nmActiveConnection(iface)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	v4, v6, _, _ := config.SplitFamilies(p.AllServers())
+	settings := nmDNSSettings{Connection: uuid}
+	if len(v4) > 0 {
+		settings.IPv4DNS = v4
+		settings.IPv4IgnoreAuto = true
+		settings.TouchIPv4 = true
+	}
+	if len(v6) > 0 {
+		settings.IPv6DNS = v6
+		settings.IPv6IgnoreAuto = true
+		settings.TouchIPv6 = true
+	}
+	if err := nmWriteSettings(settings); err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS applied to %s (connection %s): %v", iface, uuid, p.AllServers())}, nil
+}
+
+// Rollback clears the static servers of the active connection of iface and
+// lets it take DNS from DHCP/RA again.
+func (NmcliBackend) Rollback(iface string) (ApplyResult, error) {
+	if iface == "" {
+		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
+	}
+
+	uuid, err := nmActiveConnection(iface)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	if err := nmWriteSettings(nmDNSSettings{Connection: uuid, TouchIPv4: true, TouchIPv6: true}); err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("Rollback successful – connection %s of %s restored to automatic DNS", uuid, iface)}, nil
+}
+
+// Snapshot reads the DNS properties of the connection active on iface
+func (NmcliBackend) Snapshot(iface string) (DNSState, error) {
+	uuid, err := nmActiveConnection(iface)
+	if err != nil {
+		return DNSState{}, err
+	}
+
+	s, err := nmReadSettings(uuid)
+	if err != nil {
+		return DNSState{}, err
+	}
+
+	return DNSState{
+		Interface: iface,
+		Backend:   "nmcli",
+		IPv4:      FamilyState{DHCP: !s.IPv4IgnoreAuto, Servers: s.IPv4DNS},
+		IPv6:      FamilyState{DHCP: !s.IPv6IgnoreAuto, Servers: s.IPv6DNS},
+	}, nil
+}
+
+// Restore writes the captured properties back to the connection active on
+// the interface. IPv6 properties are only written when they changed.
+func (NmcliBackend) Restore(state DNSState) (ApplyResult, error) {
+	iface := state.Interface
+	uuid, err := nmActiveConnection(iface)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	current, err := nmReadSettings(uuid)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	settings := nmDNSSettings{
+		Connection:     uuid,
+		IPv4DNS:        state.IPv4.Servers,
+		IPv4IgnoreAuto: !state.IPv4.DHCP,
+		TouchIPv4:      true,
+		IPv6DNS:        state.IPv6.Servers,
+		IPv6IgnoreAuto: !state.IPv6.DHCP,
+	}
+	settings.TouchIPv6 = current.IPv6IgnoreAuto != settings.IPv6IgnoreAuto ||
+		!sameServers(current.IPv6DNS, settings.IPv6DNS)
+
+	if err := nmWriteSettings(settings); err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS of %s (connection %s) restored: %s", iface, uuid, state)}, nil
+}
+
+// GetCurrentDNS returns the DNS servers NetworkManager reports for the device
+func (NmcliBackend) GetCurrentDNS(iface string) ([]string, error) {
+	out, err := NmcliExec("-t", "-f", "IP4.DNS,IP6.DNS", "device", "show", iface)
+	if err != nil {
+		return nil, fmt.Errorf("nmcli error: %v, output: %s", err, out)
+	}
+
+	var servers []string
+	for _, fields := range parseNmcliTerse(out) {
+		if len(fields) == 2 && fields[1] != "" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers, nil
+}
+
+// GetNetworkInterfaces lists the devices NetworkManager reports as connected
+func (NmcliBackend) GetNetworkInterfaces() ([]string, error) {
+	out, err := NmcliExec("-t", "-f", "DEVICE,TYPE,STATE", "device", "status")
+	if err != nil {
+		return nil, fmt.Errorf("nmcli error: %v, output: %s", err, out)
+	}
+
+	var names []string
+	for _, fields := range parseNmcliTerse(out) {
+		if len(fields) == 3 && fields[1] != "loopback" && fields[2] == "connected" {
+			names = append(names, fields[0])
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no active interfaces found")
+	}
+	return names, nil
+}
+
+// nmActiveConnection returns the UUID of the connection active on iface
+func nmActiveConnection(iface string) (string, error) {
+	out, err := NmcliExec("-t", "-f", "UUID,DEVICE", "connection", "show", "--active")
+	if err != nil {
+		return "", fmt.Errorf("nmcli error: %v, output: %s", err, out)
+	}
+
+	for _, fields := range parseNmcliTerse(out) {
+		if len(fields) == 2 && fields[1] == iface {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no active NetworkManager connection on %s", iface)
+}
+
+// nmReadSettings reads the DNS properties of a connection profile
+func nmReadSettings(uuid string) (nmDNSSettings, error) {
+	out, err := NmcliExec("-t", "-f", "ipv4.dns,ipv4.ignore-auto-dns,ipv6.dns,ipv6.ignore-auto-dns", "connection", "show", uuid)
+	if err != nil {
+		return nmDNSSettings{}, fmt.Errorf("nmcli error: %v, output: %s", err, out)
+	}
+
+	s := nmDNSSettings{Connection: uuid}
+	for _, fields := range parseNmcliTerse(out) {
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "ipv4.dns":
+			s.IPv4DNS = splitNmcliList(fields[1])
+		case "ipv4.ignore-auto-dns":
+			s.IPv4IgnoreAuto = fields[1] == "yes"
+		case "ipv6.dns":
+			s.IPv6DNS = splitNmcliList(fields[1])
+		case "ipv6.ignore-auto-dns":
+			s.IPv6IgnoreAuto = fields[1] == "yes"
+		}
+	}
+	return s, nil
+}
+
+// nmWriteSettings modifies the connection and reactivates it.
+// The properties of a family are only touched when TouchIPv4 or TouchIPv6
+// is set; connections with IPv6 disabled may reject the IPv6 ones.
+func nmWriteSettings(s nmDNSSettings) error {
+	args := []string{"connection", "modify", s.Connection}
+	if s.TouchIPv4 {
+		args = append(args,
+			"ipv4.dns", strings.Join(s.IPv4DNS, ","),
+			"ipv4.ignore-auto-dns", nmBool(s.IPv4IgnoreAuto))
+	}
+	if s.TouchIPv6 {
+		args = append(args,
+			"ipv6.dns", strings.Join(s.IPv6DNS, ","),
+			"ipv6.ignore-auto-dns", nmBool(s.IPv6IgnoreAuto))
+	}
+
+	out, err := NmcliExec(args...)
+	if err != nil {
+		return fmt.Errorf("nmcli error: %v, output: %s", err, out)
+	}
+
+	out, err = NmcliExec("connection", "up", s.Connection)
+	if err != nil {
+		return fmt.Errorf("nmcli error: %v, output: %s", err, out)
+	}
+	return nil
+}
+
+func nmBool(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseNmcliTerse splits `nmcli -t` output into fields.
+// Colons inside values are escaped as "\:" and backslashes as "\\".
+func parseNmcliTerse(out string) [][]string {
+	var rows [][]string
+	for _, l := range strings.Split(out, "\n") {
+		l = strings.TrimRight(l, "\r")
+		if l == "" {
+			continue
+		}
+
+		var fields []string
+		var cur strings.Builder
+		for i := 0; i < len(l); i++ {
+			switch {
+			case l[i] == '\\' && i+1 < len(l):
+				i++
+				cur.WriteByte(l[i])
+			case l[i] == ':':
+				fields = append(fields, cur.String())
+				cur.Reset()
+			default:
+				cur.WriteByte(l[i])
+			}
+		}
+		rows = append(rows, append(fields, cur.String()))
+	}
+	return rows
+}
+
+// splitNmcliList splits a DNS list property; nmcli separates entries
+// with commas or (in older versions) spaces.
+func splitNmcliList(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+}
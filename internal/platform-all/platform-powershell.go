@@ -0,0 +1,287 @@
+package platform_all
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+var PowerShellExec = runPowerShell
+
+// runPowerShell runs script with Windows PowerShell and returns combined
+// stdout/stderr output as string. Output is forced to UTF-8 so interface
+// names survive any console code page.
+func runPowerShell(script string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command",
+		"[Console]::OutputEncoding = [Text.Encoding]::UTF8; $ErrorActionPreference = 'Stop'; "+script)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// PowerShellBackend drives the Windows DNS client through the DnsClient and
+// NetAdapter PowerShell modules, which answer in JSON and set both address
+// families in one call. All scripts go through PowerShellExec so they can
+// be mocked in tests.
+type PowerShellBackend struct{}
+
+func (PowerShellBackend) Name() string { return "powershell" }
+
+// Address families as numbered by Windows
+const (
+	psIPv4 = 2
+	psIPv6 = 23
+)
+
+// psServerAddress is one entry of Get-DnsClientServerAddress
+type psServerAddress struct {
+	InterfaceAlias  string
+	InterfaceIndex  int
+	AddressFamily   int
+	ServerAddresses []string
+}
+
+// psNetAdapter is one entry of Get-NetAdapter
+type psNetAdapter struct {
+	Name              string
+	InterfaceIndex    int
+	Status            string // Up, Disconnected, Disabled, ...
+	MacAddress        string // "3C-22-FB-00-11-22"
+	PhysicalMediaType string // "Native 802.11", "802.3", ...
+	Virtual           bool
+}
+
+// psStaticServers are the statically configured servers of an interface, as
+// stored in the registry; empty when the servers come from DHCP
+type psStaticServers struct {
+	IPv4 string // comma or space separated
+	IPv6 string
+}
+
+// ApplyProfile sets the IPv4 and IPv6 servers of the profile with one
+// Set-DnsClientServerAddress call; a family without servers is left as is
+func (PowerShellBackend) ApplyProfile(p config.Profile) (ApplyResult, error) {
+	iface := p.Interface
+	if iface == "" {
+		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
+	}
+
+	servers := p.AllServers()
+	if len(servers) == 0 {
+		return ApplyResult{Ok: false}, fmt.Errorf("no DNS servers provided")
+	}
+
+	script, err := psSetServers(iface, servers)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+	if out, err := PowerShellExec(script); err != nil {
+		return ApplyResult{Ok: false}, fmt.Errorf("powershell error: %v, output: %s", err, out)
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS applied to %s: %v", iface, servers)}, nil
+}
+
+// Rollback resets both address families of iface to the servers from DHCP
+func (PowerShellBackend) Rollback(iface string) (ApplyResult, error) {
+	if iface == "" {
+		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
+	}
+
+	script := fmt.Sprintf("Set-DnsClientServerAddress -InterfaceAlias %s -ResetServerAddresses", psQuote(iface))
+	if out, err := PowerShellExec(script); err != nil {
+		return ApplyResult{Ok: false}, fmt.Errorf("powershell error: %v, output: %s", err, out)
+	}
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("Rollback successful – DNS restored to automatic (DHCP) on interface %s", iface)}, nil
+}
+
+// GetCurrentDNS returns the IPv4 servers followed by the IPv6 servers iface uses
+func (PowerShellBackend) GetCurrentDNS(iface string) ([]string, error) {
+	out, err := PowerShellExec(fmt.Sprintf("ConvertTo-Json -InputObject @(Get-DnsClientServerAddress -InterfaceAlias %s -AddressFamily IPv4,IPv6 | "+
+		"Select-Object InterfaceAlias, InterfaceIndex, @{n='AddressFamily';e={[int]$_.AddressFamily}}, ServerAddresses)", psQuote(iface)))
+	if err != nil {
+		return nil, fmt.Errorf("powershell error: %v, output: %s", err, out)
+	}
+
+	var entries []psServerAddress
+	if err := psDecode(out, &entries); err != nil {
+		return nil, err
+	}
+
+	var v4, v6 []string
+	for _, e := range entries {
+		switch e.AddressFamily {
+		case psIPv4:
+			v4 = append(v4, e.ServerAddresses...)
+		case psIPv6:
+			v6 = append(v6, e.ServerAddresses...)
+		}
+	}
+	return append(v4, v6...), nil
+}
+
+// GetNetworkInterfaces lists the adapters Get-NetAdapter reports as up
+func (b PowerShellBackend) GetNetworkInterfaces() ([]string, error) {
+	adapters, err := psNetAdapters()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, a := range adapters {
+		if a.Status == "Up" {
+			names = append(names, a.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no active interfaces found")
+	}
+	return names, nil
+}
+
+// ReportInterfaces lists every adapter of Get-NetAdapter, including
+// disabled ones, with its index, state, MAC address and media type
+func (PowerShellBackend) ReportInterfaces() ([]InterfaceInfo, error) {
+	adapters, err := psNetAdapters()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]InterfaceInfo, 0, len(adapters))
+	for _, a := range adapters {
+		info := newInterfaceInfo(a.Name)
+		info.Index = a.InterfaceIndex
+		info.MAC = strings.ToLower(strings.ReplaceAll(a.MacAddress, "-", ":"))
+		if a.Status != "Disabled" {
+			info.AdminState = AdminEnabled
+		}
+		if a.Status == "Up" {
+			info.State = StateConnected
+		}
+		switch {
+		case a.Virtual:
+			info.Type = TypeVirtual
+		case strings.Contains(a.PhysicalMediaType, "802.11"):
+			info.Type = TypeWireless
+		case a.PhysicalMediaType == "802.3":
+			info.Type = TypeEthernet
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Snapshot reads the statically configured servers of iface from the
+// registry; a family without static servers gets its servers from DHCP
+func (PowerShellBackend) Snapshot(iface string) (DNSState, error) {
+	q := psQuote(iface)
+	out, err := PowerShellExec(fmt.Sprintf("$g = (Get-NetAdapter -Name %s).InterfaceGuid; "+
+		"$p = 'HKLM:\\SYSTEM\\CurrentControlSet\\Services\\{0}\\Parameters\\Interfaces\\' + $g; "+
+		"ConvertTo-Json -InputObject ([pscustomobject]@{ "+
+		"IPv4 = [string](Get-ItemProperty -Path ($p -f 'Tcpip') -ErrorAction SilentlyContinue).NameServer; "+
+		"IPv6 = [string](Get-ItemProperty -Path ($p -f 'Tcpip6') -ErrorAction SilentlyContinue).NameServer })", q))
+	if err != nil {
+		return DNSState{}, fmt.Errorf("powershell error: %v, output: %s", err, out)
+	}
+
+	var static psStaticServers
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &static); err != nil {
+		return DNSState{}, fmt.Errorf("cannot parse powershell output: %v", err)
+	}
+
+	return DNSState{
+		Interface: iface,
+		Backend:   "powershell",
+		IPv4:      psFamilyState(static.IPv4),
+		IPv6:      psFamilyState(static.IPv6),
+	}, nil
+}
+
+// Restore puts back a state captured by Snapshot: the servers are reset to
+// DHCP, then the static servers of both families are set again
+func (PowerShellBackend) Restore(state DNSState) (ApplyResult, error) {
+	iface := state.Interface
+	if iface == "" {
+		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
+	}
+
+	script := fmt.Sprintf("Set-DnsClientServerAddress -InterfaceAlias %s -ResetServerAddresses", psQuote(iface))
+	if static := append(append([]string{}, state.IPv4.Servers...), state.IPv6.Servers...); len(static) > 0 {
+		set, err := psSetServers(iface, static)
+		if err != nil {
+			return ApplyResult{Ok: false}, err
+		}
+		script += "; " + set
+	}
+
+	if out, err := PowerShellExec(script); err != nil {
+		return ApplyResult{Ok: false}, fmt.Errorf("powershell error: %v, output: %s", err, out)
+	}
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS of %s restored: %s", iface, state)}, nil
+}
+
+// psNetAdapters runs Get-NetAdapter for every adapter, including hidden ones
+func psNetAdapters() ([]psNetAdapter, error) {
+	out, err := PowerShellExec("ConvertTo-Json -InputObject @(Get-NetAdapter | " +
+		"Select-Object Name, InterfaceIndex, @{n='Status';e={[string]$_.Status}}, MacAddress, PhysicalMediaType, Virtual)")
+	if err != nil {
+		return nil, fmt.Errorf("powershell error: %v, output: %s", err, out)
+	}
+
+	var adapters []psNetAdapter
+	if err := psDecode(out, &adapters); err != nil {
+		return nil, err
+	}
+	return adapters, nil
+}
+
+// psSetServers builds the Set-DnsClientServerAddress call for servers,
+// which must all be IP addresses
+func psSetServers(iface string, servers []string) (string, error) {
+	quoted := make([]string, len(servers))
+	for i, s := range servers {
+		if net.ParseIP(s) == nil {
+			return "", fmt.Errorf("invalid DNS server address '%s'", s)
+		}
+		quoted[i] = psQuote(s)
+	}
+	return fmt.Sprintf("Set-DnsClientServerAddress -InterfaceAlias %s -ServerAddresses (%s)",
+		psQuote(iface), strings.Join(quoted, ",")), nil
+}
+
+// psFamilyState converts a registry NameServer value
+func psFamilyState(nameServer string) FamilyState {
+	servers := strings.FieldsFunc(nameServer, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(servers) == 0 {
+		return FamilyState{DHCP: true}
+	}
+	return FamilyState{Servers: servers}
+}
+
+// psDecode decodes a JSON array; a single object, which older PowerShell
+// versions emit for one-element arrays, and empty output are accepted too
+func psDecode(out string, v interface{}) error {
+	out = strings.TrimSpace(out)
+	switch {
+	case out == "":
+		out = "[]"
+	case strings.HasPrefix(out, "{"):
+		out = "[" + out + "]"
+	}
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		return fmt.Errorf("cannot parse powershell output: %v", err)
+	}
+	return nil
+}
+
+// psQuote quotes s as a PowerShell string literal
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,198 @@
+package platform_all
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// ResolvConfPath is the resolv.conf used by the default resolvconf backend
+var ResolvConfPath = "/etc/resolv.conf"
+
+// ResolvConfBackend manages DNS by rewriting the nameserver lines of a
+// resolv.conf file. Every other line (search, options, comments) is kept.
+// resolv.conf is host-wide, so the interface name is only used for messages
+// and snapshots, and DNS can only be changed for one interface at a time.
+type ResolvConfBackend struct {
+	Path string
+}
+
+// NewResolvConfBackend returns a backend operating on the resolv.conf at path
+func NewResolvConfBackend(path string) *ResolvConfBackend {
+	return &ResolvConfBackend{Path: path}
+}
+
+func (b *ResolvConfBackend) Name() string { return "resolvconf" }
+
+// HostWide reports true: every interface shares the resolv.conf
+func (b *ResolvConfBackend) HostWide() bool { return true }
+
+// ApplyProfile replaces all nameserver lines with the profile's servers
+func (b *ResolvConfBackend) ApplyProfile(p config.Profile) (ApplyResult, error) {
+	if len(p.AllServers()) == 0 {
+		return ApplyResult{Ok: false}, fmt.Errorf("no DNS servers provided")
+	}
+
+	data, err := os.ReadFile(b.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return ApplyResult{Ok: false}, fmt.Errorf("cannot read %s: %v", b.Path, err)
+	}
+
+	if err := b.write(rewriteNameservers(string(data), p.AllServers())); err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS applied to %s (%s): %v", b.Path, p.Interface, p.AllServers())}, nil
+}
+
+// Rollback restores the nameservers of the latest resolvconf snapshot of
+// iface and drops it. resolv.conf has no automatic configuration to fall
+// back to, so without a snapshot there is nothing to roll back.
+func (b *ResolvConfBackend) Rollback(iface string) (ApplyResult, error) {
+	snaps, err := ListSnapshots(iface)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+	for _, snap := range snaps {
+		if snap.State.Backend != b.Name() {
+			continue
+		}
+		if _, err := b.Restore(snap.State); err != nil {
+			return ApplyResult{Ok: false}, err
+		}
+		if err := DropSnapshotsFrom(snap); err != nil {
+			return ApplyResult{Ok: false}, err
+		}
+		return ApplyResult{Ok: true, Message: fmt.Sprintf("Rollback successful – %s restored from snapshot %d", b.Path, snap.ID)}, nil
+	}
+	return ApplyResult{Ok: false}, fmt.Errorf("no snapshot of %s found for %s, nothing to roll back", b.Path, iface)
+}
+
+// GetCurrentDNS returns the nameserver entries of the resolv.conf
+func (b *ResolvConfBackend) GetCurrentDNS(iface string) ([]string, error) {
+	data, err := os.ReadFile(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	return parseNameservers(string(data)), nil
+}
+
+// Snapshot captures the nameservers of the resolv.conf.
+// resolv.conf has no notion of DHCP, so both families are static lists.
+func (b *ResolvConfBackend) Snapshot(iface string) (DNSState, error) {
+	data, err := os.ReadFile(b.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return DNSState{}, fmt.Errorf("cannot read %s: %v", b.Path, err)
+	}
+
+	v4, v6, _, _ := config.SplitFamilies(parseNameservers(string(data)))
+	return DNSState{
+		Interface: iface,
+		Backend:   "resolvconf",
+		IPv4:      FamilyState{Servers: v4},
+		IPv6:      FamilyState{Servers: v6},
+	}, nil
+}
+
+// Restore rewrites the nameserver lines captured by Snapshot
+func (b *ResolvConfBackend) Restore(state DNSState) (ApplyResult, error) {
+	data, err := os.ReadFile(b.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return ApplyResult{Ok: false}, fmt.Errorf("cannot read %s: %v", b.Path, err)
+	}
+
+	servers := append(append([]string{}, state.IPv4.Servers...), state.IPv6.Servers...)
+	if err := b.write(rewriteNameservers(string(data), servers)); err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("%s restored: %v", b.Path, servers)}, nil
+}
+
+// GetNetworkInterfaces lists the interfaces that are up, excluding loopback
+func (b *ResolvConfBackend) GetNetworkInterfaces() ([]string, error) {
+	return upInterfaces()
+}
+
+// upInterfaces lists the names of the host's interfaces that are up, excluding loopback
+func upInterfaces() ([]string, error) {
+	ifaces, err := HostInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, i := range ifaces {
+		if i.AdminState != AdminEnabled || i.Type == TypeLoopback {
+			continue
+		}
+		names = append(names, i.Name)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no active interfaces found")
+	}
+	return names, nil
+}
+
+// write replaces the content of the resolv.conf, keeping its file mode
+func (b *ResolvConfBackend) write(content string) error {
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(b.Path); err == nil {
+		mode = st.Mode().Perm()
+	}
+	if err := os.WriteFile(b.Path, []byte(content), mode); err != nil {
+		return fmt.Errorf("cannot write %s: %v", b.Path, err)
+	}
+	return nil
+}
+
+// parseNameservers extracts the addresses of all nameserver lines
+func parseNameservers(content string) []string {
+	var servers []string
+	for _, l := range strings.Split(content, "\n") {
+		fields := strings.Fields(l)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// rewriteNameservers drops the existing nameserver lines and inserts the
+// given servers where the first one used to be (or at the end of the file).
+func rewriteNameservers(content string, servers []string) string {
+	var lines []string
+	var inserted bool
+
+	for _, l := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) > 0 && fields[0] == "nameserver" {
+			if !inserted {
+				lines = append(lines, nameserverLines(servers)...)
+				inserted = true
+			}
+			continue
+		}
+		lines = append(lines, l)
+	}
+
+	if !inserted {
+		if len(lines) == 1 && lines[0] == "" {
+			lines = nil
+		}
+		lines = append(lines, nameserverLines(servers)...)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func nameserverLines(servers []string) []string {
+	var lines []string
+	for _, s := range servers {
+		lines = append(lines, "nameserver "+s)
+	}
+	return lines
+}
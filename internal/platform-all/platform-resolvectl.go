@@ -0,0 +1,257 @@
+package platform_all
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+var ResolvectlExec = runResolvectl
+
+// runResolvectl executes `resolvectl` with given arguments
+// and returns combined stdout/stderr output as string.
+func runResolvectl(args ...string) (string, error) {
+	cmd := exec.Command("resolvectl", args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// ResolvectlBackend configures per-link DNS servers of systemd-resolved.
+// All commands go through ResolvectlExec so they can be mocked in tests.
+type ResolvectlBackend struct{}
+
+func (ResolvectlBackend) Name() string { return "resolvectl" }
+
+// ApplyProfile sets the profile's servers on the link with `resolvectl dns <iface> ...`
+func (ResolvectlBackend) ApplyProfile(p config.Profile) (ApplyResult, error) {
+	iface := p.Interface
+	if iface == "" {
+		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
+	}
+
+	if len(p.AllServers()) == 0 {
+		return ApplyResult{Ok: false}, fmt.Errorf("no DNS servers provided")
+	}
+
+	args := append([]string{"dns", iface}, p.AllServers()...)
+	out, err := ResolvectlExec(args...)
+	if err != nil {
+		return ApplyResult{Ok: false}, fmt.Errorf("resolvectl error: %v, output: %s", err, out)
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS applied to %s: %v", iface, p.AllServers())}, nil
+}
+
+// Rollback drops the per-link settings with `resolvectl revert <iface>`,
+// handing the link back to whatever configured it (networkd, DHCP, ...).
+func (ResolvectlBackend) Rollback(iface string) (ApplyResult, error) {
+	if iface == "" {
+		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
+	}
+
+	out, err := ResolvectlExec("revert", iface)
+	if err != nil {
+		return ApplyResult{Ok: false}, fmt.Errorf("resolvectl error: %v, output: %s", err, out)
+	}
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("Rollback successful – DNS of link %s reverted", iface)}, nil
+}
+
+// GetCurrentDNS returns the DNS servers of iface as reported by `resolvectl status`
+func (ResolvectlBackend) GetCurrentDNS(iface string) ([]string, error) {
+	out, err := ResolvectlExec("status", iface)
+	if err != nil {
+		return nil, fmt.Errorf("resolvectl error: %v, output: %s", err, out)
+	}
+
+	for _, l := range parseResolvectlStatus(out) {
+		if l.Name == iface {
+			return l.Servers, nil
+		}
+	}
+	return nil, fmt.Errorf("link '%s' not found in resolvectl status", iface)
+}
+
+// Snapshot captures the per-link servers of iface
+func (b ResolvectlBackend) Snapshot(iface string) (DNSState, error) {
+	servers, err := b.GetCurrentDNS(iface)
+	if err != nil {
+		return DNSState{}, err
+	}
+
+	v4, v6, _, _ := config.SplitFamilies(servers)
+	return DNSState{
+		Interface: iface,
+		Backend:   "resolvectl",
+		IPv4:      FamilyState{Servers: v4},
+		IPv6:      FamilyState{Servers: v6},
+	}, nil
+}
+
+// Restore reverts the link first; if the servers it then reports are not the
+// captured ones (they were set at runtime), they are set explicitly.
+func (b ResolvectlBackend) Restore(state DNSState) (ApplyResult, error) {
+	iface := state.Interface
+	if _, err := b.Rollback(iface); err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	want := append(append([]string{}, state.IPv4.Servers...), state.IPv6.Servers...)
+	current, err := b.GetCurrentDNS(iface)
+	if err != nil {
+		return ApplyResult{Ok: false}, err
+	}
+
+	v4, v6, _, _ := config.SplitFamilies(current)
+	if !sameServers(v4, state.IPv4.Servers) || !sameServers(v6, state.IPv6.Servers) {
+		args := append([]string{"dns", iface}, want...)
+		out, err := ResolvectlExec(args...)
+		if err != nil {
+			return ApplyResult{Ok: false}, fmt.Errorf("resolvectl error: %v, output: %s", err, out)
+		}
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS of link %s restored: %v", iface, want)}, nil
+}
+
+// sameServers reports whether a and b hold the same servers in the same order
+func sameServers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetNetworkInterfaces lists the links known to systemd-resolved that are up
+func (ResolvectlBackend) GetNetworkInterfaces() ([]string, error) {
+	out, err := ResolvectlExec("status")
+	if err != nil {
+		return nil, fmt.Errorf("resolvectl error: %v, output: %s", err, out)
+	}
+
+	up, err := upInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	isUp := make(map[string]bool)
+	for _, name := range up {
+		isUp[name] = true
+	}
+
+	var names []string
+	for _, l := range parseResolvectlStatus(out) {
+		if l.Name != "" && isUp[l.Name] {
+			names = append(names, l.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no active interfaces found")
+	}
+	return names, nil
+}
+
+// resolvectlLink is one "Link N (name)" section of `resolvectl status`.
+// The "Global" section is returned with an empty Name and Index 0.
+type resolvectlLink struct {
+	Index         int
+	Name          string
+	CurrentServer string
+	Servers       []string
+}
+
+// parseResolvectlStatus parses the human readable output of `resolvectl status`.
+// Both the single-line "DNS Servers: a b" layout of newer systemd versions and
+// the older layout with one server per continuation line are understood.
+func parseResolvectlStatus(out string) []resolvectlLink {
+	var links []resolvectlLink
+	var cur *resolvectlLink
+	var inServers bool
+
+	for _, raw := range strings.Split(out, "\n") {
+		l := strings.TrimSpace(raw)
+
+		if l == "Global" || strings.HasPrefix(l, "Link ") {
+			links = append(links, parseResolvectlHeader(l))
+			cur = &links[len(links)-1]
+			inServers = false
+			continue
+		}
+		if cur == nil || l == "" {
+			inServers = false
+			continue
+		}
+
+		key, value, found := strings.Cut(l, ": ")
+		if !found && strings.HasSuffix(l, ":") {
+			key, value, found = strings.TrimSuffix(l, ":"), "", true
+		}
+
+		if found && !isResolvectlServerList(l) {
+			inServers = false
+			switch strings.TrimSpace(key) {
+			case "DNS Servers":
+				cur.Servers = append(cur.Servers, resolvectlServers(value)...)
+				inServers = true
+			case "Current DNS Server":
+				if s := resolvectlServers(value); len(s) > 0 {
+					cur.CurrentServer = s[0]
+				}
+			}
+			continue
+		}
+
+		if inServers {
+			cur.Servers = append(cur.Servers, resolvectlServers(l)...)
+		}
+	}
+
+	return links
+}
+
+// parseResolvectlHeader parses "Global" or "Link 2 (enp0s3)"
+func parseResolvectlHeader(l string) resolvectlLink {
+	if l == "Global" {
+		return resolvectlLink{}
+	}
+
+	var link resolvectlLink
+	rest := strings.TrimPrefix(l, "Link ")
+	idx, name, _ := strings.Cut(rest, " ")
+	link.Index, _ = strconv.Atoi(idx)
+	link.Name = strings.TrimSuffix(strings.TrimPrefix(name, "("), ")")
+	return link
+}
+
+// isResolvectlServerList reports whether l consists only of server addresses,
+// which matters because IPv6 continuation lines contain colons too.
+func isResolvectlServerList(l string) bool {
+	fields := strings.Fields(l)
+	return len(fields) > 0 && len(resolvectlServers(l)) == len(fields)
+}
+
+// resolvectlServers extracts the addresses of a server list, dropping the
+// "#server-name" and "%iface" decorations resolvectl may add.
+func resolvectlServers(value string) []string {
+	var servers []string
+	for _, f := range strings.Fields(value) {
+		f, _, _ = strings.Cut(f, "#")
+		f, _, _ = strings.Cut(f, "%")
+		if net.ParseIP(f) != nil {
+			servers = append(servers, f)
+		}
+	}
+	return servers
+}
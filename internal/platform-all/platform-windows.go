@@ -3,8 +3,8 @@ package platform_all
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"os/exec"
-	"runtime"
 	"strings"
 
 	"github.com/Mreza2020/DNS-Switcher/internal/config"
@@ -13,10 +13,21 @@ import (
 type ApplyResult struct {
 	Ok      bool
 	Message string
+
+	// Steps, Snapshot and RolledBack are set by the package-level ApplyProfile
+	Steps      []ApplyStep
+	Snapshot   int  // id of the snapshot taken before applying
+	RolledBack bool // a step failed and the previous settings were restored
 }
 
 var NetshExec = runNetsh
 
+// NetshBackend drives the Windows DNS client through `netsh`.
+// All commands go through NetshExec so they can be mocked in tests.
+type NetshBackend struct{}
+
+func (NetshBackend) Name() string { return "netsh" }
+
 // runNetsh executes a Windows `netsh` command with given arguments
 // and returns combined stdout/stderr output as string.
 // It is a helper used by other networking functions.
@@ -29,75 +40,85 @@ func runNetsh(args ...string) (string, error) {
 	return out.String(), err
 }
 
-// getActiveInterface detects and returns the name of the currently
-// active (Connected) network interface using `netsh interface show interface`.
+// getActiveInterface returns the first interface that is up.
 // Returns error if no connected interface is found.
 func getActiveInterface() (string, error) {
-	out, err := runNetsh("interface", "show", "interface")
+	names, err := upInterfaces()
 	if err != nil {
-		return "", fmt.Errorf("failed to run netsh: %v, output: %s", err, out)
+		return "", fmt.Errorf("no active network interface found")
 	}
+	return names[0], nil
+}
 
-	lines := strings.Split(out, "\n")
-	for _, l := range lines {
-		if strings.Contains(l, "Connected") {
-			fields := strings.Fields(l)
-			if len(fields) >= 4 {
-				iface := strings.Join(fields[3:], " ")
-				return iface, nil
-			}
+// parseNetshInterfaceNames returns the names in the table of
+// `netsh interface show interface`. The header and the state and type
+// columns are localized, so the rows are taken positionally: every line
+// after the dashed separator has three one-word columns before the name.
+func parseNetshInterfaceNames(out string) []string {
+	var names []string
+	rows := false
+	for _, l := range strings.Split(out, "\n") {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "---") {
+			rows = true
+			continue
+		}
+		fields := strings.Fields(l)
+		if rows && len(fields) >= 4 {
+			names = append(names, strings.Join(fields[3:], " "))
 		}
 	}
-	return "", fmt.Errorf("no active network interface found")
+	return names
 }
 
 // ApplyProfile applies static DNS settings (primary + optional secondary servers)
 // to the currently active network interface using netsh.
 // Returns ApplyResult with success status and descriptive message.
-func ApplyProfile(p config.Profile) (ApplyResult, error) {
-	if runtime.GOOS != "windows" {
-		return ApplyResult{Ok: false}, fmt.Errorf("only supported on Windows")
-	}
-
+func (NetshBackend) ApplyProfile(p config.Profile) (ApplyResult, error) {
 	iface := p.Interface
 	if iface == "" {
 		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
 	}
 
-	if len(p.Servers) == 0 {
+	if len(p.AllServers()) == 0 {
 		return ApplyResult{Ok: false}, fmt.Errorf("no DNS servers provided")
 	}
 
-	// apply primary DNS server
-	out, err := NetshExec("interface", "ip", "set", "dns",
-		fmt.Sprintf("name=%s", iface),
-		"source=static",
-		fmt.Sprintf("address=%s", p.Servers[0]))
-	if err != nil {
-		return ApplyResult{Ok: false}, fmt.Errorf("netsh error: %v, output: %s", err, out)
+	if len(p.Servers) > 0 {
+		// apply primary DNS server
+		out, err := NetshExec("interface", "ip", "set", "dns",
+			fmt.Sprintf("name=%s", iface),
+			"source=static",
+			fmt.Sprintf("address=%s", p.Servers[0]))
+		if err != nil {
+			return ApplyResult{Ok: false}, fmt.Errorf("netsh error: %v, output: %s", err, out)
+		}
+
+		// apply secondary DNS servers
+		for i := 1; i < len(p.Servers); i++ {
+			out1, err1 := NetshExec("interface", "ip", "add", "dns",
+				fmt.Sprintf("name=%s", iface),
+				fmt.Sprintf("addr=%s", p.Servers[i]),
+				fmt.Sprintf("index=%d", i+1))
+			if err1 != nil {
+				return ApplyResult{Ok: false}, fmt.Errorf("netsh error: %v, output: %s", err1, out1)
+			}
+		}
 	}
 
-	// apply secondary DNS servers
-	for i := 1; i < len(p.Servers); i++ {
-		out1, err1 := NetshExec("interface", "ip", "add", "dns",
-			fmt.Sprintf("name=%s", iface),
-			fmt.Sprintf("addr=%s", p.Servers[i]),
-			fmt.Sprintf("index=%d", i+1))
-		if err1 != nil {
-			return ApplyResult{Ok: false}, fmt.Errorf("netsh error: %v, output: %s", err1, out1)
+	// apply IPv6 DNS servers, leaving IPv6 untouched when the profile has none
+	if len(p.IPv6) > 0 {
+		if err := netshSetDNSServers("ipv6", iface, FamilyState{Servers: p.IPv6}); err != nil {
+			return ApplyResult{Ok: false}, err
 		}
 	}
 
-	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS applied to %s: %v", iface, p.Servers)}, nil
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS applied to %s: %v", iface, p.AllServers())}, nil
 }
 
 // Rollback restores DNS mode back to automatic DHCP on the active interface.
 // Used when reverting applied DNS profiles.
-func Rollback(iface string) (ApplyResult, error) {
-	if runtime.GOOS != "windows" {
-		return ApplyResult{Ok: false}, fmt.Errorf("only supported on Windows")
-	}
-
+func (NetshBackend) Rollback(iface string) (ApplyResult, error) {
 	if iface == "" {
 		var err error
 		iface, err = getActiveInterface()
@@ -106,68 +127,180 @@ func Rollback(iface string) (ApplyResult, error) {
 		}
 	}
 
-	out, err := runNetsh("interface", "ip", "set", "dns", fmt.Sprintf("name=%s", iface), "source=dhcp")
+	out, err := NetshExec("interface", "ip", "set", "dns", fmt.Sprintf("name=%s", iface), "source=dhcp")
 	if err != nil {
 		return ApplyResult{Ok: false}, fmt.Errorf("netsh error: %v, output: %s", err, out)
 	}
 	return ApplyResult{Ok: true, Message: fmt.Sprintf("Rollback successful – DNS restored to automatic (DHCP) on interface %s", iface)}, nil
 }
 
-// GetCurrentDNS reads and returns the currently configured DNS servers
-// (static or enumerated) for the active network interface.
-// Output is parsed from `netsh interface ip show dns name=<iface>`.
-func GetCurrentDNS(iface string) ([]string, error) {
-	out, err := NetshExec("interface", "ip", "show", "dns",
-		fmt.Sprintf("name=%s", iface))
-	if err != nil {
-		return nil, err
-	}
-
+// GetCurrentDNS returns the DNS servers iface uses, static or learned from
+// DHCP: the IPv4 servers followed by the IPv6 servers of
+// `netsh interface ipv4|ipv6 show dnsservers`.
+func (NetshBackend) GetCurrentDNS(iface string) ([]string, error) {
 	var dnsList []string
-	var capture bool
-	lines := strings.Split(out, "\n")
-	for _, l := range lines {
-		l = strings.TrimSpace(l)
-		if strings.HasPrefix(l, "Statically Configured DNS Servers") ||
-			strings.HasPrefix(l, "DNS Servers") {
-			parts := strings.Split(l, ":")
-			if len(parts) == 2 {
-				dnsList = append(dnsList, strings.TrimSpace(parts[1]))
-			}
-			capture = true
-			continue
-		}
-		if capture && l != "" {
-			dnsList = append(dnsList, l)
+	for _, family := range []string{"ipv4", "ipv6"} {
+		out, err := NetshExec("interface", family, "show", "dnsservers",
+			fmt.Sprintf("name=%s", iface))
+		if err != nil {
+			return nil, fmt.Errorf("netsh error: %v, output: %s", err, out)
 		}
+		dnsList = append(dnsList, netshAddresses(netshBody(out))...)
 	}
 	return dnsList, nil
 }
 
-func GetNetworkInterfaces() ([]string, error) {
+// GetNetworkInterfaces lists the interfaces that are up, excluding
+// loopback. Windows reports their names in UTF-16 whatever the console
+// language and code page, unlike the text of netsh.
+func (NetshBackend) GetNetworkInterfaces() ([]string, error) {
+	return upInterfaces()
+}
+
+// ReportInterfaces lists the interfaces of the host together with the
+// disabled adapters, which only `netsh interface show interface` knows
+func (NetshBackend) ReportInterfaces() ([]InterfaceInfo, error) {
+	host, err := HostInterfaces()
+	if err != nil {
+		return nil, err
+	}
 	out, err := NetshExec("interface", "show", "interface")
 	if err != nil {
 		return nil, fmt.Errorf("failed to run netsh: %v, output: %s", err, out)
 	}
 
-	var names []string
-	lines := strings.Split(out, "\n")
+	known := make(map[string]bool, len(host))
+	infos := make([]InterfaceInfo, 0, len(host))
+	for _, h := range host {
+		// adapters that are disabled are missing from the host's list
+		h.AdminState = AdminEnabled
+		known[h.Name] = true
+		infos = append(infos, h)
+	}
+	for _, name := range parseNetshInterfaceNames(out) {
+		if !known[name] {
+			infos = append(infos, newInterfaceInfo(name))
+		}
+	}
+	return infos, nil
+}
 
-	for _, l := range lines {
-		l = strings.TrimSpace(l)
+// Snapshot reads the IPv4 and IPv6 DNS configuration of iface with
+// `netsh interface ipv4|ipv6 show dnsservers`.
+func (NetshBackend) Snapshot(iface string) (DNSState, error) {
+	state := DNSState{Interface: iface, Backend: "netsh"}
 
-		if strings.Contains(l, "Connected") {
-			fields := strings.Fields(l)
-			if len(fields) >= 4 {
-				iface := strings.Join(fields[3:], " ")
-				names = append(names, iface)
-			}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		out, err := NetshExec("interface", family, "show", "dnsservers",
+			fmt.Sprintf("name=%s", iface))
+		if err != nil {
+			return DNSState{}, fmt.Errorf("netsh error: %v, output: %s", err, out)
+		}
+
+		fs := parseNetshDNSServers(out)
+		if family == "ipv4" {
+			state.IPv4 = fs
+		} else {
+			state.IPv6 = fs
+		}
+	}
+	return state, nil
+}
+
+// Restore puts back a state captured by Snapshot: DHCP families are reset to
+// source=dhcp, static families get their servers re-added in order.
+func (NetshBackend) Restore(state DNSState) (ApplyResult, error) {
+	iface := state.Interface
+	if iface == "" {
+		return ApplyResult{Ok: false}, fmt.Errorf("no interface specified")
+	}
+
+	families := []struct {
+		name  string
+		state FamilyState
+	}{{"ipv4", state.IPv4}, {"ipv6", state.IPv6}}
+
+	for _, f := range families {
+		if err := netshSetDNSServers(f.name, iface, f.state); err != nil {
+			return ApplyResult{Ok: false}, err
+		}
+	}
+
+	return ApplyResult{Ok: true, Message: fmt.Sprintf("DNS of %s restored: %s", iface, state)}, nil
+}
+
+// netshSetDNSServers configures one address family of iface
+func netshSetDNSServers(family, iface string, fs FamilyState) error {
+	name := fmt.Sprintf("name=%s", iface)
+
+	if fs.DHCP {
+		out, err := NetshExec("interface", family, "set", "dnsservers", name, "source=dhcp")
+		if err != nil {
+			return fmt.Errorf("netsh error: %v, output: %s", err, out)
+		}
+		return nil
+	}
+
+	first := "none"
+	if len(fs.Servers) > 0 {
+		first = fs.Servers[0]
+	}
+	out, err := NetshExec("interface", family, "set", "dnsservers", name,
+		"source=static", fmt.Sprintf("address=%s", first), "validate=no")
+	if err != nil {
+		return fmt.Errorf("netsh error: %v, output: %s", err, out)
+	}
+
+	for i := 1; i < len(fs.Servers); i++ {
+		out, err := NetshExec("interface", family, "add", "dnsservers", name,
+			fmt.Sprintf("address=%s", fs.Servers[i]),
+			fmt.Sprintf("index=%d", i+1), "validate=no")
+		if err != nil {
+			return fmt.Errorf("netsh error: %v, output: %s", err, out)
 		}
 	}
+	return nil
+}
+
+// parseNetshDNSServers parses `netsh interface ipv4|ipv6 show dnsservers`.
+// Every token that is an IP address is taken as a server, in order. The
+// labels are localized but the one of servers configured through DHCP
+// names the protocol in every language, e.g. "Über DHCP konfigurierte
+// DNS-Server" or "DHCP 経由で構成された DNS サーバー".
+func parseNetshDNSServers(out string) FamilyState {
+	var fs FamilyState
+	body := netshBody(out)
+	if strings.Contains(body, "DHCP") {
+		fs.DHCP = true
+	}
+
+	// servers learned from DHCP are not part of the configuration to restore
+	if !fs.DHCP {
+		fs.Servers = netshAddresses(body)
+	}
+	return fs
+}
 
-	if len(names) == 0 {
-		return nil, fmt.Errorf("no active interfaces found")
+// netshBody drops the first line of netsh output, the localized
+// "Configuration for interface" title quoting the interface name, which
+// could look like an address or contain "DHCP"
+func netshBody(out string) string {
+	out = strings.TrimLeft(out, "\r\n\t ")
+	if _, body, ok := strings.Cut(out, "\n"); ok {
+		return body
 	}
+	return ""
+}
 
-	return names, nil
+// netshAddresses returns every token of netsh output that is an IP address,
+// in order, with IPv6 zone suffixes ("%12") removed.
+func netshAddresses(out string) []string {
+	var addrs []string
+	for _, f := range strings.Fields(out) {
+		f, _, _ = strings.Cut(f, "%")
+		if net.ParseIP(f) != nil {
+			addrs = append(addrs, f)
+		}
+	}
+	return addrs
 }
@@ -1,11 +1,97 @@
 package platform_all
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
 
 // init: sets up a mock implementation of NetshExec
-// Intercepts network commands and returns predictable mock output for testing
+// Intercepts network commands and answers them from an in-memory DNS configuration
 func init() {
-	NetshExec = func(args ...string) (string, error) {
+	SetBackend(NetshBackend{})
+	NetshExec = newFakeNetsh().exec
+
+	// keep snapshots taken by tests out of the user's config directory
+	dir, err := os.MkdirTemp("", "dns-switcher-test")
+	if err != nil {
+		panic(err)
+	}
+	SnapshotPath = filepath.Join(dir, "snapshots.json")
+}
+
+// fakeNetsh keeps the DNS configuration netsh would change, by family and
+// interface ("ipv4 Ethernet"); unknown interfaces use DHCP. `set` and `add`
+// calls are recorded, calls starting with fail return an error and calls
+// starting with ignore succeed without changing anything.
+type fakeNetsh struct {
+	state  map[string]FamilyState
+	calls  []string
+	fail   string
+	ignore string
+}
+
+// fakeDHCPServers are what show dnsservers reports for a DHCP family
+var fakeDHCPServers = map[string]string{"ipv4": "192.168.1.1", "ipv6": "fec0:0:0:ffff::1%1"}
+
+func newFakeNetsh() *fakeNetsh {
+	return &fakeNetsh{state: map[string]FamilyState{}}
+}
+
+func (f *fakeNetsh) exec(args ...string) (string, error) {
+	call := strings.Join(args, " ")
+	if len(args) < 4 || args[0] != "interface" {
 		return fmt.Sprintf("MOCK: %v", args), nil
 	}
+
+	family := args[1]
+	if family == "ip" {
+		family = "ipv4"
+	}
+	params := map[string]string{}
+	for _, a := range args[4:] {
+		if k, v, ok := strings.Cut(a, "="); ok {
+			params[k] = v
+		}
+	}
+	key := family + " " + params["name"]
+	fs, ok := f.state[key]
+	if !ok {
+		fs = FamilyState{DHCP: true}
+	}
+
+	if args[2] == "show" {
+		if fs.DHCP {
+			return fmt.Sprintf("\r\nConfiguration for interface %q\r\n    DNS servers configured through DHCP:  %s\r\n",
+				params["name"], fakeDHCPServers[family]), nil
+		}
+		return fmt.Sprintf("\r\nConfiguration for interface %q\r\n    Statically Configured DNS Servers:    %s\r\n",
+			params["name"], strings.Join(fs.Servers, "\r\n                                          ")), nil
+	}
+
+	f.calls = append(f.calls, call)
+	if f.fail != "" && strings.HasPrefix(call, f.fail) {
+		return "The requested operation requires elevation.", fmt.Errorf("exit status 1")
+	}
+	if f.ignore != "" && strings.HasPrefix(call, f.ignore) {
+		return "Ok.", nil
+	}
+
+	addr := params["address"]
+	if addr == "" {
+		addr = params["addr"]
+	}
+	switch {
+	case args[2] == "set" && params["source"] == "dhcp":
+		fs = FamilyState{DHCP: true}
+	case args[2] == "set" && addr == "none":
+		fs = FamilyState{}
+	case args[2] == "set":
+		fs = FamilyState{Servers: []string{addr}}
+	case args[2] == "add":
+		fs.Servers = append(fs.Servers, addr)
+	}
+	f.state[key] = fs
+	return "Ok.", nil
 }
@@ -1,6 +1,7 @@
 package platform_all
 
 import (
+	"strings"
 	"testing"
 
 	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
@@ -44,3 +45,34 @@ func TestRollback_Mock(t *testing.T) {
 
 	t.Logf("Rollback OK: %s", result.Message)
 }
+
+// TestApplyProfileIPv6_Mock: verifies IPv6 servers are applied with `netsh interface ipv6`
+func TestApplyProfileIPv6_Mock(t *testing.T) {
+	var calls []string
+	orig := NetshExec
+	t.Cleanup(func() { NetshExec = orig })
+	NetshExec = func(args ...string) (string, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return "", nil
+	}
+
+	p := config2.Profile{
+		Name:      "cf",
+		Servers:   []string{"1.1.1.1"},
+		IPv6:      []string{"2606:4700:4700::1111", "2606:4700:4700::1001"},
+		Interface: "Wi-Fi",
+	}
+
+	if _, err := (NetshBackend{}).ApplyProfile(p); err != nil {
+		t.Fatalf("ApplyProfile returned error: %v", err)
+	}
+
+	want := []string{
+		"interface ip set dns name=Wi-Fi source=static address=1.1.1.1",
+		"interface ipv6 set dnsservers name=Wi-Fi source=static address=2606:4700:4700::1111 validate=no",
+		"interface ipv6 add dnsservers name=Wi-Fi address=2606:4700:4700::1001 index=2 validate=no",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected netsh calls:\n%s", strings.Join(calls, "\n"))
+	}
+}
@@ -0,0 +1,127 @@
+package platform_all
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mockHostInterfaces replaces HostInterfaces with a loopback, two Wi-Fi
+// adapters, an Ethernet adapter and a disconnected adapter
+func mockHostInterfaces(t *testing.T) {
+	orig := HostInterfaces
+	t.Cleanup(func() { HostInterfaces = orig })
+
+	HostInterfaces = func() ([]InterfaceInfo, error) {
+		lo := newInterfaceInfo("Loopback Pseudo-Interface 1")
+		lo.Index, lo.AdminState, lo.State, lo.Type = 1, AdminEnabled, StateConnected, TypeLoopback
+
+		wifi := newInterfaceInfo("Wi-Fi")
+		wifi.Index, wifi.AdminState, wifi.State, wifi.Type = 12, AdminEnabled, StateConnected, TypeWireless
+		wifi.MAC, wifi.IPv4 = "3c:22:fb:00:11:22", []string{"192.168.1.20/24"}
+
+		wifi2 := newInterfaceInfo("Wi-Fi 2")
+		wifi2.Index, wifi2.AdminState, wifi2.State, wifi2.Type = 14, AdminEnabled, StateConnected, TypeWireless
+
+		eth := newInterfaceInfo("Ethernet")
+		eth.Index, eth.AdminState, eth.State, eth.Type = 7, AdminEnabled, StateConnected, TypeEthernet
+
+		unplugged := newInterfaceInfo("Ethernet 2")
+		unplugged.Index = 9
+		return []InterfaceInfo{lo, wifi, wifi2, eth, unplugged}, nil
+	}
+}
+
+// mockInterfaceSelection uses the host interfaces of mockHostInterfaces and
+// stubs the default route and the prompt
+func mockInterfaceSelection(t *testing.T) {
+	origRoute, origPrompt := DefaultRouteInterface, PromptInterface
+	t.Cleanup(func() { DefaultRouteInterface, PromptInterface = origRoute, origPrompt })
+	SetBackend(NetshBackend{})
+	mockHostInterfaces(t)
+
+	DefaultRouteInterface = func() (string, error) { return "Ethernet", nil }
+	PromptInterface = func(interfaces []string) (string, error) { return interfaces[len(interfaces)-1], nil }
+}
+
+// TestResolveInterfaces: verifies names, auto, patterns, all interfaces and the prompt
+func TestResolveInterfaces(t *testing.T) {
+	mockInterfaceSelection(t)
+
+	cases := []struct {
+		sel  InterfaceSelection
+		want []string
+	}{
+		{InterfaceSelection{Spec: "vEthernet (WSL)"}, []string{"vEthernet (WSL)"}},
+		{InterfaceSelection{Spec: AutoInterface}, []string{"Ethernet"}},
+		{InterfaceSelection{Spec: "Wi-Fi*"}, []string{"Wi-Fi", "Wi-Fi 2"}},
+		{InterfaceSelection{Spec: "Wi-Fi", All: true}, []string{"Wi-Fi", "Wi-Fi 2", "Ethernet"}},
+		{InterfaceSelection{}, []string{"Ethernet"}},
+	}
+	for _, c := range cases {
+		got, err := ResolveInterfaces(c.sel)
+		if err != nil {
+			t.Fatalf("%+v: %v", c.sel, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("%+v: expected %q, got %q", c.sel, c.want, got)
+		}
+	}
+}
+
+// TestResolveInterfacesErrors: verifies non-interactive mode never prompts and unmatched patterns fail
+func TestResolveInterfacesErrors(t *testing.T) {
+	mockInterfaceSelection(t)
+	PromptInterface = func([]string) (string, error) {
+		t.Fatal("prompted in non-interactive mode")
+		return "", nil
+	}
+
+	if _, err := ResolveInterfaces(InterfaceSelection{NonInteractive: true}); err == nil || !strings.Contains(err.Error(), "--iface") {
+		t.Fatalf("expected error naming --iface, got %v", err)
+	}
+	if _, err := ResolveInterfaces(InterfaceSelection{Spec: "eth*", NonInteractive: true}); err == nil || !strings.Contains(err.Error(), "Wi-Fi 2") {
+		t.Fatalf("expected error listing the interfaces, got %v", err)
+	}
+}
+
+// TestResolveInterfacesHostWide: verifies a host-wide backend refuses to
+// change several interfaces at once but still reads them
+func TestResolveInterfacesHostWide(t *testing.T) {
+	mockInterfaceSelection(t)
+	SetBackend(NewResolvConfBackend(t.TempDir() + "/resolv.conf"))
+
+	if _, err := ResolveInterfaces(InterfaceSelection{All: true, Change: true}); err == nil || !strings.Contains(err.Error(), "single interface") {
+		t.Fatalf("expected error asking for a single interface, got %v", err)
+	}
+	if got, err := ResolveInterfaces(InterfaceSelection{Spec: "Wi-Fi*", Change: true}); err == nil {
+		t.Fatalf("expected error for a pattern matching two interfaces, got %q", got)
+	}
+	if got, err := ResolveInterfaces(InterfaceSelection{Spec: "Wi-Fi 2", Change: true}); err != nil || len(got) != 1 {
+		t.Fatalf("expected a single interface, got %q, %v", got, err)
+	}
+	if got, err := ResolveInterfaces(InterfaceSelection{All: true}); err != nil || len(got) != 3 {
+		t.Fatalf("expected every interface for reading, got %q, %v", got, err)
+	}
+}
+
+// TestPromptInterface: verifies the menu goes to PromptOut and the choice is read from PromptIn
+func TestPromptInterface(t *testing.T) {
+	origIn, origOut := PromptIn, PromptOut
+	t.Cleanup(func() { PromptIn, PromptOut = origIn, origOut })
+
+	var out strings.Builder
+	PromptIn, PromptOut = strings.NewReader("2\n"), &out
+	got, err := promptInterface([]string{"eth0", "wlan0"})
+	if err != nil || got != "wlan0" {
+		t.Fatalf("expected wlan0, got %q, %v", got, err)
+	}
+	if !strings.Contains(out.String(), " [2] wlan0") {
+		t.Fatalf("menu not written: %q", out.String())
+	}
+
+	PromptIn = strings.NewReader("7\n")
+	if _, err := promptInterface([]string{"eth0"}); err == nil {
+		t.Fatal("expected error for invalid choice")
+	}
+}
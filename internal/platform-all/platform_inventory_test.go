@@ -0,0 +1,72 @@
+package platform_all
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestListInterfaces: verifies disabled adapters known only to netsh are
+// added to the host interfaces and connected ones get their DNS configuration
+func TestListInterfaces(t *testing.T) {
+	f := mockFakeNetsh(t)
+	f.state["ipv4 Wi-Fi"] = FamilyState{Servers: []string{"10.0.0.53", "10.0.0.54"}}
+	exec := NetshExec
+	NetshExec = func(args ...string) (string, error) {
+		if strings.Join(args, " ") == "interface show interface" {
+			data, err := os.ReadFile("testdata/netsh-show-interface.txt")
+			return string(data), err
+		}
+		return exec(args...)
+	}
+	SetBackend(NetshBackend{})
+	mockHostInterfaces(t)
+
+	infos, err := ListInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, i := range infos {
+		names = append(names, i.Name)
+	}
+	want := []string{"Loopback Pseudo-Interface 1", "Wi-Fi", "Wi-Fi 2", "Ethernet", "Ethernet 2", "Bluetooth Network Connection"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected %q, got %q", want, names)
+	}
+
+	wifi := infos[1]
+	if wifi.Index != 12 || wifi.Type != TypeWireless || wifi.AdminState != AdminEnabled || len(wifi.IPv4) != 1 {
+		t.Fatalf("unexpected host data: %+v", wifi)
+	}
+	if !reflect.DeepEqual(wifi.DNS, []string{"10.0.0.53", "10.0.0.54", "fec0:0:0:ffff::1"}) {
+		t.Fatalf("unexpected DNS %v", wifi.DNS)
+	}
+	if wifi.DNSConfig == nil || wifi.DNSConfig.IPv4.DHCP || !wifi.DNSConfig.IPv6.DHCP {
+		t.Fatalf("unexpected DNS config %+v", wifi.DNSConfig)
+	}
+	if infos[0].DNSConfig != nil || infos[4].DNSConfig != nil {
+		t.Fatal("DNS read for a loopback or disconnected interface")
+	}
+	if bt := infos[5]; bt.AdminState != AdminDisabled || bt.State != StateDisconnected {
+		t.Fatalf("adapter missing from the host not disabled: %+v", bt)
+	}
+}
+
+// TestHostInterfaces: verifies the loopback interface of the host is reported
+func TestHostInterfaces(t *testing.T) {
+	infos, err := hostInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range infos {
+		if i.Type == TypeLoopback {
+			if i.AdminState != AdminEnabled || len(i.IPv4)+len(i.IPv6) == 0 {
+				t.Fatalf("unexpected loopback %+v", i)
+			}
+			return
+		}
+	}
+	t.Skip("no loopback interface")
+}
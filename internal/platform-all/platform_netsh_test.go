@@ -0,0 +1,105 @@
+package platform_all
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseNetshInterfaceNames: verifies names are taken positionally from
+// the English, German, Japanese and Persian tables
+func TestParseNetshInterfaceNames(t *testing.T) {
+	cases := map[string][]string{
+		"netsh-show-interface.txt":    {"Wi-Fi", "Ethernet 2", "Bluetooth Network Connection", "Loopback Pseudo-Interface 1"},
+		"netsh-show-interface-de.txt": {"Ethernet", "WLAN 2", "Bluetooth-Netzwerkverbindung"},
+		"netsh-show-interface-ja.txt": {"イーサネット", "Wi-Fi", "Bluetooth ネットワーク接続"},
+		"netsh-show-interface-fa.txt": {"Wi-Fi", "Ethernet 2", "اتصال شبکه بلوتوث"},
+	}
+	for fixture, want := range cases {
+		data, err := os.ReadFile("testdata/" + fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parseNetshInterfaceNames(string(data)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %q, got %q", fixture, want, got)
+		}
+	}
+}
+
+// TestParseNetshDNSServersLocalized: verifies DHCP and static configurations
+// are told apart in every language, ignoring the interface name in the title
+func TestParseNetshDNSServersLocalized(t *testing.T) {
+	for _, lang := range []string{"de", "ja", "fa"} {
+		data, _ := os.ReadFile("testdata/netsh-dnsservers-static-" + lang + ".txt")
+		fs := parseNetshDNSServers(string(data))
+		if fs.DHCP || !reflect.DeepEqual(fs.Servers, []string{"10.0.0.53", "10.0.0.54"}) {
+			t.Fatalf("%s: unexpected static state: %+v", lang, fs)
+		}
+
+		data, _ = os.ReadFile("testdata/netsh-dnsservers-dhcp-" + lang + ".txt")
+		fs = parseNetshDNSServers(string(data))
+		if !fs.DHCP || len(fs.Servers) != 0 {
+			t.Fatalf("%s: unexpected DHCP state: %+v", lang, fs)
+		}
+	}
+}
+
+// TestNetshGetCurrentDNSLocalized: verifies static and DHCP servers of both
+// families are read through NetshExec from localized output
+func TestNetshGetCurrentDNSLocalized(t *testing.T) {
+	orig := NetshExec
+	t.Cleanup(func() { NetshExec = orig })
+
+	cases := map[string][]string{
+		"de": {"10.0.0.53", "10.0.0.54", "192.168.178.1"},
+		"ja": {"10.0.0.53", "10.0.0.54", "fd00::1"},
+		"fa": {"10.0.0.53", "10.0.0.54", "192.168.1.1"},
+	}
+	for lang, want := range cases {
+		var calls []string
+		NetshExec = func(args ...string) (string, error) {
+			call := strings.Join(args, " ")
+			calls = append(calls, call)
+			// static IPv4, DHCP for the other family
+			fixture := "testdata/netsh-dnsservers-dhcp-" + lang + ".txt"
+			if strings.HasPrefix(call, "interface ipv4 ") {
+				fixture = "testdata/netsh-dnsservers-static-" + lang + ".txt"
+			}
+			data, err := os.ReadFile(fixture)
+			return string(data), err
+		}
+
+		got, err := (NetshBackend{}).GetCurrentDNS("Ethernet")
+		if err != nil {
+			t.Fatalf("%s: %v", lang, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %q, got %q", lang, want, got)
+		}
+		wantCalls := []string{"interface ipv4 show dnsservers name=Ethernet", "interface ipv6 show dnsservers name=Ethernet"}
+		if !reflect.DeepEqual(calls, wantCalls) {
+			t.Fatalf("%s: unexpected netsh calls %q", lang, calls)
+		}
+	}
+}
+
+// TestNetshGetNetworkInterfaces: verifies interfaces come from the host,
+// not from localized netsh output
+func TestNetshGetNetworkInterfaces(t *testing.T) {
+	mockHostInterfaces(t)
+	orig := NetshExec
+	t.Cleanup(func() { NetshExec = orig })
+	NetshExec = func(args ...string) (string, error) {
+		t.Fatalf("unexpected netsh call %q", args)
+		return "", nil
+	}
+
+	got, err := (NetshBackend{}).GetNetworkInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Wi-Fi", "Wi-Fi 2", "Ethernet"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
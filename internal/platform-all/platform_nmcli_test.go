@@ -0,0 +1,133 @@
+package platform_all
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// mockNmcli replaces NmcliExec for the duration of a test.
+// Read-only commands are answered from fixtures, every call is recorded.
+func mockNmcli(t *testing.T) *[]string {
+	var calls []string
+	orig := NmcliExec
+	t.Cleanup(func() { NmcliExec = orig })
+
+	fixtures := map[string]string{
+		"-t -f UUID,DEVICE connection show --active": "nmcli-active.txt",
+		"-t -f ipv4.dns,ipv4.ignore-auto-dns,ipv6.dns,ipv6.ignore-auto-dns connection show 0b6f4a5e-2c1d-4f7b-9a31-2f6d1c0e8a11": "nmcli-connection.txt",
+		"-t -f IP4.DNS,IP6.DNS device show wlp2s0": "nmcli-device-dns.txt",
+		"-t -f DEVICE,TYPE,STATE device status":    "nmcli-device-status.txt",
+	}
+
+	NmcliExec = func(args ...string) (string, error) {
+		call := strings.Join(args, " ")
+		if fixture, ok := fixtures[call]; ok {
+			data, err := os.ReadFile("testdata/" + fixture)
+			return string(data), err
+		}
+		calls = append(calls, call)
+		return "", nil
+	}
+	return &calls
+}
+
+// TestParseNmcliTerse: verifies escaped colons and backslashes in terse output
+func TestParseNmcliTerse(t *testing.T) {
+	rows := parseNmcliTerse("IP6.DNS[1]:fe80\\:\\:1\nNAME:My\\\\Net\\:work\n")
+	want := [][]string{{"IP6.DNS[1]", "fe80::1"}, {"NAME", "My\\Net:work"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("expected %q, got %q", want, rows)
+	}
+}
+
+// TestNmcliBackend_Read: verifies current DNS and connected devices are parsed from fixtures
+func TestNmcliBackend_Read(t *testing.T) {
+	mockNmcli(t)
+	b := NmcliBackend{}
+
+	current, err := b.GetCurrentDNS("wlp2s0")
+	if err != nil {
+		t.Fatalf("GetCurrentDNS returned error: %v", err)
+	}
+	if !reflect.DeepEqual(current, []string{"192.168.1.1", "192.168.1.2", "fe80::1"}) {
+		t.Fatalf("unexpected current DNS: %v", current)
+	}
+
+	names, err := b.GetNetworkInterfaces()
+	if err != nil {
+		t.Fatalf("GetNetworkInterfaces returned error: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"wlp2s0"}) {
+		t.Fatalf("unexpected interfaces: %v", names)
+	}
+}
+
+// TestNmcliBackend_ApplySnapshotRestore: verifies apply modifies the active connection,
+// Snapshot reads its DNS properties and Restore writes them back
+func TestNmcliBackend_ApplySnapshotRestore(t *testing.T) {
+	calls := mockNmcli(t)
+	b := NmcliBackend{}
+	uuid := "0b6f4a5e-2c1d-4f7b-9a31-2f6d1c0e8a11"
+
+	state, err := b.Snapshot("wlp2s0")
+	if err != nil {
+		t.Fatalf("Snapshot returned error: %v", err)
+	}
+	want := DNSState{
+		Interface: "wlp2s0",
+		Backend:   "nmcli",
+		IPv4:      FamilyState{DHCP: false, Servers: []string{"10.10.0.53", "10.10.0.54"}},
+		IPv6:      FamilyState{DHCP: true, Servers: []string{"fd00::53"}},
+	}
+	if !reflect.DeepEqual(state, want) {
+		t.Fatalf("expected %+v, got %+v", want, state)
+	}
+
+	p := config2.Profile{Name: "cf", Servers: []string{"1.1.1.1", "2606:4700:4700::1111"}, Interface: "wlp2s0"}
+	if _, err := b.ApplyProfile(p); err != nil {
+		t.Fatalf("ApplyProfile returned error: %v", err)
+	}
+	if _, err := b.Restore(state); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+	if _, err := b.Rollback("wlp2s0"); err != nil {
+		t.Fatalf("Rollback returned error: %v", err)
+	}
+
+	wantCalls := []string{
+		"connection modify " + uuid + " ipv4.dns 1.1.1.1 ipv4.ignore-auto-dns yes ipv6.dns 2606:4700:4700::1111 ipv6.ignore-auto-dns yes",
+		"connection up " + uuid,
+		// the fixture still reports the original IPv6 settings, so they are left alone
+		"connection modify " + uuid + " ipv4.dns 10.10.0.53,10.10.0.54 ipv4.ignore-auto-dns yes",
+		"connection up " + uuid,
+		"connection modify " + uuid + " ipv4.dns  ipv4.ignore-auto-dns no ipv6.dns  ipv6.ignore-auto-dns no",
+		"connection up " + uuid,
+	}
+	if !reflect.DeepEqual(*calls, wantCalls) {
+		t.Fatalf("unexpected nmcli calls:\n%s", strings.Join(*calls, "\n"))
+	}
+}
+
+// TestNmcliBackend_ApplyIPv6Only: verifies a profile without IPv4 servers
+// leaves the IPv4 properties of the connection alone
+func TestNmcliBackend_ApplyIPv6Only(t *testing.T) {
+	calls := mockNmcli(t)
+	uuid := "0b6f4a5e-2c1d-4f7b-9a31-2f6d1c0e8a11"
+
+	p := config2.Profile{Name: "cf6", IPv6: []string{"2606:4700:4700::1111"}, Interface: "wlp2s0"}
+	if _, err := (NmcliBackend{}).ApplyProfile(p); err != nil {
+		t.Fatalf("ApplyProfile returned error: %v", err)
+	}
+
+	wantCalls := []string{
+		"connection modify " + uuid + " ipv6.dns 2606:4700:4700::1111 ipv6.ignore-auto-dns yes",
+		"connection up " + uuid,
+	}
+	if !reflect.DeepEqual(*calls, wantCalls) {
+		t.Fatalf("unexpected nmcli calls:\n%s", strings.Join(*calls, "\n"))
+	}
+}
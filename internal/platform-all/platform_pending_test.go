@@ -0,0 +1,134 @@
+package platform_all
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// applyPending applies p through the fake netsh and records it as pending
+// until deadline
+func applyPending(t *testing.T, p config2.Profile, deadline time.Time) {
+	res, err := ApplyProfile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPending(PendingApply{Interface: p.Interface, Profile: p.Name, Snapshot: res.Snapshot, Deadline: deadline}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRevertExpired: verifies only applies past their deadline are reverted,
+// newest first, restoring the settings from before the first of them
+func TestRevertExpired(t *testing.T) {
+	f := mockFakeNetsh(t)
+	PendingPath = filepath.Join(t.TempDir(), "pending.json")
+	t.Cleanup(func() { PendingPath = "" })
+
+	now := time.Now()
+	applyPending(t, config2.Profile{Name: "cf", Servers: []string{"1.1.1.1"}, Interface: "Ethernet"}, now.Add(-time.Minute))
+	applyPending(t, config2.Profile{Name: "google", Servers: []string{"8.8.8.8"}, Interface: "Ethernet"}, now.Add(-time.Second))
+	applyPending(t, config2.Profile{Name: "cf", Servers: []string{"1.1.1.1"}, Interface: "Wi-Fi"}, now.Add(time.Minute))
+
+	results, err := RevertExpired(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].RolledBack {
+		t.Fatalf("expected two reverts, got %+v", results)
+	}
+	if state := f.state["ipv4 Ethernet"]; !reflect.DeepEqual(state.Servers, []string{"10.0.0.53", "10.0.0.54"}) {
+		t.Fatalf("settings before the first apply not restored: %+v", state)
+	}
+
+	pending, _ := LoadPending()
+	if len(pending) != 1 || pending[0].Interface != "Wi-Fi" {
+		t.Fatalf("expected the Wi-Fi apply to stay pending, got %+v", pending)
+	}
+	if results, err := RevertExpired(now); err != nil || len(results) != 0 {
+		t.Fatalf("nothing should be left to revert: %+v, %v", results, err)
+	}
+}
+
+// TestConfirmPending: verifies confirming keeps the applied servers and
+// leaves nothing to revert, and that RevertPending reverts by interface
+func TestConfirmPending(t *testing.T) {
+	f := mockFakeNetsh(t)
+	PendingPath = filepath.Join(t.TempDir(), "pending.json")
+	t.Cleanup(func() { PendingPath = "" })
+
+	past := time.Now().Add(-time.Minute)
+	applyPending(t, config2.Profile{Name: "cf", Servers: []string{"1.1.1.1"}, Interface: "Ethernet"}, past)
+	applyPending(t, config2.Profile{Name: "cf", Servers: []string{"1.1.1.1"}, Interface: "Wi-Fi"}, past)
+
+	confirmed, err := ConfirmPending("Ethernet")
+	if err != nil || len(confirmed) != 1 || confirmed[0].Profile != "cf" {
+		t.Fatalf("unexpected confirmation %+v, %v", confirmed, err)
+	}
+
+	results, err := RevertPending("")
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected the Wi-Fi apply to be reverted, got %+v, %v", results, err)
+	}
+	if state := f.state["ipv4 Ethernet"]; !reflect.DeepEqual(state.Servers, []string{"1.1.1.1"}) {
+		t.Fatalf("confirmed servers reverted: %+v", state)
+	}
+	if state := f.state["ipv4 Wi-Fi"]; !state.DHCP {
+		t.Fatalf("Wi-Fi not reverted to DHCP: %+v", state)
+	}
+	if pending, _ := LoadPending(); len(pending) != 0 {
+		t.Fatalf("pending applies left: %+v", pending)
+	}
+}
+
+// TestOwnedPending: verifies an owned apply outlives its first deadline
+// while renewed, is not confirmed or reverted by hand and is reverted once
+// it expired
+func TestOwnedPending(t *testing.T) {
+	f := mockFakeNetsh(t)
+	PendingPath = filepath.Join(t.TempDir(), "pending.json")
+	t.Cleanup(func() { PendingPath = "" })
+
+	now := time.Now()
+	res, err := ApplyProfile(config2.Profile{Name: "proxy", Servers: []string{"127.0.0.1"}, Interface: "Ethernet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPending(PendingApply{Interface: "Ethernet", Profile: "proxy", Snapshot: res.Snapshot, Deadline: now.Add(-time.Second), Owner: "serve"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenewPending([]int{res.Snapshot}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := RevertExpired(now); err != nil || len(results) != 0 {
+		t.Fatalf("a renewed apply must not be reverted: %+v, %v", results, err)
+	}
+	if confirmed, err := ConfirmPending(""); err != nil || len(confirmed) != 0 {
+		t.Fatalf("an owned apply must not be confirmed: %+v, %v", confirmed, err)
+	}
+	if results, err := RevertPending(""); err != nil || len(results) != 0 {
+		t.Fatalf("an owned apply must not be reverted by hand: %+v, %v", results, err)
+	}
+
+	results, err := RevertExpired(now.Add(2 * time.Minute))
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected the expired apply to be reverted, got %+v, %v", results, err)
+	}
+	if state := f.state["ipv4 Ethernet"]; !reflect.DeepEqual(state.Servers, []string{"10.0.0.53", "10.0.0.54"}) {
+		t.Fatalf("settings before the apply not restored: %+v", state)
+	}
+	if pending, _ := LoadPending(); len(pending) != 0 {
+		t.Fatalf("pending applies left: %+v", pending)
+	}
+
+	AddPending(PendingApply{Interface: "Ethernet", Snapshot: 42, Deadline: now, Owner: "serve"})
+	if err := RemovePending([]int{42}); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := LoadPending(); len(pending) != 0 {
+		t.Fatalf("removed apply left: %+v", pending)
+	}
+}
@@ -0,0 +1,137 @@
+package platform_all
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// mockPowerShell replaces PowerShellExec for the duration of a test.
+// Scripts reading state are answered from the recorded JSON fixtures by
+// their leading cmdlet, every other script is recorded.
+func mockPowerShell(t *testing.T, dnsClient string) *[]string {
+	var calls []string
+	orig := PowerShellExec
+	t.Cleanup(func() { PowerShellExec = orig })
+
+	PowerShellExec = func(script string) (string, error) {
+		fixture := ""
+		switch {
+		case strings.Contains(script, "Get-DnsClientServerAddress"):
+			fixture = dnsClient
+		case strings.HasPrefix(script, "$g = (Get-NetAdapter"):
+			fixture = "powershell-dns-registry.json"
+		case strings.Contains(script, "Get-NetAdapter |"):
+			fixture = "powershell-netadapter.json"
+		}
+		if fixture != "" {
+			data, err := os.ReadFile("testdata/" + fixture)
+			return string(data), err
+		}
+		calls = append(calls, script)
+		return "", nil
+	}
+	return &calls
+}
+
+// TestPowerShellBackend_Read: verifies current DNS of both families, a
+// single-object answer and the adapter list are parsed from recorded JSON
+func TestPowerShellBackend_Read(t *testing.T) {
+	mockPowerShell(t, "powershell-dnsclient.json")
+	b := PowerShellBackend{}
+
+	servers, err := b.GetCurrentDNS("Wi-Fi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.1.1.1", "1.0.0.1", "2606:4700:4700::1111"}; !reflect.DeepEqual(servers, want) {
+		t.Fatalf("expected %v, got %v", want, servers)
+	}
+
+	mockPowerShell(t, "powershell-dnsclient-single.json")
+	servers, err = b.GetCurrentDNS("Ethernet")
+	if err != nil || !reflect.DeepEqual(servers, []string{"192.168.1.1"}) {
+		t.Fatalf("unexpected single-object result: %v, %v", servers, err)
+	}
+
+	ifaces, err := b.GetNetworkInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Wi-Fi", "vEthernet (WSL)"}; !reflect.DeepEqual(ifaces, want) {
+		t.Fatalf("expected %v, got %v", want, ifaces)
+	}
+
+	infos, err := b.ReportInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, len(infos))
+	for i, info := range infos {
+		got[i] = strings.Join([]string{info.Name, info.AdminState, info.State, info.Type, info.MAC}, "|")
+	}
+	want := []string{
+		"Wi-Fi|enabled|connected|wireless|3c:22:fb:00:11:22",
+		"Ethernet|enabled|disconnected|ethernet|00:1a:2b:3c:4d:5e",
+		"vEthernet (WSL)|enabled|connected|virtual|00:15:5d:01:02:03",
+		"Ethernet 2|disabled|disconnected|ethernet|00:1a:2b:3c:4d:5f",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if infos[0].Index != 12 {
+		t.Fatalf("expected index 12, got %d", infos[0].Index)
+	}
+}
+
+// TestPowerShellBackend_ApplySnapshotRestore: verifies apply sets both families
+// in one call, Snapshot reads the static servers and Restore resets before setting them
+func TestPowerShellBackend_ApplySnapshotRestore(t *testing.T) {
+	calls := mockPowerShell(t, "powershell-dnsclient.json")
+	b := PowerShellBackend{}
+
+	p := config2.Profile{Name: "cf", Interface: "Kid's Wi-Fi", Servers: []string{"1.1.1.1", "1.0.0.1"}, IPv6: []string{"2606:4700:4700::1111"}}
+	if res, err := b.ApplyProfile(p); err != nil || !res.Ok {
+		t.Fatalf("apply failed: %+v, %v", res, err)
+	}
+	want := []string{"Set-DnsClientServerAddress -InterfaceAlias 'Kid''s Wi-Fi' -ServerAddresses ('1.1.1.1','1.0.0.1','2606:4700:4700::1111')"}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Fatalf("expected %q, got %q", want, *calls)
+	}
+
+	state, err := b.Snapshot("Wi-Fi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantState := DNSState{Interface: "Wi-Fi", Backend: "powershell",
+		IPv4: FamilyState{Servers: []string{"1.1.1.1", "1.0.0.1"}}, IPv6: FamilyState{DHCP: true}}
+	if !reflect.DeepEqual(state, wantState) {
+		t.Fatalf("expected %+v, got %+v", wantState, state)
+	}
+
+	*calls = nil
+	if res, err := b.Restore(state); err != nil || !res.Ok {
+		t.Fatalf("restore failed: %+v, %v", res, err)
+	}
+	want = []string{"Set-DnsClientServerAddress -InterfaceAlias 'Wi-Fi' -ResetServerAddresses; " +
+		"Set-DnsClientServerAddress -InterfaceAlias 'Wi-Fi' -ServerAddresses ('1.1.1.1','1.0.0.1')"}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Fatalf("expected %q, got %q", want, *calls)
+	}
+
+	*calls = nil
+	if _, err := b.Rollback("Wi-Fi"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Set-DnsClientServerAddress -InterfaceAlias 'Wi-Fi' -ResetServerAddresses"}; !reflect.DeepEqual(*calls, want) {
+		t.Fatalf("expected %q, got %q", want, *calls)
+	}
+
+	p.Servers = []string{"1.1.1.1'; Remove-Item C:\\ -Recurse; '"}
+	if _, err := b.ApplyProfile(p); err == nil {
+		t.Fatal("expected an error for a server that is not an IP address")
+	}
+}
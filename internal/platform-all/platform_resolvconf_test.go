@@ -0,0 +1,105 @@
+package platform_all
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+const sampleResolvConf = `# generated by dhclient
+search corp.example.com
+nameserver 192.168.1.1
+nameserver 192.168.1.2
+options edns0 trust-ad
+`
+
+func writeResolvConf(t *testing.T, content string) *ResolvConfBackend {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("cannot write temp resolv.conf: %v", err)
+	}
+	return NewResolvConfBackend(path)
+}
+
+// TestResolvConfApply: verifies nameservers are replaced while search/options lines are kept
+func TestResolvConfApply(t *testing.T) {
+	b := writeResolvConf(t, sampleResolvConf)
+
+	p := config2.Profile{Name: "cf", Servers: []string{"1.1.1.1", "1.0.0.1"}, Interface: "eth0"}
+	if _, err := b.ApplyProfile(p); err != nil {
+		t.Fatalf("ApplyProfile returned error: %v", err)
+	}
+
+	data, _ := os.ReadFile(b.Path)
+	want := `# generated by dhclient
+search corp.example.com
+nameserver 1.1.1.1
+nameserver 1.0.0.1
+options edns0 trust-ad
+`
+	if string(data) != want {
+		t.Fatalf("unexpected resolv.conf:\n%s", data)
+	}
+
+	current, err := b.GetCurrentDNS("eth0")
+	if err != nil {
+		t.Fatalf("GetCurrentDNS returned error: %v", err)
+	}
+	if len(current) != 2 || current[0] != "1.1.1.1" || current[1] != "1.0.0.1" {
+		t.Fatalf("unexpected current DNS: %v", current)
+	}
+}
+
+// TestResolvConfRollback: verifies rollback restores the file of the latest
+// snapshot, even after several applies, and fails once it was used
+func TestResolvConfRollback(t *testing.T) {
+	b := writeResolvConf(t, sampleResolvConf)
+	origPath := SnapshotPath
+	t.Cleanup(func() { SnapshotPath = origPath })
+	SnapshotPath = filepath.Join(t.TempDir(), "snapshots.json")
+
+	state, err := b.Snapshot("eth0")
+	if err != nil {
+		t.Fatalf("Snapshot returned error: %v", err)
+	}
+	if _, err := SaveSnapshot(state, "x"); err != nil {
+		t.Fatalf("SaveSnapshot returned error: %v", err)
+	}
+
+	for _, s := range []string{"1.1.1.1", "8.8.8.8"} {
+		p := config2.Profile{Name: "x", Servers: []string{s}, Interface: "eth0"}
+		if _, err := b.ApplyProfile(p); err != nil {
+			t.Fatalf("ApplyProfile returned error: %v", err)
+		}
+	}
+
+	if _, err := b.Rollback("eth0"); err != nil {
+		t.Fatalf("Rollback returned error: %v", err)
+	}
+
+	data, _ := os.ReadFile(b.Path)
+	if string(data) != sampleResolvConf {
+		t.Fatalf("rollback did not restore original file:\n%s", data)
+	}
+
+	if _, err := b.Rollback("eth0"); err == nil {
+		t.Fatal("expected error when no snapshot exists")
+	}
+}
+
+// TestResolvConfApplyWithoutNameservers: verifies servers are appended when the file has none
+func TestResolvConfApplyWithoutNameservers(t *testing.T) {
+	b := writeResolvConf(t, "search lan\n")
+
+	p := config2.Profile{Name: "g", Servers: []string{"8.8.8.8"}, Interface: "eth0"}
+	if _, err := b.ApplyProfile(p); err != nil {
+		t.Fatalf("ApplyProfile returned error: %v", err)
+	}
+
+	data, _ := os.ReadFile(b.Path)
+	if string(data) != "search lan\nnameserver 8.8.8.8\n" {
+		t.Fatalf("unexpected resolv.conf:\n%s", data)
+	}
+}
@@ -0,0 +1,129 @@
+package platform_all
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// mockResolvectl replaces ResolvectlExec for the duration of a test.
+// `status` calls are answered with the given fixture, every call is recorded.
+func mockResolvectl(t *testing.T, fixture string) *[][]string {
+	var calls [][]string
+	orig := ResolvectlExec
+	t.Cleanup(func() { ResolvectlExec = orig })
+
+	ResolvectlExec = func(args ...string) (string, error) {
+		calls = append(calls, args)
+		if len(args) > 0 && args[0] == "status" {
+			data, err := os.ReadFile("testdata/" + fixture)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		}
+		return "", nil
+	}
+	return &calls
+}
+
+// TestParseResolvectlStatus: verifies per-link servers are read from current resolvectl output
+func TestParseResolvectlStatus(t *testing.T) {
+	data, err := os.ReadFile("testdata/resolvectl-status.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := parseResolvectlStatus(string(data))
+	if len(links) != 3 {
+		t.Fatalf("expected 3 sections, got %d", len(links))
+	}
+
+	if !reflect.DeepEqual(links[0].Servers, []string{"9.9.9.9", "149.112.112.112"}) {
+		t.Fatalf("unexpected global servers: %v", links[0].Servers)
+	}
+
+	eth := links[1]
+	if eth.Index != 2 || eth.Name != "enp0s3" || eth.CurrentServer != "192.168.1.1" {
+		t.Fatalf("unexpected link: %+v", eth)
+	}
+	if !reflect.DeepEqual(eth.Servers, []string{"192.168.1.1", "fd00::1"}) {
+		t.Fatalf("unexpected link servers: %v", eth.Servers)
+	}
+
+	if links[2].Name != "wlp2s0" || len(links[2].Servers) != 0 {
+		t.Fatalf("unexpected link: %+v", links[2])
+	}
+}
+
+// TestParseResolvectlStatusLegacy: verifies the multi-line server layout of older systemd versions
+func TestParseResolvectlStatusLegacy(t *testing.T) {
+	data, err := os.ReadFile("testdata/resolvectl-status-legacy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := parseResolvectlStatus(string(data))
+	if len(links) != 2 {
+		t.Fatalf("expected 2 sections, got %d", len(links))
+	}
+
+	want := []string{"10.0.2.3", "10.0.2.4", "2001:db8::53"}
+	if !reflect.DeepEqual(links[1].Servers, want) {
+		t.Fatalf("expected %v, got %v", want, links[1].Servers)
+	}
+	if len(links[0].Servers) != 0 {
+		t.Fatalf("NTA continuation lines must not be read as servers: %v", links[0].Servers)
+	}
+}
+
+// TestResolvectlBackend_Mock: verifies the commands issued for apply, status and rollback
+func TestResolvectlBackend_Mock(t *testing.T) {
+	calls := mockResolvectl(t, "resolvectl-status.txt")
+	b := ResolvectlBackend{}
+
+	p := config2.Profile{Name: "cf", Servers: []string{"1.1.1.1", "1.0.0.1"}, Interface: "enp0s3"}
+	if _, err := b.ApplyProfile(p); err != nil {
+		t.Fatalf("ApplyProfile returned error: %v", err)
+	}
+
+	current, err := b.GetCurrentDNS("enp0s3")
+	if err != nil {
+		t.Fatalf("GetCurrentDNS returned error: %v", err)
+	}
+	if !reflect.DeepEqual(current, []string{"192.168.1.1", "fd00::1"}) {
+		t.Fatalf("unexpected current DNS: %v", current)
+	}
+
+	if _, err := b.Rollback("enp0s3"); err != nil {
+		t.Fatalf("Rollback returned error: %v", err)
+	}
+
+	var got []string
+	for _, c := range *calls {
+		got = append(got, strings.Join(c, " "))
+	}
+	want := []string{"dns enp0s3 1.1.1.1 1.0.0.1", "status enp0s3", "revert enp0s3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected calls %v, got %v", want, got)
+	}
+}
+
+// TestResolvectlBackend_Error: verifies resolvectl failures are reported with their output
+func TestResolvectlBackend_Error(t *testing.T) {
+	orig := ResolvectlExec
+	t.Cleanup(func() { ResolvectlExec = orig })
+	ResolvectlExec = func(args ...string) (string, error) {
+		return "Failed to set DNS configuration: Link lo is loopback device.", fmt.Errorf("exit status 1")
+	}
+
+	p := config2.Profile{Name: "cf", Servers: []string{"1.1.1.1"}, Interface: "lo"}
+	_, err := ResolvectlBackend{}.ApplyProfile(p)
+	if err == nil || !strings.Contains(err.Error(), "loopback") {
+		t.Fatalf("expected resolvectl error with output, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package platform_all
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// mockNetshSnapshots installs a fakeNetsh where Ethernet has static IPv4
+// servers and DHCP IPv6, isolates the snapshot store and returns the
+// recorded calls that change the configuration
+func mockNetshSnapshots(t *testing.T) *[]string {
+	return &mockFakeNetsh(t).calls
+}
+
+// mockFakeNetsh is mockNetshSnapshots returning the fakeNetsh itself
+func mockFakeNetsh(t *testing.T) *fakeNetsh {
+	origExec, origPath := NetshExec, SnapshotPath
+	t.Cleanup(func() { NetshExec, SnapshotPath = origExec, origPath })
+	SnapshotPath = filepath.Join(t.TempDir(), "snapshots.json")
+
+	f := newFakeNetsh()
+	f.state["ipv4 Ethernet"] = FamilyState{Servers: []string{"10.0.0.53", "10.0.0.54"}}
+	NetshExec = f.exec
+	return f
+}
+
+// TestParseNetshDNSServers: verifies static lists keep their order and DHCP servers are not captured
+func TestParseNetshDNSServers(t *testing.T) {
+	data, _ := os.ReadFile("testdata/netsh-dnsservers-static.txt")
+	fs := parseNetshDNSServers(string(data))
+	if fs.DHCP || !reflect.DeepEqual(fs.Servers, []string{"10.0.0.53", "10.0.0.54"}) {
+		t.Fatalf("unexpected static state: %+v", fs)
+	}
+
+	data, _ = os.ReadFile("testdata/netsh-dnsservers-dhcp.txt")
+	fs = parseNetshDNSServers(string(data))
+	if !fs.DHCP || len(fs.Servers) != 0 {
+		t.Fatalf("unexpected DHCP state: %+v", fs)
+	}
+}
+
+// TestRollbackRestoresSnapshot: verifies rollback restores the static servers
+// captured before apply instead of resetting to DHCP
+func TestRollbackRestoresSnapshot(t *testing.T) {
+	calls := mockNetshSnapshots(t)
+
+	p := config2.Profile{Name: "cf", Servers: []string{"1.1.1.1"}, Interface: "Ethernet"}
+	if _, err := ApplyProfile(p); err != nil {
+		t.Fatalf("ApplyProfile returned error: %v", err)
+	}
+
+	snaps, err := ListSnapshots("Ethernet")
+	if err != nil || len(snaps) != 1 || snaps[0].Profile != "cf" {
+		t.Fatalf("expected one snapshot, got %+v (%v)", snaps, err)
+	}
+
+	*calls = nil
+	if _, err := Rollback("Ethernet"); err != nil {
+		t.Fatalf("Rollback returned error: %v", err)
+	}
+
+	want := []string{
+		"interface ipv4 set dnsservers name=Ethernet source=static address=10.0.0.53 validate=no",
+		"interface ipv4 add dnsservers name=Ethernet address=10.0.0.54 index=2 validate=no",
+		"interface ipv6 set dnsservers name=Ethernet source=dhcp",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Fatalf("unexpected netsh calls:\n%s", strings.Join(*calls, "\n"))
+	}
+
+	if snaps, _ := ListSnapshots("Ethernet"); len(snaps) != 0 {
+		t.Fatalf("snapshot should be consumed by rollback, got %+v", snaps)
+	}
+}
+
+// TestRollbackTo: verifies restoring an older snapshot drops it and all newer ones of the interface
+func TestRollbackTo(t *testing.T) {
+	mockNetshSnapshots(t)
+
+	for _, iface := range []string{"Ethernet", "Wi-Fi", "Ethernet"} {
+		p := config2.Profile{Name: "cf", Servers: []string{"1.1.1.1"}, Interface: iface}
+		if _, err := ApplyProfile(p); err != nil {
+			t.Fatalf("ApplyProfile returned error: %v", err)
+		}
+	}
+
+	if _, err := RollbackTo(1); err != nil {
+		t.Fatalf("RollbackTo returned error: %v", err)
+	}
+
+	snaps, _ := ListSnapshots("")
+	if len(snaps) != 1 || snaps[0].ID != 2 || snaps[0].State.Interface != "Wi-Fi" {
+		t.Fatalf("expected only the Wi-Fi snapshot to remain, got %+v", snaps)
+	}
+
+	if _, err := RollbackTo(42); err == nil {
+		t.Fatal("expected error for unknown snapshot")
+	}
+}
+
+// stepNames returns the names of the steps of res, in order
+func stepNames(res ApplyResult) []string {
+	var names []string
+	for _, s := range res.Steps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// TestApplyProfileTransactional: verifies a successful apply lists its steps
+// and keeps the snapshot, and a failing secondary server restores the
+// previous servers and drops the snapshot again
+func TestApplyProfileTransactional(t *testing.T) {
+	f := mockFakeNetsh(t)
+	p := config2.Profile{Name: "cf", Servers: []string{"1.1.1.1", "1.0.0.1", "8.8.8.8"}, Interface: "Ethernet"}
+
+	res, err := ApplyProfile(p)
+	if err != nil || !res.Ok || res.RolledBack {
+		t.Fatalf("apply failed: %+v, %v", res, err)
+	}
+	if want := []string{StepSnapshot, StepApply, StepVerify}; !reflect.DeepEqual(stepNames(res), want) {
+		t.Fatalf("expected steps %v, got %+v", want, res.Steps)
+	}
+	if snaps, _ := ListSnapshots("Ethernet"); len(snaps) != 1 {
+		t.Fatalf("expected one snapshot, got %+v", snaps)
+	}
+	if _, err := Rollback("Ethernet"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.fail = "interface ip add dns name=Ethernet addr=8.8.8.8"
+	res, err = ApplyProfile(p)
+	if err == nil || res.Ok || !res.RolledBack {
+		t.Fatalf("expected a rolled back failure, got %+v, %v", res, err)
+	}
+	if want := []string{StepSnapshot, StepApply, StepRestore}; !reflect.DeepEqual(stepNames(res), want) {
+		t.Fatalf("expected steps %v, got %+v", want, res.Steps)
+	}
+	if res.Steps[1].Ok || !res.Steps[2].Ok {
+		t.Fatalf("unexpected step outcomes %+v", res.Steps)
+	}
+	if state := f.state["ipv4 Ethernet"]; !reflect.DeepEqual(state.Servers, []string{"10.0.0.53", "10.0.0.54"}) {
+		t.Fatalf("previous servers not restored: %+v", state)
+	}
+	if snaps, _ := ListSnapshots("Ethernet"); len(snaps) != 0 {
+		t.Fatalf("snapshot of a rolled back apply kept: %+v", snaps)
+	}
+}
+
+// TestApplyProfileVerifyFails: verifies servers the interface does not use
+// after applying are detected and the previous settings restored, and that
+// a failing restore keeps the snapshot
+func TestApplyProfileVerifyFails(t *testing.T) {
+	f := mockFakeNetsh(t)
+	p := config2.Profile{Name: "cf", Servers: []string{"1.1.1.1"}, IPv6: []string{"2606:4700:4700::1111"}, Interface: "Ethernet"}
+
+	f.ignore = "interface ipv6 set dnsservers name=Ethernet source=static"
+	res, err := ApplyProfile(p)
+	if err == nil || !res.RolledBack || !strings.Contains(err.Error(), "2606:4700:4700::1111") {
+		t.Fatalf("expected a verification failure, got %+v, %v", res, err)
+	}
+	if want := []string{StepSnapshot, StepApply, StepVerify, StepRestore}; !reflect.DeepEqual(stepNames(res), want) {
+		t.Fatalf("expected steps %v, got %+v", want, res.Steps)
+	}
+	if state := f.state["ipv4 Ethernet"]; !reflect.DeepEqual(state.Servers, []string{"10.0.0.53", "10.0.0.54"}) {
+		t.Fatalf("previous servers not restored: %+v", state)
+	}
+
+	f.fail = "interface ipv4 set dnsservers"
+	res, err = ApplyProfile(p)
+	if err == nil || res.RolledBack || res.Steps[len(res.Steps)-1].Ok {
+		t.Fatalf("expected a failed restore, got %+v, %v", res, err)
+	}
+	if snaps, _ := ListSnapshots("Ethernet"); len(snaps) != 1 {
+		t.Fatalf("snapshot of a failed restore dropped: %+v", snaps)
+	}
+}
+
+// TestSaveSnapshotConcurrent: verifies concurrent writers neither lose
+// snapshots nor hand out the same ID twice, and leave no lock or
+// temporary file behind
+func TestSaveSnapshotConcurrent(t *testing.T) {
+	origPath := SnapshotPath
+	t.Cleanup(func() { SnapshotPath = origPath })
+	SnapshotPath = filepath.Join(t.TempDir(), "snapshots.json")
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := SaveSnapshot(DNSState{Interface: "Ethernet", Backend: "netsh"}, "cloudflare")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("SaveSnapshot failed: %v", err)
+		}
+	}
+
+	snaps, err := LoadSnapshots()
+	if err != nil || len(snaps) != writers {
+		t.Fatalf("expected %d snapshots, got %d, %v", writers, len(snaps), err)
+	}
+	ids := map[int]bool{}
+	for _, s := range snaps {
+		ids[s.ID] = true
+	}
+	if len(ids) != writers {
+		t.Fatalf("snapshot IDs handed out twice: %+v", snaps)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(SnapshotPath))
+	if len(entries) != 1 {
+		t.Fatalf("expected only snapshots.json to be left, got %v", entries)
+	}
+}
@@ -0,0 +1,146 @@
+package platform_all
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SnapshotPath is the JSON file holding the snapshot history
+var SnapshotPath = defaultSnapshotPath()
+
+// MaxSnapshots bounds the history; the oldest snapshots are dropped first
+var MaxSnapshots = 50
+
+// Snapshot is the DNS configuration of an interface saved right before a
+// profile was applied on top of it.
+type Snapshot struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Profile   string    `json:"profile"`
+	State     DNSState  `json:"state"`
+}
+
+// defaultSnapshotPath returns the snapshot file inside the user config directory
+func defaultSnapshotPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "dns-switcher", "snapshots.json")
+}
+
+// LoadSnapshots reads the whole snapshot history, oldest first
+func LoadSnapshots() ([]Snapshot, error) {
+	var snaps []Snapshot
+	data, err := os.ReadFile(SnapshotPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return snaps, nil
+		}
+		return nil, fmt.Errorf("cannot read snapshots: %v", err)
+	}
+	if err := json.Unmarshal(data, &snaps); err != nil {
+		return nil, fmt.Errorf("cannot parse snapshots %s: %v", SnapshotPath, err)
+	}
+	return snaps, nil
+}
+
+// writeSnapshots replaces the snapshot history on disk. Callers hold the
+// state lock.
+func writeSnapshots(snaps []Snapshot) error {
+	if err := os.MkdirAll(filepath.Dir(SnapshotPath), 0755); err != nil {
+		return fmt.Errorf("cannot create snapshot directory: %v", err)
+	}
+	data, err := json.MarshalIndent(snaps, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(SnapshotPath, data, 0644); err != nil {
+		return fmt.Errorf("cannot write snapshots: %v", err)
+	}
+	return nil
+}
+
+// SaveSnapshot appends state to the history and returns the stored snapshot.
+// profile is the name of the profile about to be applied.
+func SaveSnapshot(state DNSState, profile string) (Snapshot, error) {
+	snap := Snapshot{ID: 1, CreatedAt: time.Now(), Profile: profile, State: state}
+	err := withStateLock(func() error {
+		snaps, err := LoadSnapshots()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range snaps {
+			if s.ID >= snap.ID {
+				snap.ID = s.ID + 1
+			}
+		}
+
+		snaps = append(snaps, snap)
+		if MaxSnapshots > 0 && len(snaps) > MaxSnapshots {
+			snaps = snaps[len(snaps)-MaxSnapshots:]
+		}
+		return writeSnapshots(snaps)
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
+
+// ListSnapshots returns the snapshots of iface, newest first.
+// An empty iface returns the snapshots of all interfaces.
+func ListSnapshots(iface string) ([]Snapshot, error) {
+	snaps, err := LoadSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Snapshot
+	for _, s := range snaps {
+		if iface == "" || s.State.Interface == iface {
+			out = append(out, s)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out, nil
+}
+
+// FindSnapshot returns the snapshot with the given id
+func FindSnapshot(id int) (Snapshot, error) {
+	snaps, err := LoadSnapshots()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for _, s := range snaps {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return Snapshot{}, fmt.Errorf("snapshot %d not found", id)
+}
+
+// DropSnapshotsFrom removes snap and every newer snapshot of the same
+// interface, since they describe states taken on top of it.
+func DropSnapshotsFrom(snap Snapshot) error {
+	return withStateLock(func() error {
+		snaps, err := LoadSnapshots()
+		if err != nil {
+			return err
+		}
+
+		var kept []Snapshot
+		for _, s := range snaps {
+			if s.State.Interface == snap.State.Interface && s.ID >= snap.ID {
+				continue
+			}
+			kept = append(kept, s)
+		}
+		return writeSnapshots(kept)
+	})
+}
@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// CacheOptions configure the response cache of a Proxy. A Size of 0
+// disables the cache.
+type CacheOptions struct {
+	Size int // entries kept, the least recently used one is evicted first
+	// MinTTL and MaxTTL bound how long an answer is kept, whatever the TTLs
+	// of its records; the records are served with the bounded TTLs
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// MaxNegativeTTL bounds how long NXDOMAIN and NODATA answers are kept
+	MaxNegativeTTL time.Duration
+	// Prefetch refreshes an entry that was asked for more than once when it
+	// is asked for in the last tenth of its TTL, so hot names never expire
+	Prefetch bool
+}
+
+// CacheStats are the counters of a cache
+type CacheStats struct {
+	Entries    int    `json:"entries"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Prefetches uint64 `json:"prefetches"`
+	Evictions  uint64 `json:"evictions"`
+}
+
+// HitRate returns the fraction of lookups answered from the cache, 0 before any
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// String describes the counters in one line
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses (%.0f%% hit rate), %d entries, %d prefetches, %d evictions",
+		s.Hits, s.Misses, s.HitRate()*100, s.Entries, s.Prefetches, s.Evictions)
+}
+
+// cacheEntry is a response as it was stored, with its records' TTLs
+// already bounded
+type cacheEntry struct {
+	key         string
+	msg         *dns.Msg
+	stored      time.Time
+	ttl         time.Duration
+	hits        int
+	prefetching bool
+}
+
+// cache is a size-bounded LRU of responses keyed by question
+type cache struct {
+	opts CacheOptions
+
+	mu       sync.Mutex
+	lru      *list.List // of *cacheEntry, most recently used first
+	entries  map[string]*list.Element
+	counters CacheStats
+}
+
+// newCache returns an empty cache, or nil when opts.Size is 0
+func newCache(opts CacheOptions) *cache {
+	if opts.Size <= 0 {
+		return nil
+	}
+	if opts.MaxTTL <= 0 {
+		opts.MaxTTL = 24 * time.Hour
+	}
+	if opts.MaxNegativeTTL <= 0 {
+		opts.MaxNegativeTTL = time.Hour
+	}
+	return &cache{opts: opts, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+// cacheKey returns the key of the question of req. The DO and CD bits are
+// part of it since they change the records of the answer. ok is false for
+// requests that are not cached: with other than one question.
+func cacheKey(req *dns.Msg) (key string, ok bool) {
+	if len(req.Question) != 1 {
+		return "", false
+	}
+	q := req.Question[0]
+	do := false
+	if opt := req.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+	return fmt.Sprintf("%s/%d/%d/%t/%t", strings.ToLower(q.Name), q.Qtype, q.Qclass, do, req.CheckingDisabled), true
+}
+
+// get returns a copy of the cached response to req with the TTLs decreased
+// by the time it spent in the cache, or nil on a miss. prefetch tells the
+// caller to refresh the entry.
+func (c *cache) get(req *dns.Msg) (resp *dns.Msg, prefetch bool) {
+	key, ok := cacheKey(req)
+	if !ok {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, found := c.entries[key]
+	if !found {
+		c.counters.Misses++
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	age := now().Sub(e.stored)
+	if age >= e.ttl {
+		c.remove(el)
+		c.counters.Misses++
+		return nil, false
+	}
+
+	c.counters.Hits++
+	e.hits++
+	c.lru.MoveToFront(el)
+
+	resp = e.msg.Copy()
+	resp.Id = req.Id
+	resp.Question = req.Question
+	elapsed := uint32(age / time.Second)
+	for _, rr := range records(resp) {
+		if h := rr.Header(); h.Ttl > elapsed {
+			h.Ttl -= elapsed
+		} else {
+			h.Ttl = 0
+		}
+	}
+
+	if c.opts.Prefetch && e.hits > 1 && !e.prefetching && age >= e.ttl-e.ttl/10 {
+		e.prefetching = true
+		c.counters.Prefetches++
+		prefetch = true
+	}
+	return resp, prefetch
+}
+
+// put stores resp as the answer to req when it can be cached: an answer
+// for the lowest TTL of its records, NXDOMAIN and NODATA for the negative
+// TTL of the SOA in them (RFC 2308 section 5). Truncated responses, errors
+// and negative answers without a SOA are not cached.
+func (c *cache) put(req, resp *dns.Msg) {
+	key, ok := cacheKey(req)
+	if !ok || resp.Truncated {
+		return
+	}
+
+	msg := resp.Copy()
+	var ttl time.Duration
+	switch {
+	case msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0:
+		ttl = c.opts.MaxTTL
+		for _, rr := range records(msg) {
+			h := rr.Header()
+			h.Ttl = seconds(clamp(time.Duration(h.Ttl)*time.Second, c.opts.MinTTL, c.opts.MaxTTL))
+			ttl = min(ttl, time.Duration(h.Ttl)*time.Second)
+		}
+	case msg.Rcode == dns.RcodeSuccess || msg.Rcode == dns.RcodeNameError:
+		var soa *dns.SOA
+		for _, rr := range msg.Ns {
+			if s, ok := rr.(*dns.SOA); ok {
+				soa = s
+			}
+		}
+		if soa == nil {
+			return
+		}
+		ttl = clamp(time.Duration(min(soa.Hdr.Ttl, soa.Minttl))*time.Second, c.opts.MinTTL, c.opts.MaxNegativeTTL)
+		for _, rr := range records(msg) {
+			rr.Header().Ttl = seconds(ttl)
+		}
+	default:
+		return
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, found := c.entries[key]; found {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, msg: msg, stored: now(), ttl: ttl})
+	for c.lru.Len() > c.opts.Size {
+		c.remove(c.lru.Back())
+		c.counters.Evictions++
+	}
+}
+
+// flush drops every entry, keeping the counters
+func (c *cache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+}
+
+// stats returns the counters of the cache
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.counters
+	s.Entries = c.lru.Len()
+	return s
+}
+
+// remove drops an entry; c.mu must be held
+func (c *cache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	c.lru.Remove(el)
+}
+
+// records returns the records of m whose TTL counts, every one but the OPT
+// pseudo-record
+func records(m *dns.Msg) []dns.RR {
+	var out []dns.RR
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				out = append(out, rr)
+			}
+		}
+	}
+	return out
+}
+
+// clamp bounds d to [lo, hi]
+func clamp(d, lo, hi time.Duration) time.Duration {
+	return min(max(d, lo), hi)
+}
+
+// seconds converts d to a TTL
+func seconds(d time.Duration) uint32 {
+	return uint32(d / time.Second)
+}
@@ -0,0 +1,383 @@
+// Package proxy is a local DNS forwarder: it listens on a loopback address
+// and relays every query to the upstreams of a profile, racing the
+// healthiest ones and failing over to the others when they do not answer.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/Mreza2020/DNS-Switcher/internal/resolver"
+	"github.com/miekg/dns"
+)
+
+// DefaultListen is the address the proxy listens on unless told otherwise.
+// Operating systems only send queries to port 53.
+const DefaultListen = "127.0.0.1:53"
+
+// now is the clock of the health tracking, replaced in tests
+var now = time.Now
+
+// Event reports an upstream that stopped answering or answers again
+type Event struct {
+	Time     time.Time `json:"time"`
+	Upstream string    `json:"upstream"`
+	Up       bool      `json:"up"`
+	Error    string    `json:"error,omitempty"` // the last failure, when down
+}
+
+// String describes the event in one log line
+func (e Event) String() string {
+	if e.Up {
+		return fmt.Sprintf("%s upstream %s answers again", e.Time.Format(time.RFC3339), e.Upstream)
+	}
+	return fmt.Sprintf("%s upstream %s is down: %s", e.Time.Format(time.RFC3339), e.Upstream, e.Error)
+}
+
+// Options configure a Proxy
+type Options struct {
+	Profile config.Profile
+	Listen  string // UDP and TCP address, DefaultListen when empty
+
+	Timeout   time.Duration // per upstream query, default 2s
+	Race      int           // upstreams queried at once for every query, default 2
+	DoHMethod string
+	// MaxFails consecutive failures mark an upstream down for Cooldown;
+	// down upstreams are only asked after all the others failed
+	MaxFails int
+	Cooldown time.Duration
+
+	Cache CacheOptions
+
+	Log func(Event) // called for one event at a time
+}
+
+// withDefaults fills in the zero options
+func (o Options) withDefaults() Options {
+	if o.Listen == "" {
+		o.Listen = DefaultListen
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Race <= 0 {
+		o.Race = 2
+	}
+	if o.MaxFails <= 0 {
+		o.MaxFails = 3
+	}
+	if o.Cooldown <= 0 {
+		o.Cooldown = 30 * time.Second
+	}
+	if o.Log == nil {
+		o.Log = func(Event) {}
+	}
+	return o
+}
+
+// UpstreamStatus is the health of an upstream as tracked by the proxy
+type UpstreamStatus struct {
+	Upstream string        `json:"upstream"`
+	Up       bool          `json:"up"`
+	RTT      time.Duration `json:"rtt_ns"` // smoothed over the answered queries
+	Fails    int           `json:"fails"`  // consecutive failures
+}
+
+// upstream is a target and its health
+type upstream struct {
+	target resolver.Target
+
+	mu        sync.Mutex
+	rtt       time.Duration
+	fails     int
+	downUntil time.Time
+}
+
+// record updates the health of u with the outcome of a query. It returns
+// the event to log when u went down or came back.
+func (u *upstream) record(rtt time.Duration, err error, opts Options) *Event {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	t := now()
+	if err == nil {
+		wasDown := u.fails >= opts.MaxFails
+		u.fails, u.downUntil = 0, time.Time{}
+		if u.rtt == 0 {
+			u.rtt = rtt
+		} else {
+			u.rtt = (7*u.rtt + rtt) / 8
+		}
+		if wasDown {
+			return &Event{Time: t, Upstream: u.target.String(), Up: true}
+		}
+		return nil
+	}
+
+	u.fails++
+	if u.fails < opts.MaxFails {
+		return nil
+	}
+	// asked again after Cooldown, or earlier when no other upstream answers
+	u.downUntil = t.Add(opts.Cooldown)
+	if u.fails == opts.MaxFails {
+		return &Event{Time: t, Upstream: u.target.String(), Error: err.Error()}
+	}
+	return nil
+}
+
+// status returns the health of u at t
+func (u *upstream) status(t time.Time) UpstreamStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return UpstreamStatus{
+		Upstream: u.target.String(),
+		Up:       !t.Before(u.downUntil),
+		RTT:      u.rtt,
+		Fails:    u.fails,
+	}
+}
+
+// Proxy forwards the queries it receives to the upstreams of a profile
+type Proxy struct {
+	opts Options
+
+	mu        sync.RWMutex
+	profile   string
+	upstreams []*upstream
+
+	cache *cache // nil when disabled
+
+	logMu sync.Mutex
+
+	servers []*dns.Server
+	addr    string
+}
+
+// New returns a Proxy for opts.Profile; it listens once started
+func New(opts Options) (*Proxy, error) {
+	p := &Proxy{opts: opts.withDefaults(), cache: newCache(opts.Cache)}
+	if err := p.SetProfile(opts.Profile); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetProfile replaces the upstreams queries are forwarded to, taking
+// effect with the next query. The cached answers of the previous upstreams
+// are dropped.
+func (p *Proxy) SetProfile(profile config.Profile) error {
+	targets := resolver.TargetsOf(profile)
+	if len(targets) == 0 {
+		return fmt.Errorf("profile '%s' has no servers", profile.Name)
+	}
+	upstreams := make([]*upstream, len(targets))
+	for i, t := range targets {
+		upstreams[i] = &upstream{target: t}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profile, p.upstreams = profile.Name, upstreams
+	if p.cache != nil {
+		p.cache.flush()
+	}
+	return nil
+}
+
+// Profile returns the name of the profile queries are forwarded to
+func (p *Proxy) Profile() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.profile
+}
+
+// CacheStats returns the counters of the cache; ok is false when it is disabled
+func (p *Proxy) CacheStats() (stats CacheStats, ok bool) {
+	if p.cache == nil {
+		return CacheStats{}, false
+	}
+	return p.cache.stats(), true
+}
+
+// Upstreams returns the health of every upstream, in the profile's order
+func (p *Proxy) Upstreams() []UpstreamStatus {
+	t := now()
+	var out []UpstreamStatus
+	for _, u := range p.current() {
+		out = append(out, u.status(t))
+	}
+	return out
+}
+
+// current returns the upstreams of the active profile
+func (p *Proxy) current() []*upstream {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.upstreams
+}
+
+// ordered returns the upstreams in the order they are asked in: the ones
+// that are up, fastest first, then the ones that are down
+func (p *Proxy) ordered() []*upstream {
+	t := now()
+	upstreams := append([]*upstream{}, p.current()...)
+	status := make(map[*upstream]UpstreamStatus, len(upstreams))
+	for _, u := range upstreams {
+		status[u] = u.status(t)
+	}
+	sort.SliceStable(upstreams, func(i, j int) bool {
+		a, b := status[upstreams[i]], status[upstreams[j]]
+		if a.Up != b.Up {
+			return a.Up
+		}
+		return a.RTT < b.RTT
+	})
+	return upstreams
+}
+
+// Resolve answers req from the cache or else with forward, caching the
+// response. A cached entry due for prefetching is refreshed in the background.
+func (p *Proxy) Resolve(req *dns.Msg, overTCP bool) (*dns.Msg, error) {
+	if p.cache == nil {
+		return p.forward(req, overTCP)
+	}
+	if resp, prefetch := p.cache.get(req); resp != nil {
+		if prefetch {
+			go func() {
+				if resp, err := p.forward(req, false); err == nil {
+					p.cache.put(req, resp)
+				}
+			}()
+		}
+		return resp, nil
+	}
+	resp, err := p.forward(req, overTCP)
+	if err == nil {
+		p.cache.put(req, resp)
+	}
+	return resp, err
+}
+
+// forward sends req to the upstreams and returns the first usable
+// response. Race upstreams are asked at once; every one that fails is
+// replaced by the next, until one answers or none is left. SERVFAIL and
+// REFUSED count as failures, NXDOMAIN is an answer. overTCP is passed to
+// resolver.Forward.
+func (p *Proxy) forward(req *dns.Msg, overTCP bool) (*dns.Msg, error) {
+	upstreams := p.ordered()
+
+	type reply struct {
+		u   *upstream
+		msg *dns.Msg
+		err error
+	}
+	replies := make(chan reply, len(upstreams))
+	next, pending := 0, 0
+	ask := func() {
+		u := upstreams[next]
+		next++
+		pending++
+		go func() {
+			msg, res := resolver.Forward(u.target, req.Copy(), p.opts.Timeout, p.opts.DoHMethod, overTCP)
+			err := res.Error
+			if err == nil && (msg.Rcode == dns.RcodeServerFailure || msg.Rcode == dns.RcodeRefused) {
+				err = fmt.Errorf("rcode %s", dns.RcodeToString[msg.Rcode])
+			}
+			if e := u.record(res.RTT, err, p.opts); e != nil {
+				p.logMu.Lock()
+				p.opts.Log(*e)
+				p.logMu.Unlock()
+			}
+			replies <- reply{u, msg, err}
+		}()
+	}
+
+	for next < len(upstreams) && next < p.opts.Race {
+		ask()
+	}
+	var errs []error
+	for pending > 0 {
+		r := <-replies
+		pending--
+		if r.err == nil {
+			r.msg.Id = req.Id
+			return r.msg, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %v", r.u.target, r.err))
+		if next < len(upstreams) {
+			ask()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ServeDNS answers a client with Resolve, or with SERVFAIL when no
+// upstream answered. Responses to UDP clients are truncated to the size
+// they accept, so they retry over TCP.
+func (p *Proxy) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	_, overTCP := w.RemoteAddr().(*net.TCPAddr)
+	resp, err := p.Resolve(req, overTCP)
+	if err != nil {
+		resp = new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeServerFailure)
+	}
+	if !overTCP {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		resp.Truncate(size)
+	}
+	w.WriteMsg(resp)
+}
+
+// Start listens on opts.Listen over UDP and TCP and serves queries in the
+// background until Shutdown
+func (p *Proxy) Start() error {
+	pc, err := net.ListenPacket("udp", p.opts.Listen)
+	if err != nil {
+		return err
+	}
+	// the UDP address, so that port 0 picks the same port for both
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	p.addr = pc.LocalAddr().String()
+	p.servers = []*dns.Server{
+		{PacketConn: pc, Handler: p},
+		{Listener: l, Handler: p},
+	}
+
+	var wg sync.WaitGroup
+	for _, srv := range p.servers {
+		wg.Add(1)
+		srv.NotifyStartedFunc = wg.Done
+		go srv.ActivateAndServe()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Addr returns the address the proxy listens on, once started
+func (p *Proxy) Addr() string {
+	return p.addr
+}
+
+// Shutdown stops serving and closes the listeners
+func (p *Proxy) Shutdown() error {
+	var errs []error
+	for _, srv := range p.servers {
+		if err := srv.Shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
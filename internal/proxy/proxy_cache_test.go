@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// question returns a request for name and qtype
+func question(name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	return m
+}
+
+// answer returns a response to req with an A record per TTL
+func answer(req *dns.Msg, ttls ...uint32) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	for _, ttl := range ttls {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+	}
+	return resp
+}
+
+// negative returns an NXDOMAIN response to req, with a SOA of the given
+// TTL and minimum unless both are 0
+func negative(req *dns.Msg, ttl, minttl uint32) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetRcode(req, dns.RcodeNameError)
+	if ttl > 0 || minttl > 0 {
+		resp.Ns = append(resp.Ns, &dns.SOA{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+			Ns:  "ns.example.com.", Mbox: "hostmaster.example.com.", Minttl: minttl,
+		})
+	}
+	return resp
+}
+
+// ttls returns the TTLs of the records of m
+func ttls(m *dns.Msg) []uint32 {
+	var out []uint32
+	for _, rr := range records(m) {
+		out = append(out, rr.Header().Ttl)
+	}
+	return out
+}
+
+// refreshed reports whether the entry of req is cached and not waiting
+// for a prefetch
+func refreshed(c *cache, req *dns.Msg) bool {
+	key, _ := cacheKey(req)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	return ok && !el.Value.(*cacheEntry).prefetching
+}
+
+// TestCacheTTL: verifies answers are served with decayed TTLs until the
+// lowest one expires, and that TTLs are bounded by MinTTL and MaxTTL
+func TestCacheTTL(t *testing.T) {
+	advance := mockNow(t)
+	c := newCache(CacheOptions{Size: 10, MinTTL: 30 * time.Second, MaxTTL: time.Hour})
+
+	req := question("example.com", dns.TypeA)
+	c.put(req, answer(req, 60, 300))
+	advance(20 * time.Second)
+	resp, _ := c.get(question("EXAMPLE.com", dns.TypeA))
+	if resp == nil || ttls(resp)[0] != 40 || ttls(resp)[1] != 280 || resp.Question[0].Name != "EXAMPLE.com." {
+		t.Fatalf("expected decayed TTLs [40 280], got %v", resp)
+	}
+	if resp, _ := c.get(question("example.com", dns.TypeAAAA)); resp != nil {
+		t.Fatalf("another type must not be answered from the cache, got %v", resp)
+	}
+	advance(40 * time.Second)
+	if resp, _ := c.get(req); resp != nil {
+		t.Fatalf("expected the entry to expire with its lowest TTL, got %v", resp)
+	}
+
+	short, long := question("short.example.com", dns.TypeA), question("long.example.com", dns.TypeA)
+	c.put(short, answer(short, 5))
+	c.put(long, answer(long, 7200))
+	if resp, _ := c.get(short); resp == nil || ttls(resp)[0] != 30 {
+		t.Fatalf("expected the TTL raised to MinTTL, got %v", resp)
+	}
+	if resp, _ := c.get(long); resp == nil || ttls(resp)[0] != 3600 {
+		t.Fatalf("expected the TTL lowered to MaxTTL, got %v", resp)
+	}
+
+	if s := c.stats(); s.Hits != 3 || s.Misses != 2 || s.Entries != 2 {
+		t.Fatalf("unexpected counters %+v", s)
+	}
+}
+
+// TestCacheNegative: verifies NXDOMAIN is cached for the SOA's negative
+// TTL bounded by MaxNegativeTTL, and not at all without a SOA
+func TestCacheNegative(t *testing.T) {
+	mockNow(t)
+	c := newCache(CacheOptions{Size: 10, MaxNegativeTTL: 60 * time.Second})
+
+	soa, capped, bare := question("a.example.com", dns.TypeA), question("b.example.com", dns.TypeA), question("c.example.com", dns.TypeA)
+	c.put(soa, negative(soa, 900, 45))
+	c.put(capped, negative(capped, 900, 300))
+	c.put(bare, negative(bare, 0, 0))
+
+	if resp, _ := c.get(soa); resp == nil || resp.Rcode != dns.RcodeNameError || ttls(resp)[0] != 45 {
+		t.Fatalf("expected NXDOMAIN for the SOA minimum, got %v", resp)
+	}
+	if resp, _ := c.get(capped); resp == nil || ttls(resp)[0] != 60 {
+		t.Fatalf("expected the negative TTL lowered to MaxNegativeTTL, got %v", resp)
+	}
+	if resp, _ := c.get(bare); resp != nil {
+		t.Fatalf("NXDOMAIN without SOA must not be cached, got %v", resp)
+	}
+
+	servfail := new(dns.Msg)
+	servfail.SetRcode(bare, dns.RcodeServerFailure)
+	c.put(bare, servfail)
+	if resp, _ := c.get(bare); resp != nil {
+		t.Fatalf("SERVFAIL must not be cached, got %v", resp)
+	}
+}
+
+// TestCacheLRU: verifies the least recently used entry is evicted when
+// the cache is full
+func TestCacheLRU(t *testing.T) {
+	mockNow(t)
+	c := newCache(CacheOptions{Size: 2})
+
+	a, b, d := question("a.test", dns.TypeA), question("b.test", dns.TypeA), question("d.test", dns.TypeA)
+	c.put(a, answer(a, 60))
+	c.put(b, answer(b, 60))
+	c.get(a)
+	c.put(d, answer(d, 60))
+
+	if resp, _ := c.get(b); resp != nil {
+		t.Fatalf("expected b to be evicted, got %v", resp)
+	}
+	for _, req := range []*dns.Msg{a, d} {
+		if resp, _ := c.get(req); resp == nil {
+			t.Fatalf("expected %s to be kept", req.Question[0].Name)
+		}
+	}
+	if s := c.stats(); s.Evictions != 1 || s.Entries != 2 {
+		t.Fatalf("unexpected counters %+v", s)
+	}
+}
+
+// TestProxyCachePrefetch: verifies repeated queries are answered from the
+// cache and a hot entry is refreshed before it expires
+func TestProxyCachePrefetch(t *testing.T) {
+	advance := mockNow(t)
+	up := startStub(t, "192.0.2.1")
+	p, _ := startProxy(t, Options{Cache: CacheOptions{Size: 10, Prefetch: true}}, up)
+
+	ask(t, p, "udp")
+	ask(t, p, "udp")
+	if up.queries.Load() != 1 {
+		t.Fatalf("expected the second query from the cache, upstream asked %d times", up.queries.Load())
+	}
+
+	// in the last tenth of the 60s TTL
+	advance(55 * time.Second)
+	if r := ask(t, p, "udp"); r.Answer[0].Header().Ttl != 5 {
+		t.Fatalf("expected the cached answer with 5s left, got %v", r)
+	}
+	for i := 0; i < 100 && !refreshed(p.cache, question("example.com", dns.TypeA)); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if up.queries.Load() != 2 {
+		t.Fatalf("expected a prefetch, upstream asked %d times", up.queries.Load())
+	}
+
+	advance(30 * time.Second)
+	if r := ask(t, p, "udp"); r.Answer[0].Header().Ttl != 30 || up.queries.Load() != 2 {
+		t.Fatalf("expected the prefetched answer, got %v after %d queries", r, up.queries.Load())
+	}
+	if s, ok := p.CacheStats(); !ok || s.Hits != 3 || s.Misses != 1 || s.Prefetches != 1 {
+		t.Fatalf("unexpected counters %+v", s)
+	}
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/miekg/dns"
+)
+
+// stub is a local DNS server, over UDP and TCP, answering A queries with
+// its address after delay, with rcode when set, or never while down
+type stub struct {
+	addr    string
+	ip      string
+	delay   atomic.Int64
+	rcode   atomic.Int32
+	down    atomic.Bool
+	queries atomic.Int32
+}
+
+func startStub(t *testing.T, ip string) *stub {
+	s := &stub{ip: ip}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		s.queries.Add(1)
+		if s.down.Load() {
+			return
+		}
+		time.Sleep(time.Duration(s.delay.Load()))
+		resp := new(dns.Msg)
+		if rcode := int(s.rcode.Load()); rcode != dns.RcodeSuccess {
+			resp.SetRcode(q, rcode)
+			w.WriteMsg(resp)
+			return
+		}
+		resp.SetReply(q)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(s.ip),
+		})
+		w.WriteMsg(resp)
+	})
+	for _, srv := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: l, Handler: handler}} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go srv.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { srv.Shutdown() })
+	}
+
+	s.addr = pc.LocalAddr().String()
+	return s
+}
+
+// startProxy starts a proxy on a free port forwarding to stubs; the func
+// returns the events logged so far
+func startProxy(t *testing.T, opts Options, stubs ...*stub) (*Proxy, func() []Event) {
+	var events []Event
+	var mu sync.Mutex
+	opts.Profile = config.Profile{Name: "stubs"}
+	for _, s := range stubs {
+		opts.Profile.Servers = append(opts.Profile.Servers, s.addr)
+	}
+	opts.Listen = "127.0.0.1:0"
+	opts.Log = func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}
+
+	p, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Shutdown() })
+	return p, func() []Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Event{}, events...)
+	}
+}
+
+// mockNow replaces now with a clock advanced by the returned func
+func mockNow(t *testing.T) (advance func(time.Duration)) {
+	var mu sync.Mutex
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	t.Cleanup(func() { now = time.Now })
+	return func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(d)
+	}
+}
+
+// ask queries the proxy for example.com over net and returns the answer
+func ask(t *testing.T, p *Proxy, network string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	c := &dns.Client{Net: network, Timeout: 2 * time.Second}
+	r, _, err := c.Exchange(m, p.Addr())
+	if err != nil {
+		t.Fatalf("query over %s failed: %v", network, err)
+	}
+	if r.Id != m.Id {
+		t.Fatalf("response id %d does not match query id %d", r.Id, m.Id)
+	}
+	return r
+}
+
+// answerIP returns the address in the first A record of r
+func answerIP(r *dns.Msg) string {
+	if len(r.Answer) == 0 {
+		return ""
+	}
+	if a, ok := r.Answer[0].(*dns.A); ok {
+		return a.A.String()
+	}
+	return ""
+}
+
+// TestProxyForwards: verifies queries over UDP and TCP are answered by the upstream
+func TestProxyForwards(t *testing.T) {
+	up := startStub(t, "192.0.2.1")
+	p, _ := startProxy(t, Options{}, up)
+
+	for _, network := range []string{"udp", "tcp"} {
+		if r := ask(t, p, network); answerIP(r) != "192.0.2.1" {
+			t.Fatalf("unexpected answer over %s: %v", network, r)
+		}
+	}
+	if p.Profile() != "stubs" {
+		t.Fatalf("unexpected profile '%s'", p.Profile())
+	}
+}
+
+// TestProxyFailover: verifies a query is answered by the next upstream when
+// the first one times out or fails, and that an upstream failing MaxFails
+// times is asked last until Cooldown has passed
+func TestProxyFailover(t *testing.T) {
+	advance := mockNow(t)
+	dead, servfail, good := startStub(t, "192.0.2.1"), startStub(t, "192.0.2.2"), startStub(t, "192.0.2.3")
+	dead.down.Store(true)
+	servfail.rcode.Store(dns.RcodeServerFailure)
+	p, events := startProxy(t, Options{Race: 1, Timeout: 200 * time.Millisecond, MaxFails: 1, Cooldown: time.Minute}, dead, servfail, good)
+
+	if r := ask(t, p, "udp"); answerIP(r) != "192.0.2.3" {
+		t.Fatalf("expected the answer of the third upstream, got %v", r)
+	}
+	if e := events(); len(e) != 2 || e[0].Up || !strings.Contains(e[1].Error, "SERVFAIL") {
+		t.Fatalf("expected two upstreams down, got %+v", e)
+	}
+
+	// the failed upstreams are only asked again after the cooldown
+	start := time.Now()
+	if r := ask(t, p, "udp"); answerIP(r) != "192.0.2.3" || time.Since(start) > 150*time.Millisecond {
+		t.Fatalf("expected a fast answer of the third upstream, got %v after %v", r, time.Since(start))
+	}
+	if dead.queries.Load() != 1 || servfail.queries.Load() != 1 {
+		t.Fatalf("down upstreams asked again: %d, %d", dead.queries.Load(), servfail.queries.Load())
+	}
+
+	advance(time.Minute)
+	dead.down.Store(false)
+	if r := ask(t, p, "udp"); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("unexpected answer %v", r)
+	}
+	if e := events(); dead.queries.Load() != 2 || len(e) != 3 || !e[2].Up {
+		t.Fatalf("expected the first upstream to be asked and back up, got %d queries, %+v", dead.queries.Load(), e)
+	}
+}
+
+// TestProxyRace: verifies a slow upstream raced with a fast one does not
+// delay the answer, and that no answer at all gives SERVFAIL
+func TestProxyRace(t *testing.T) {
+	slow, fast := startStub(t, "192.0.2.1"), startStub(t, "192.0.2.2")
+	slow.delay.Store(int64(500 * time.Millisecond))
+	p, _ := startProxy(t, Options{Race: 2, Timeout: time.Second}, slow, fast)
+
+	start := time.Now()
+	if r := ask(t, p, "udp"); answerIP(r) != "192.0.2.2" || time.Since(start) > 300*time.Millisecond {
+		t.Fatalf("expected the fast answer, got %v after %v", r, time.Since(start))
+	}
+
+	slow.rcode.Store(dns.RcodeRefused)
+	fast.rcode.Store(dns.RcodeServerFailure)
+	slow.delay.Store(0)
+	if r := ask(t, p, "tcp"); r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %v", r)
+	}
+}
+
+// TestState: verifies the published state is read back while the proxy
+// keeps updating it, and ignored once it stopped
+func TestState(t *testing.T) {
+	advance := mockNow(t)
+	StatePath = filepath.Join(t.TempDir(), "proxy.json")
+	t.Cleanup(func() { StatePath = "" })
+
+	p, _ := startProxy(t, Options{Cache: CacheOptions{Size: 10}}, startStub(t, "192.0.2.1"))
+	ask(t, p, "udp")
+	ask(t, p, "udp")
+	if err := WriteState(p.State(now())); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadState()
+	if err != nil || s == nil {
+		t.Fatalf("expected the state, got %v, %v", s, err)
+	}
+	if s.Profile != "stubs" || s.Listen != p.Addr() || s.Cache == nil || s.Cache.Hits != 1 || s.Cache.Misses != 1 || len(s.Upstreams) != 1 {
+		t.Fatalf("unexpected state %+v", s)
+	}
+
+	advance(3 * StateInterval)
+	if s, err := LoadState(); err != nil || s != nil {
+		t.Fatalf("expected a stale state to be ignored, got %+v, %v", s, err)
+	}
+	if err := RemoveState(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(StatePath); !os.IsNotExist(err) {
+		t.Fatalf("state not removed: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StatePath is the JSON file a running proxy publishes its State in, for
+// the status command of other processes. It is set by the command layer;
+// nothing is written while it is empty.
+var StatePath string
+
+// StateInterval is how often a serving proxy rewrites its State. A State
+// not updated for three intervals belongs to a proxy that is gone.
+const StateInterval = 5 * time.Second
+
+// State describes a running proxy
+type State struct {
+	PID       int              `json:"pid"`
+	Listen    string           `json:"listen"`
+	Profile   string           `json:"profile"`
+	StartedAt time.Time        `json:"started_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Cache     *CacheStats      `json:"cache,omitempty"` // nil when the cache is disabled
+	Upstreams []UpstreamStatus `json:"upstreams"`
+}
+
+// Running reports whether the proxy was still updating its state at t
+func (s State) Running(t time.Time) bool {
+	return t.Sub(s.UpdatedAt) < 3*StateInterval
+}
+
+// State returns the current state of p, started at started
+func (p *Proxy) State(started time.Time) State {
+	s := State{
+		PID:       os.Getpid(),
+		Listen:    p.Addr(),
+		Profile:   p.Profile(),
+		StartedAt: started,
+		UpdatedAt: now(),
+		Upstreams: p.Upstreams(),
+	}
+	if stats, ok := p.CacheStats(); ok {
+		s.Cache = &stats
+	}
+	return s
+}
+
+// WriteState replaces the state on disk
+func WriteState(s State) error {
+	if StatePath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(StatePath), 0755); err != nil {
+		return fmt.Errorf("cannot create proxy state directory: %v", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(StatePath, data, 0644); err != nil {
+		return fmt.Errorf("cannot write proxy state: %v", err)
+	}
+	return nil
+}
+
+// LoadState reads the state of the proxy, nil when none was written or the
+// proxy is no longer running
+func LoadState() (*State, error) {
+	if StatePath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(StatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot read proxy state: %v", err)
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("cannot parse proxy state %s: %v", StatePath, err)
+	}
+	if !s.Running(now()) {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// RemoveState deletes the state on disk, when the proxy stops
+func RemoveState() error {
+	if StatePath == "" {
+		return nil
+	}
+	if err := os.Remove(StatePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove proxy state: %v", err)
+	}
+	return nil
+}
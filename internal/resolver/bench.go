@@ -0,0 +1,212 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// BenchOptions configure a benchmark run
+type BenchOptions struct {
+	// Queries are asked in turn by every target, once per repetition.
+	// QName is a shortcut for a single A query, used when Queries is empty.
+	Queries []Query
+	QName   string
+	// ProfileQueries replaces Queries for the targets of the named profiles
+	ProfileQueries map[string][]Query
+
+	Repeat      int           // rounds of queries per target
+	Timeout     time.Duration // per probe
+	Concurrency int           // probes in flight at once
+	DoHMethod   string        // "GET" or "POST" for DoH targets
+	// CacheBustZone, when set, adds opts.Repeat probes per target for random
+	// labels under this zone (see CacheBustQuery), recorded as Uncached
+	CacheBustZone string
+}
+
+// withDefaults fills unset options with the values the commands always used
+func (o BenchOptions) withDefaults() BenchOptions {
+	if o.Repeat <= 0 {
+		o.Repeat = 1
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+	if o.DoHMethod == "" {
+		o.DoHMethod = "GET"
+	}
+	if len(o.Queries) == 0 {
+		o.Queries = []Query{{Name: o.QName}}
+	}
+	return o
+}
+
+// queriesFor returns the queries asked of t
+func (o BenchOptions) queriesFor(t Target) []Query {
+	if q, ok := o.ProfileQueries[t.Profile]; ok && len(q) > 0 {
+		return q
+	}
+	return o.Queries
+}
+
+// ServerResult holds the samples of one target, in probe order: every
+// query of the run in turn, once per repetition. These names are usually
+// answered from the resolver's cache; Uncached holds the cache-busting
+// samples, if any.
+type ServerResult struct {
+	Target   Target
+	Samples  []Result
+	Uncached []Result
+}
+
+// Average returns the mean RTT of the successful samples.
+// ok is false when no sample succeeded.
+func (s ServerResult) Average() (avg time.Duration, ok bool) {
+	var sum time.Duration
+	var n int
+	for _, r := range s.Samples {
+		if r.Error == nil {
+			sum += r.RTT
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / time.Duration(n), true
+}
+
+// ProfileResult holds the results of every target of a profile
+type ProfileResult struct {
+	Profile config.Profile
+	Servers []ServerResult
+}
+
+// Average returns the mean of the server averages, ignoring servers that
+// never answered. ok is false when no server answered.
+func (p ProfileResult) Average() (avg time.Duration, ok bool) {
+	var sum time.Duration
+	var n int
+	for _, s := range p.Servers {
+		if a, ok := s.Average(); ok {
+			sum += a
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / time.Duration(n), true
+}
+
+// probe is the function used by Benchmark, replaceable in tests
+var probe = Probe
+
+// Benchmark probes every target opts.Repeat times using a pool of
+// opts.Concurrency workers. Results keep the order of targets and samples
+// the order of repetitions.
+//
+// ctx bounds the whole run: a probe never waits longer than the time left
+// until its deadline, and probes not started when ctx is done are recorded
+// with ctx.Err() as error.
+func Benchmark(ctx context.Context, targets []Target, opts BenchOptions) []ServerResult {
+	opts = opts.withDefaults()
+
+	queries := make([][]Query, len(targets))
+	rounds := 0
+	results := make([]ServerResult, len(targets))
+	for i, t := range targets {
+		queries[i] = opts.queriesFor(t)
+		rounds = max(rounds, len(queries[i]))
+		results[i] = ServerResult{Target: t, Samples: make([]Result, opts.Repeat*len(queries[i]))}
+		if opts.CacheBustZone != "" {
+			results[i].Uncached = make([]Result, opts.Repeat)
+		}
+	}
+
+	type job struct {
+		target, sample int
+		query          Query
+		uncached       bool
+	}
+	jobs := make(chan job)
+
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				t := targets[j.target]
+				slot := &results[j.target].Samples[j.sample]
+				if j.uncached {
+					slot = &results[j.target].Uncached[j.sample]
+				}
+
+				if err := ctx.Err(); err != nil {
+					*slot = Result{Server: t.String(), Query: j.query.String(), Error: err}
+					continue
+				}
+
+				timeout := opts.Timeout
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+					timeout = time.Until(deadline)
+					if timeout <= 0 {
+						*slot = Result{Server: t.String(), Query: j.query.String(), Error: context.DeadlineExceeded}
+						continue
+					}
+				}
+				*slot = probe(t, j.query, timeout, opts.DoHMethod)
+				slot.Query = j.query.String()
+			}
+		}()
+	}
+
+	// interleave repetitions and queries so a slow target does not hold one
+	// worker for all its samples
+	for s := 0; s < opts.Repeat; s++ {
+		for k := 0; k < rounds; k++ {
+			for i := range targets {
+				if k < len(queries[i]) {
+					jobs <- job{target: i, sample: s*len(queries[i]) + k, query: queries[i][k]}
+				}
+			}
+		}
+		if opts.CacheBustZone != "" {
+			for i := range targets {
+				jobs <- job{target: i, sample: s, query: CacheBustQuery(opts.CacheBustZone), uncached: true}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// BenchmarkProfiles benchmarks the targets of all profiles in one pool and
+// groups the results per profile, in the order of profiles.
+func BenchmarkProfiles(ctx context.Context, profiles []config.Profile, opts BenchOptions) []ProfileResult {
+	var targets []Target
+	var counts []int
+	for _, p := range profiles {
+		t := TargetsOf(p)
+		targets = append(targets, t...)
+		counts = append(counts, len(t))
+	}
+
+	servers := Benchmark(ctx, targets, opts)
+
+	out := make([]ProfileResult, len(profiles))
+	offset := 0
+	for i, p := range profiles {
+		out[i] = ProfileResult{Profile: p, Servers: servers[offset : offset+counts[i]]}
+		offset += counts[i]
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Domains of the DNSSEC probe: a correctly signed one and one whose
+// signatures are deliberately broken
+var (
+	DNSSECSignedDomain = "sigok.verteiltesysteme.net"
+	DNSSECBrokenDomain = "sigfail.verteiltesysteme.net"
+)
+
+// DNSSECStatus tells whether a server validates DNSSEC
+type DNSSECStatus string
+
+const (
+	// DNSSECValidating: AD flag on the signed domain, SERVFAIL on the broken one
+	DNSSECValidating DNSSECStatus = "validating"
+	// DNSSECNotValidating: the server answers without validating
+	DNSSECNotValidating DNSSECStatus = "not-validating"
+	// DNSSECUnknown: a probe failed, nothing can be said
+	DNSSECUnknown DNSSECStatus = "unknown"
+)
+
+// DNSSECResult is the outcome of CheckDNSSEC for one server. Signed and
+// Broken are the measurements of the two queries.
+type DNSSECResult struct {
+	Server string       `json:"server"`
+	Status DNSSECStatus `json:"status"`
+	// AD is the Authenticated Data flag of the answer for the signed domain
+	AD bool `json:"ad"`
+	// Signatures tells whether RRSIG records came back (the server is DNSSEC aware)
+	Signatures bool   `json:"signatures"`
+	Signed     Result `json:"signed"`
+	Broken     Result `json:"broken"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// CheckDNSSEC sends queries with the DO bit for DNSSECSignedDomain and
+// DNSSECBrokenDomain to t and reports whether it validates: a validating
+// resolver sets the AD flag on the signed answer and answers SERVFAIL for
+// the broken signatures.
+func CheckDNSSEC(t Target, timeout time.Duration, dohMethod string) DNSSECResult {
+	res := DNSSECResult{Server: t.String(), Status: DNSSECUnknown}
+
+	signedQ := Query{Name: DNSSECSignedDomain, DNSSEC: true}
+	signed, sr := Exchange(t, signedQ, timeout, dohMethod)
+	sr.Query = signedQ.String()
+	res.Signed = sr
+	if signed == nil {
+		res.Detail = "signed domain: " + errString(sr.Error)
+		return res
+	}
+	res.AD = signed.AuthenticatedData
+	for _, rr := range signed.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			res.Signatures = true
+		}
+	}
+
+	brokenQ := Query{Name: DNSSECBrokenDomain, DNSSEC: true}
+	broken, br := Exchange(t, brokenQ, timeout, dohMethod)
+	br.Query = brokenQ.String()
+	res.Broken = br
+	if broken == nil {
+		res.Detail = "broken domain: " + errString(br.Error)
+		return res
+	}
+
+	switch {
+	case signed.Rcode != dns.RcodeSuccess:
+		res.Detail = fmt.Sprintf("signed domain: rcode %s", dns.RcodeToString[signed.Rcode])
+	case !res.AD:
+		res.Status = DNSSECNotValidating
+		res.Detail = "no AD flag on the signed domain"
+	case broken.Rcode != dns.RcodeServerFailure:
+		res.Status = DNSSECNotValidating
+		res.Detail = fmt.Sprintf("broken signatures accepted (rcode %s)", dns.RcodeToString[broken.Rcode])
+	default:
+		res.Status = DNSSECValidating
+	}
+	return res
+}
+
+// CheckDNSSECAll runs CheckDNSSEC for every target, concurrency at a time,
+// and returns the results in the order of targets. Targets not started
+// before ctx is done are reported as DNSSECUnknown.
+func CheckDNSSECAll(ctx context.Context, targets []Target, timeout time.Duration, dohMethod string, concurrency int) []DNSSECResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	out := make([]DNSSECResult, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		if ctx.Err() != nil {
+			out[i] = DNSSECResult{Server: t.String(), Status: DNSSECUnknown, Detail: ctx.Err().Error()}
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			out[i] = CheckDNSSEC(t, timeout, dohMethod)
+		}()
+	}
+	wg.Wait()
+	return out
+}
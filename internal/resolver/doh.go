@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DoHClient is the HTTP client used for DNS-over-HTTPS queries.
+// Tests replace it with the client of an httptest TLS server.
+var DoHClient = &http.Client{}
+
+const dnsMessageType = "application/dns-message"
+
+// MeasureDoH sends an RFC 8484 DNS-over-HTTPS query (wire format) for the A
+// record of qname to url and measures the round-trip time.
+// method is "GET" (dns= query parameter) or "POST" (message in the body).
+// The returned Result has the same meaning as the one of MeasureRTT.
+func MeasureDoH(url string, qname string, timeout time.Duration, method string) Result {
+	q := Query{Name: qname}
+	_, res := checked(q)(exchangeDoH(url, q.msg(), timeout, method))
+	return res
+}
+
+// exchangeDoH sends m over DoH and returns the response, if one could be
+// parsed, together with its measurement
+func exchangeDoH(url string, m *dns.Msg, timeout time.Duration, method string) (*dns.Msg, Result) {
+	method = strings.ToUpper(method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	res := Result{Server: url, Protocol: "doh-" + strings.ToLower(method)}
+
+	// RFC 8484 4.1: use ID 0 so responses are cache friendly
+	m = m.Copy()
+	m.Id = 0
+	wire, err := m.Pack()
+	if err != nil {
+		res.Error = err
+		return nil, res
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var req *http.Request
+	switch method {
+	case http.MethodGet:
+		q := base64.RawURLEncoding.EncodeToString(wire)
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url+sep+"dns="+q, nil)
+	case http.MethodPost:
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(wire))
+		if err == nil {
+			req.Header.Set("Content-Type", dnsMessageType)
+		}
+	default:
+		err = fmt.Errorf("unsupported DoH method %q", method)
+	}
+	if err != nil {
+		res.Error = err
+		return nil, res
+	}
+	req.Header.Set("Accept", dnsMessageType)
+
+	start := time.Now()
+	resp, err := DoHClient.Do(req)
+	if err != nil {
+		res.RTT = time.Since(start)
+		res.Error = err
+		return nil, res
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	res.RTT = time.Since(start)
+	if err != nil {
+		res.Error = err
+		return nil, res
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		res.Error = fmt.Errorf("http status %d", resp.StatusCode)
+		return nil, res
+	}
+
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		res.Error = fmt.Errorf("invalid DNS response: %v", err)
+		return nil, res
+	}
+	return r, res
+}
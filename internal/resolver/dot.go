@@ -0,0 +1,119 @@
+package resolver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/miekg/dns"
+)
+
+// DoTRootCAs are the roots DoT certificates are verified against.
+// nil means the system roots; tests install the pool of a self-signed cert.
+var DoTRootCAs *x509.CertPool
+
+// MeasureDoT opens a DNS-over-TLS connection to d and sends an A-record query
+// for qname over it. The TLS handshake (including TCP connect) and the query
+// are timed separately: Result.Handshake and Result.RTT.
+//
+// The certificate is verified against d.AuthName (or d.Host when empty).
+// With an SPKI pin, one certificate of the verified chain must match it; a pin
+// without an authentication name is accepted on its own when it matches the
+// server's own certificate (RFC 7858 out-of-band key pinning).
+func MeasureDoT(d config.DoTServer, qname string, timeout time.Duration) Result {
+	q := Query{Name: qname}
+	_, res := checked(q)(exchangeDoT(d, q.msg(), timeout))
+	return res
+}
+
+// exchangeDoT sends m over DoT and returns the response, if one could be
+// parsed, together with its measurement
+func exchangeDoT(d config.DoTServer, m *dns.Msg, timeout time.Duration) (*dns.Msg, Result) {
+	res := Result{Server: d.String(), Protocol: "dot"}
+	if err := d.Validate(); err != nil {
+		res.Error = err
+		return nil, res
+	}
+
+	tlsConfig := dotTLSConfig(d)
+	dialer := &net.Dialer{Timeout: timeout}
+
+	start := time.Now()
+	conn, err := tls.DialWithDialer(dialer, "tcp", d.Address(), tlsConfig)
+	res.Handshake = time.Since(start)
+	if err != nil {
+		res.Error = fmt.Errorf("tls handshake: %v", err)
+		return nil, res
+	}
+	defer conn.Close()
+
+	co := &dns.Conn{Conn: conn}
+	co.SetDeadline(time.Now().Add(timeout))
+
+	start = time.Now()
+	if err := co.WriteMsg(m); err != nil {
+		res.RTT = time.Since(start)
+		res.Error = err
+		return nil, res
+	}
+	r, err := co.ReadMsg()
+	res.RTT = time.Since(start)
+
+	if err != nil {
+		res.Error = err
+		return nil, res
+	}
+	return r, res
+}
+
+// dotTLSConfig builds the TLS configuration for a DoT server
+func dotTLSConfig(d config.DoTServer) *tls.Config {
+	cfg := &tls.Config{
+		ServerName: d.AuthName,
+		RootCAs:    DoTRootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = d.Host
+	}
+
+	if d.SPKIPin == "" {
+		return cfg
+	}
+
+	if d.AuthName == "" {
+		// the pin alone authenticates the server, the name is not checked
+		cfg.InsecureSkipVerify = true
+	}
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if d.AuthName == "" {
+			// the handshake only proves the key of the leaf, the other
+			// certificates sent by the server are unverified
+			if len(cs.PeerCertificates) > 0 && SPKIPin(cs.PeerCertificates[0]) == d.SPKIPin {
+				return nil
+			}
+			return fmt.Errorf("server certificate does not match SPKI pin %s", d.SPKIPin)
+		}
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				if SPKIPin(cert) == d.SPKIPin {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("no certificate of the verified chain matches SPKI pin %s", d.SPKIPin)
+	}
+	return cfg
+}
+
+// SPKIPin returns the base64 SHA-256 hash of the certificate's
+// SubjectPublicKeyInfo, the format of config.DoTServer.SPKIPin
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
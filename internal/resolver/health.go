@@ -0,0 +1,108 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SystemPath is the Path of the checks made through the operating system's
+// resolver, which uses whatever servers the interfaces are configured with
+const SystemPath = "system"
+
+// HealthCheck is one query of a health check: through a server, or through
+// the system resolver when Path is SystemPath
+type HealthCheck struct {
+	Path   string `json:"path"`
+	Ok     bool   `json:"ok"`
+	Result Result `json:"result"`
+}
+
+// HealthReport is the outcome of CheckHealth. It passed when every query
+// was answered by at least one server and resolved through the system.
+type HealthReport struct {
+	Passed bool          `json:"passed"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// Failed returns the checks that did not pass
+func (r HealthReport) Failed() []HealthCheck {
+	var failed []HealthCheck
+	for _, c := range r.Checks {
+		if !c.Ok {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// HealthOptions configure CheckHealth
+type HealthOptions struct {
+	Queries     []Query
+	Timeout     time.Duration // per query
+	Concurrency int
+	DoHMethod   string
+	// System also resolves the names of the A and AAAA queries through
+	// SystemLookup
+	System bool
+}
+
+// SystemLookup resolves host with the operating system's resolver
+var SystemLookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return net.DefaultResolver.LookupIPAddr(ctx, host)
+}
+
+// CheckHealth asks every query once through every target and, with
+// opts.System, through SystemLookup, to tell whether resolution works.
+// A query that one target cannot answer does not fail the check as long
+// as another one does.
+func CheckHealth(ctx context.Context, targets []Target, opts HealthOptions) HealthReport {
+	bench := BenchOptions{Queries: opts.Queries, Timeout: opts.Timeout, Concurrency: opts.Concurrency, DoHMethod: opts.DoHMethod}.withDefaults()
+	report := HealthReport{Passed: true, Checks: []HealthCheck{}}
+
+	if len(targets) > 0 {
+		answered := make([]bool, len(bench.Queries))
+		for _, sr := range Benchmark(ctx, targets, bench) {
+			for k, r := range sr.Samples {
+				report.Checks = append(report.Checks, HealthCheck{Path: sr.Target.String(), Ok: r.Error == nil, Result: r})
+				answered[k] = answered[k] || r.Error == nil
+			}
+		}
+		for _, ok := range answered {
+			report.Passed = report.Passed && ok
+		}
+	}
+
+	if opts.System {
+		for _, q := range bench.Queries {
+			if q.AllowNXDomain || (q.Type != 0 && q.Type != dns.TypeA && q.Type != dns.TypeAAAA) {
+				continue
+			}
+			r := lookupSystem(ctx, q, bench.Timeout)
+			report.Checks = append(report.Checks, HealthCheck{Path: SystemPath, Ok: r.Error == nil, Result: r})
+			report.Passed = report.Passed && r.Error == nil
+		}
+	}
+	return report
+}
+
+// lookupSystem resolves the name of q through SystemLookup
+func lookupSystem(ctx context.Context, q Query, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := SystemLookup(ctx, dns.Fqdn(q.Name))
+	r := Result{Server: SystemPath, Query: q.String(), Protocol: SystemPath, RTT: time.Since(start), Error: err}
+	if err == nil && len(addrs) == 0 {
+		r.Error = errors.New("no addresses")
+	}
+	if r.Error != nil {
+		r.Error = fmt.Errorf("system resolver: %v", r.Error)
+	}
+	return r
+}
@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/miekg/dns"
+)
+
+// Target is one upstream that can be probed: a plain server ("8.8.8.8",
+// "127.0.0.1:5353"), a DNS-over-HTTPS URL, or a DNS-over-TLS server.
+type Target struct {
+	Profile string
+	Server  string
+	DoT     *config.DoTServer
+}
+
+// String returns the name of the upstream as shown in output
+func (t Target) String() string {
+	if t.DoT != nil {
+		return t.DoT.String()
+	}
+	return t.Server
+}
+
+// IsDoH reports whether the target is a DNS-over-HTTPS URL
+func (t Target) IsDoH() bool {
+	return t.DoT == nil && strings.HasPrefix(t.Server, "https://")
+}
+
+// TargetsOf returns every upstream of a profile in the order of Profile.Upstreams
+func TargetsOf(p config.Profile) []Target {
+	var targets []Target
+	for _, s := range p.AllServers() {
+		targets = append(targets, Target{Profile: p.Name, Server: s})
+	}
+	for _, u := range p.DoH {
+		targets = append(targets, Target{Profile: p.Name, Server: u})
+	}
+	for i := range p.DoT {
+		d := p.DoT[i]
+		targets = append(targets, Target{Profile: p.Name, DoT: &d})
+	}
+	return targets
+}
+
+// ParseTarget turns a single server given on the command line into a Target.
+// tls:// servers are parsed with config.ParseDoT.
+func ParseTarget(s string) (Target, error) {
+	if strings.HasPrefix(s, "tls://") {
+		d, err := config.ParseDoT(s)
+		if err != nil {
+			return Target{}, err
+		}
+		return Target{DoT: &d}, nil
+	}
+	return Target{Server: s}, nil
+}
+
+// Probe sends q to the target with the matching protocol and measures it,
+// so every kind of upstream shows up in the same Result form.
+// dohMethod is "GET" or "POST" and only matters for DoH targets.
+func Probe(t Target, q Query, timeout time.Duration, dohMethod string) Result {
+	_, res := Exchange(t, q, timeout, dohMethod)
+	return res
+}
+
+// Exchange is Probe that also returns the response. The message is nil when
+// no response could be read; it is set even when Result.Error reports an
+// unexpected rcode or an empty answer.
+func Exchange(t Target, q Query, timeout time.Duration, dohMethod string) (*dns.Msg, Result) {
+	return checked(q)(Forward(t, q.msg(), timeout, dohMethod, false))
+}
+
+// Forward sends m as it is to the target with the matching protocol and
+// returns the response without judging its rcode or answers, for relaying
+// it to a client. overTCP selects TCP for plain DNS servers, e.g. for a
+// client that retries over TCP after a truncated response.
+func Forward(t Target, m *dns.Msg, timeout time.Duration, dohMethod string, overTCP bool) (*dns.Msg, Result) {
+	switch {
+	case t.DoT != nil:
+		return exchangeDoT(*t.DoT, m, timeout)
+	case t.IsDoH():
+		return exchangeDoH(t.Server, m, timeout, dohMethod)
+	default:
+		return exchangeDo53(t.Server, m, timeout, overTCP)
+	}
+}
+
+// checked returns a func setting the error of a response that is not a
+// valid answer to q, for wrapping an exchange
+func checked(q Query) func(*dns.Msg, Result) (*dns.Msg, Result) {
+	return func(r *dns.Msg, res Result) (*dns.Msg, Result) {
+		if r != nil && res.Error == nil {
+			res.Error = q.check(r)
+		}
+		return r, res
+	}
+}
@@ -0,0 +1,94 @@
+package resolver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/miekg/dns"
+)
+
+// Query is the question a probe asks
+type Query struct {
+	Name string
+	Type uint16 // dns.TypeA when zero
+	// AllowNXDomain counts NXDOMAIN and empty answers as success, for names
+	// that are not expected to exist (see CacheBustQuery)
+	AllowNXDomain bool
+	// DNSSEC sets the DO bit (EDNS0) and asks for the AD flag (RFC 6840 5.7)
+	DNSSEC bool
+}
+
+// String returns the "name TYPE" form shown in output
+func (q Query) String() string {
+	qtype := q.Type
+	if qtype == 0 {
+		qtype = dns.TypeA
+	}
+	return strings.TrimSuffix(q.Name, ".") + " " + dns.TypeToString[qtype]
+}
+
+// msg builds the DNS message for the query
+func (q Query) msg() *dns.Msg {
+	qtype := q.Type
+	if qtype == 0 {
+		qtype = dns.TypeA
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(q.Name), qtype)
+	if q.DNSSEC {
+		m.SetEdns0(4096, true)
+		m.AuthenticatedData = true
+	}
+	return m
+}
+
+// check returns an error when r is not a valid answer to the query
+func (q Query) check(r *dns.Msg) error {
+	if q.AllowNXDomain && (r.Rcode == dns.RcodeNameError || r.Rcode == dns.RcodeSuccess) {
+		return nil
+	}
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) == 0 {
+		return fmt.Errorf("no answer or rcode %d", r.Rcode)
+	}
+	return nil
+}
+
+// CacheBustQuery returns a query for a random label under zone
+// (e.g. "3f9c2a71d04b8e65.example.com"). No resolver has the name cached, so
+// the query measures a full recursion; NXDOMAIN is the expected answer.
+func CacheBustQuery(zone string) Query {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return Query{Name: hex.EncodeToString(b) + "." + dns.Fqdn(zone), AllowNXDomain: true}
+}
+
+// SuiteQueries converts the entries of a benchmark suite into queries
+func SuiteQueries(s config.Suite) ([]Query, error) {
+	var out []Query
+	for _, sq := range s.Queries {
+		t := sq.Type
+		if t == "" {
+			t = "A"
+		}
+		qtype, ok := dns.StringToType[strings.ToUpper(t)]
+		if !ok {
+			return nil, fmt.Errorf("suite '%s': unknown record type %q for %s", s.Name, sq.Type, sq.Name)
+		}
+		out = append(out, Query{Name: sq.Name, Type: qtype})
+	}
+	return out, nil
+}
+
+// DefaultQueries returns the queries used when no suite is selected: an A
+// query for domain when set (the DomainTesting setting), otherwise the
+// built-in config.DefaultSuite
+func DefaultQueries(domain string) []Query {
+	if domain != "" {
+		return []Query{{Name: domain}}
+	}
+	q, _ := SuiteQueries(config.DefaultSuite)
+	return q
+}
@@ -1,9 +1,9 @@
 package resolver
 
 import (
-	"fmt"
-	"log"
-	"os"
+	"context"
+	"encoding/json"
+	"net"
 	"time"
 
 	"github.com/Mreza2020/DNS-Switcher/internal/config"
@@ -11,85 +11,87 @@ import (
 )
 
 type Result struct {
-	Server string
-	RTT    time.Duration
-	Error  error
+	Server    string        `json:"server"`
+	Query     string        `json:"query,omitempty"` // "name TYPE", set by Benchmark
+	Protocol  string        `json:"protocol"`
+	Handshake time.Duration `json:"handshake_ns,omitempty"`
+	RTT       time.Duration `json:"rtt_ns"`
+	Error     error         `json:"-"`
 }
 
-var (
-	DomainTesting string
-)
-
-func init() {
-	DomainTesting = os.Getenv("DomainTesting")
-
-	if DomainTesting == "" {
-		log.Fatal("Environment variable DomainTesting is not set. Please set it before running.")
-		return
+// MarshalJSON encodes the error as its message, "error" is omitted on success
+func (r Result) MarshalJSON() ([]byte, error) {
+	type plain Result
+	out := struct {
+		plain
+		Error string `json:"error,omitempty"`
+	}{plain: plain(r)}
+	if r.Error != nil {
+		out.Error = r.Error.Error()
 	}
+	return json.Marshal(out)
 }
 
 // MeasureRTT performs a DNS query against a given DNS server and measures round-trip time (RTT).
 // It sends an A-record query for the provided qname, using the specified timeout.
 // Returns a Result struct containing:
 //   - Server: DNS server tested
+//   - Protocol: "do53" for plain DNS, "doh-get"/"doh-post" for DNS-over-HTTPS, "dot" for DNS-over-TLS
+//   - Handshake: TLS handshake duration (DNS-over-TLS only)
 //   - RTT: measured round-trip duration
 //   - Error: non-nil if exchange failed, timeout occurred, or no valid answer was returned
 func MeasureRTT(server string, qname string, timeout time.Duration) Result {
+	q := Query{Name: qname}
+	_, res := checked(q)(exchangeDo53(server, q.msg(), timeout, false))
+	return res
+}
+
+// exchangeDo53 sends m over plain DNS, UDP unless overTCP, and returns the
+// response, if any, together with its measurement
+func exchangeDo53(server string, m *dns.Msg, timeout time.Duration, overTCP bool) (*dns.Msg, Result) {
 	c := new(dns.Client)
 	c.Timeout = timeout
-
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(qname), dns.TypeA)
+	if overTCP {
+		c.Net = "tcp"
+	}
 
 	start := time.Now()
-	r, _, err := c.Exchange(m, server+":53")
+	r, _, err := c.Exchange(m, serverAddr(server))
 	rtt := time.Since(start)
 
 	if err != nil {
-		return Result{Server: server, RTT: rtt, Error: err}
+		return nil, Result{Server: server, Protocol: "do53", RTT: rtt, Error: err}
 	}
-	if r.Rcode != dns.RcodeSuccess || len(r.Answer) == 0 {
-		return Result{Server: server, RTT: rtt, Error: fmt.Errorf("no answer or rcode %d", r.Rcode)}
+	return r, Result{Server: server, Protocol: "do53", RTT: rtt, Error: nil}
+}
+
+// serverAddr turns a server into a dialable address. Bare IPv4/IPv6 literals
+// get port 53 ("2606:4700::1111" -> "[2606:4700::1111]:53"), addresses that
+// already carry a port ("127.0.0.1:5353", "[::1]:5353") are used as they are.
+func serverAddr(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
 	}
-	return Result{Server: server, RTT: rtt, Error: nil}
+	return net.JoinHostPort(server, "53")
 }
 
 // FindFastestProfile evaluates multiple DNS profiles and determines which profile
-// has the lowest average DNS RTT across its configured DNS servers.
+// scores best on metric across its configured DNS servers.
 // For each profile:
-//   - MeasureRTT is executed for every server
-//   - Only successful RTT samples are averaged
-//   - Profiles with zero successful responses are ignored
+//   - every upstream is probed once with each query of the built-in suite,
+//     all profiles concurrently (see BenchmarkProfiles)
+//   - the samples of all its upstreams are scored together (see Score), so
+//     failed queries count against the profile
+//   - Profiles that cannot be scored, e.g. with zero successful responses, are ignored
 //
 // Returns a pointer to the fastest profile, or nil if none have valid responding servers.
-func FindFastestProfile(profiles []config.Profile) *config.Profile {
-	var fastest *config.Profile
-	var bestRTT time.Duration
-
-	for i := range profiles {
-		p := profiles[i]
-		var sum time.Duration
-		var count int
-		for _, s := range p.Servers {
-			r := MeasureRTT(s, DomainTesting, 2*time.Second)
-			if r.Error == nil {
-				sum += r.RTT
-				count++
-			}
-		}
+func FindFastestProfile(profiles []config.Profile, metric RankMetric) *config.Profile {
+	opts := BenchOptions{Queries: DefaultQueries(""), Concurrency: 8}
+	results := BenchmarkProfiles(context.Background(), profiles, opts)
 
-		if count == 0 {
-			continue
-		}
-
-		avg := sum / time.Duration(count)
-
-		if fastest == nil || avg < bestRTT {
-			bestRTT = avg
-			fastest = &p
-		}
+	ranked := RankProfiles(results, metric)
+	if len(ranked) == 0 {
+		return nil
 	}
-
-	return fastest
+	return &ranked[0].Profile
 }
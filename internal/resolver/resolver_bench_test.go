@@ -0,0 +1,104 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/miekg/dns"
+)
+
+// fakeProbe replaces the probe used by Benchmark for the duration of a test
+func fakeProbe(t *testing.T, fn func(Target, Query, time.Duration, string) Result) {
+	orig := probe
+	t.Cleanup(func() { probe = orig })
+	probe = fn
+}
+
+// TestBenchmarkConcurrency: verifies the worker pool bound and result ordering
+func TestBenchmarkConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	fakeProbe(t, func(tg Target, _ Query, _ time.Duration, _ string) Result {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return Result{Server: tg.String(), RTT: time.Millisecond}
+	})
+
+	var targets []Target
+	for i := 0; i < 10; i++ {
+		targets = append(targets, Target{Server: fmt.Sprintf("10.0.0.%d", i)})
+	}
+
+	results := Benchmark(context.Background(), targets, BenchOptions{Repeat: 2, Concurrency: 3})
+
+	if maxInFlight > 3 {
+		t.Fatalf("expected at most 3 probes in flight, got %d", maxInFlight)
+	}
+	for i, r := range results {
+		if r.Target.Server != targets[i].Server || len(r.Samples) != 2 || r.Samples[1].Server != targets[i].Server {
+			t.Fatalf("result %d out of order: %+v", i, r)
+		}
+	}
+}
+
+// TestBenchmarkDeadline: verifies a dead server cannot hold the run past the global deadline
+func TestBenchmarkDeadline(t *testing.T) {
+	fakeProbe(t, func(tg Target, _ Query, timeout time.Duration, _ string) Result {
+		time.Sleep(timeout) // a server that never answers
+		return Result{Server: tg.String(), Error: fmt.Errorf("i/o timeout")}
+	})
+
+	targets := []Target{{Server: "192.0.2.1"}, {Server: "192.0.2.2"}, {Server: "192.0.2.3"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	results := Benchmark(ctx, targets, BenchOptions{Repeat: 5, Timeout: 2 * time.Second, Concurrency: 2})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("benchmark ignored the deadline, took %v", elapsed)
+	}
+
+	for _, r := range results {
+		if _, ok := r.Average(); ok {
+			t.Fatalf("dead server reported success: %+v", r)
+		}
+	}
+}
+
+// TestBenchmarkProfiles: verifies per-profile grouping against local stub servers
+func TestBenchmarkProfiles(t *testing.T) {
+	fast := startUDPStub(t, answerExample)
+	slow := startUDPStub(t, func(w dns.ResponseWriter, q *dns.Msg) {
+		time.Sleep(30 * time.Millisecond)
+		answerExample(w, q)
+	})
+
+	profiles := []config2.Profile{
+		{Name: "slow", Servers: []string{slow}},
+		{Name: "fast", Servers: []string{fast, fast}},
+	}
+
+	results := BenchmarkProfiles(context.Background(), profiles, BenchOptions{QName: "example.com", Repeat: 2, Concurrency: 4})
+	if len(results) != 2 || len(results[0].Servers) != 1 || len(results[1].Servers) != 2 {
+		t.Fatalf("unexpected grouping: %+v", results)
+	}
+
+	slowAvg, ok1 := results[0].Average()
+	fastAvg, ok2 := results[1].Average()
+	if !ok1 || !ok2 {
+		t.Fatalf("stub servers did not answer: %+v", results)
+	}
+	if fastAvg >= slowAvg {
+		t.Fatalf("expected fast profile (%v) to beat slow profile (%v)", fastAvg, slowAvg)
+	}
+}
@@ -0,0 +1,125 @@
+package resolver
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// signedZone holds pre-signed records of a test zone: sigok.test with a valid
+// signature and sigfail.test with a corrupted one
+type signedZone struct {
+	key     *dns.DNSKEY
+	records map[string][]dns.RR // owner -> A record and its RRSIG
+}
+
+// newSignedZone generates a key and signs the records of the test zone
+func newSignedZone(t *testing.T) *signedZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "test.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z := &signedZone{key: key, records: map[string][]dns.RR{}}
+	for _, name := range []string{"sigok.test.", "sigfail.test."} {
+		a := &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.10"),
+		}
+		sig := &dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60},
+			TypeCovered: dns.TypeA,
+			Algorithm:   key.Algorithm,
+			Labels:      2,
+			OrigTtl:     60,
+			Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+			Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+			KeyTag:      key.KeyTag(),
+			SignerName:  "test.",
+		}
+		if err := sig.Sign(priv.(crypto.Signer), []dns.RR{a}); err != nil {
+			t.Fatal(err)
+		}
+		if name == "sigfail.test." {
+			// flip a character of the base64 signature, keeping it decodable
+			b := []byte(sig.Signature)
+			if b[0] == 'A' {
+				b[0] = 'B'
+			} else {
+				b[0] = 'A'
+			}
+			sig.Signature = string(b)
+		}
+		z.records[name] = []dns.RR{a, sig}
+	}
+	return z
+}
+
+// handler serves the zone; a validating handler checks the signatures like a
+// validating resolver would, setting AD or answering SERVFAIL
+func (z *signedZone) handler(validating bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, q *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(q)
+		rrs, ok := z.records[q.Question[0].Name]
+		if !ok {
+			resp.Rcode = dns.RcodeNameError
+			w.WriteMsg(resp)
+			return
+		}
+
+		do := q.IsEdns0() != nil && q.IsEdns0().Do()
+		if validating {
+			if err := rrs[1].(*dns.RRSIG).Verify(z.key, rrs[:1]); err != nil {
+				resp.Rcode = dns.RcodeServerFailure
+				w.WriteMsg(resp)
+				return
+			}
+			resp.AuthenticatedData = true
+		}
+		resp.Answer = append(resp.Answer, rrs[0])
+		if do {
+			resp.Answer = append(resp.Answer, rrs[1])
+			resp.SetEdns0(4096, true)
+		}
+		w.WriteMsg(resp)
+	}
+}
+
+// useTestDNSSECDomains points the probe to the test zone
+func useTestDNSSECDomains(t *testing.T) {
+	signed, broken := DNSSECSignedDomain, DNSSECBrokenDomain
+	t.Cleanup(func() { DNSSECSignedDomain, DNSSECBrokenDomain = signed, broken })
+	DNSSECSignedDomain, DNSSECBrokenDomain = "sigok.test", "sigfail.test"
+}
+
+// TestCheckDNSSEC: verifies validating, non-validating and unreachable servers
+func TestCheckDNSSEC(t *testing.T) {
+	useTestDNSSECDomains(t)
+	zone := newSignedZone(t)
+	validating := startUDPStub(t, zone.handler(true))
+	plain := startUDPStub(t, zone.handler(false))
+
+	targets := []Target{{Server: validating}, {Server: plain}, {Server: "127.0.0.1:1"}}
+	results := CheckDNSSECAll(context.Background(), targets, time.Second, "", 2)
+
+	if r := results[0]; r.Status != DNSSECValidating || !r.AD || !r.Signatures {
+		t.Fatalf("validating server not recognized: %+v", r)
+	}
+	if r := results[1]; r.Status != DNSSECNotValidating || r.AD || !r.Signatures {
+		t.Fatalf("non-validating server not recognized: %+v", r)
+	}
+	if r := results[2]; r.Status != DNSSECUnknown {
+		t.Fatalf("unreachable server not unknown: %+v", r)
+	}
+}
@@ -0,0 +1,92 @@
+package resolver
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// newDoHServer starts a local TLS server answering RFC 8484 queries for
+// example.com with 93.184.216.34 and installs its client as DoHClient.
+func newDoHServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var wire []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			wire, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != dnsMessageType {
+				http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			wire, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		q := new(dns.Msg)
+		if err := q.Unpack(wire); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(q)
+		if q.Question[0].Name == "example.com." {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("93.184.216.34"),
+			})
+		} else {
+			resp.Rcode = dns.RcodeNameError
+		}
+		out, _ := resp.Pack()
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(out)
+	}))
+	t.Cleanup(srv.Close)
+
+	orig := DoHClient
+	t.Cleanup(func() { DoHClient = orig })
+	DoHClient = srv.Client()
+	return srv
+}
+
+// TestMeasureDoH: verifies DoH latency is measured with both GET and POST
+func TestMeasureDoH(t *testing.T) {
+	srv := newDoHServer(t)
+
+	for _, method := range []string{"GET", "POST"} {
+		r := Probe(Target{Server: srv.URL + "/dns-query"}, Query{Name: "example.com"}, 2*time.Second, method)
+		if r.Error != nil {
+			t.Fatalf("%s: unexpected error: %v", method, r.Error)
+		}
+		if r.Protocol != "doh-"+map[string]string{"GET": "get", "POST": "post"}[method] {
+			t.Fatalf("%s: unexpected protocol %q", method, r.Protocol)
+		}
+		if r.RTT <= 0 {
+			t.Fatalf("%s: RTT not measured", method)
+		}
+	}
+}
+
+// TestMeasureDoHNoAnswer: verifies NXDOMAIN and HTTP errors are reported as errors
+func TestMeasureDoHNoAnswer(t *testing.T) {
+	srv := newDoHServer(t)
+
+	if r := MeasureDoH(srv.URL+"/dns-query", "missing.example", 2*time.Second, "GET"); r.Error == nil {
+		t.Fatal("expected error for NXDOMAIN")
+	}
+	if r := MeasureDoH(srv.URL+"/dns-query", "example.com", 2*time.Second, "PUT"); r.Error == nil {
+		t.Fatal("expected error for unsupported method")
+	}
+}
@@ -0,0 +1,92 @@
+package resolver
+
+import (
+	"crypto/x509"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// dotServerFor starts a TLS stub, trusts its certificate and describes it as a DoTServer
+func dotServerFor(t *testing.T) (config2.DoTServer, *x509.Certificate) {
+	addr, cert := startTLSStub(t, answerExample)
+
+	orig := DoTRootCAs
+	t.Cleanup(func() { DoTRootCAs = orig })
+	DoTRootCAs = x509.NewCertPool()
+	DoTRootCAs.AddCert(cert)
+
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return config2.DoTServer{Host: host, Port: p, AuthName: "dns.test"}, cert
+}
+
+// TestMeasureDoT: verifies handshake and query time are reported separately
+func TestMeasureDoT(t *testing.T) {
+	d, _ := dotServerFor(t)
+
+	r := Probe(Target{DoT: &d}, Query{Name: "example.com"}, 2*time.Second, "")
+	if r.Error != nil {
+		t.Fatalf("unexpected error: %v", r.Error)
+	}
+	if r.Protocol != "dot" || r.Handshake <= 0 || r.RTT <= 0 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+}
+
+// TestMeasureDoTAuthName: verifies a wrong authentication name fails the handshake
+func TestMeasureDoTAuthName(t *testing.T) {
+	d, _ := dotServerFor(t)
+	d.AuthName = "other.test"
+
+	if r := MeasureDoT(d, "example.com", 2*time.Second); r.Error == nil {
+		t.Fatal("expected certificate name mismatch")
+	}
+}
+
+// TestMeasureDoTPin: verifies SPKI pinning accepts the right key and rejects others
+func TestMeasureDoTPin(t *testing.T) {
+	d, cert := dotServerFor(t)
+
+	// a pin without auth name authenticates the server on its own
+	d.AuthName = ""
+	d.SPKIPin = SPKIPin(cert)
+	DoTRootCAs = x509.NewCertPool()
+	if r := MeasureDoT(d, "example.com", 2*time.Second); r.Error != nil {
+		t.Fatalf("pinned server rejected: %v", r.Error)
+	}
+
+	d.SPKIPin = "GP8Knf7qBae+aIfythytMbYnL+yowaWVeD6MoLHkVRg="
+	if r := MeasureDoT(d, "example.com", 2*time.Second); r.Error == nil {
+		t.Fatal("expected pin mismatch")
+	}
+}
+
+// TestMeasureDoTPinLeafOnly: verifies a pinned certificate sent behind
+// another leaf does not authenticate the server
+func TestMeasureDoTPinLeafOnly(t *testing.T) {
+	pinned, attacker := selfSigned(t), selfSigned(t)
+	attacker.Certificate = append(attacker.Certificate, pinned.Certificate[0])
+	addr := startTLSStubWith(t, answerExample, attacker)
+
+	orig := DoTRootCAs
+	t.Cleanup(func() { DoTRootCAs = orig })
+	DoTRootCAs = x509.NewCertPool()
+
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	d := config2.DoTServer{Host: host, Port: p, SPKIPin: SPKIPin(pinned.Leaf)}
+	if r := MeasureDoT(d, "example.com", 2*time.Second); r.Error == nil {
+		t.Fatal("expected the pin of an appended certificate to be rejected")
+	}
+
+	// with an authentication name the pin must be in the verified chain
+	DoTRootCAs.AddCert(pinned.Leaf)
+	d.AuthName = "dns.test"
+	if r := MeasureDoT(d, "example.com", 2*time.Second); r.Error == nil {
+		t.Fatal("expected an unverified chain to be rejected")
+	}
+}
@@ -0,0 +1,76 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// mockSystemLookup answers SystemLookup with addrs and err
+func mockSystemLookup(t *testing.T, addrs []net.IPAddr, err error) *[]string {
+	var hosts []string
+	orig := SystemLookup
+	t.Cleanup(func() { SystemLookup = orig })
+	SystemLookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		hosts = append(hosts, host)
+		return addrs, err
+	}
+	return &hosts
+}
+
+// TestCheckHealth: verifies a query answered by one of the servers passes,
+// while a query no server answers or a failing system resolver fails
+func TestCheckHealth(t *testing.T) {
+	good := startUDPStub(t, answerExample)
+	broken := startUDPStub(t, func(w dns.ResponseWriter, q *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetRcode(q, dns.RcodeServerFailure)
+		w.WriteMsg(resp)
+	})
+	hosts := mockSystemLookup(t, []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil)
+
+	opts := HealthOptions{
+		Queries: []Query{{Name: "example.com"}},
+		Timeout: time.Second,
+		System:  true,
+	}
+	report := CheckHealth(context.Background(), []Target{{Server: good}, {Server: broken}}, opts)
+	if !report.Passed || len(report.Checks) != 3 || len(report.Failed()) != 1 {
+		t.Fatalf("expected a pass with one failed check, got %+v", report)
+	}
+	if report.Failed()[0].Path != broken || report.Checks[2].Path != SystemPath {
+		t.Fatalf("unexpected checks %+v", report.Checks)
+	}
+	if len(*hosts) != 1 || (*hosts)[0] != "example.com." {
+		t.Fatalf("unexpected system lookups %v", *hosts)
+	}
+
+	report = CheckHealth(context.Background(), []Target{{Server: broken}}, opts)
+	if report.Passed {
+		t.Fatalf("expected a failure when no server answers, got %+v", report)
+	}
+
+	mockSystemLookup(t, nil, errors.New("no such host"))
+	report = CheckHealth(context.Background(), []Target{{Server: good}}, opts)
+	if report.Passed || report.Failed()[0].Path != SystemPath {
+		t.Fatalf("expected the system path to fail, got %+v", report)
+	}
+}
+
+// TestCheckHealthSystemQueries: verifies only names of A and AAAA queries
+// that must exist are resolved through the system
+func TestCheckHealthSystemQueries(t *testing.T) {
+	hosts := mockSystemLookup(t, []net.IPAddr{{IP: net.ParseIP("2001:db8::1")}}, nil)
+	opts := HealthOptions{
+		Queries: []Query{{Name: "a.test"}, {Name: "aaaa.test", Type: dns.TypeAAAA}, {Name: "mx.test", Type: dns.TypeMX}, CacheBustQuery("example.com")},
+		System:  true,
+	}
+	report := CheckHealth(context.Background(), nil, opts)
+	if !report.Passed || len(*hosts) != 2 || (*hosts)[1] != "aaaa.test." {
+		t.Fatalf("unexpected system lookups %v (%+v)", *hosts, report)
+	}
+}
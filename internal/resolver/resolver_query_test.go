@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/miekg/dns"
+)
+
+// TestCacheBustQuery: verifies random labels under the zone
+func TestCacheBustQuery(t *testing.T) {
+	a, b := CacheBustQuery("example.com"), CacheBustQuery("example.com.")
+	if a.Name == b.Name {
+		t.Fatalf("expected different names, got %s twice", a.Name)
+	}
+	for _, q := range []Query{a, b} {
+		if !strings.HasSuffix(q.Name, ".example.com.") || !q.AllowNXDomain {
+			t.Fatalf("unexpected query %+v", q)
+		}
+	}
+}
+
+// TestBenchmarkCacheBust: verifies uncached samples use fresh names and accept NXDOMAIN
+func TestBenchmarkCacheBust(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	addr := startUDPStub(t, func(w dns.ResponseWriter, q *dns.Msg) {
+		mu.Lock()
+		seen[q.Question[0].Name] = true
+		mu.Unlock()
+		answerExample(w, q)
+	})
+
+	opts := BenchOptions{QName: "example.com", Repeat: 3, Concurrency: 2, CacheBustZone: "example.com"}
+	sr := Benchmark(context.Background(), []Target{{Server: addr}}, opts)[0]
+
+	if len(sr.Samples) != 3 || len(sr.Uncached) != 3 {
+		t.Fatalf("unexpected sample counts: %+v", sr)
+	}
+	if st := sr.Stats(); st.Received != 3 {
+		t.Fatalf("cached samples failed: %+v", sr.Samples)
+	}
+	if st := sr.UncachedStats(); st.Received != 3 {
+		t.Fatalf("NXDOMAIN not accepted for uncached samples: %+v", sr.Uncached)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// example.com plus one fresh name per uncached sample
+	if len(seen) != 4 {
+		t.Fatalf("expected 4 distinct names, got %v", seen)
+	}
+
+	// outside cache-busting mode NXDOMAIN stays an error
+	if r := Probe(Target{Server: addr}, Query{Name: "missing.example.com"}, 2*time.Second, ""); r.Error == nil {
+		t.Fatal("expected NXDOMAIN to fail a normal query")
+	}
+}
+
+// TestBenchmarkSuite: verifies every query of a suite is asked per repetition and per-profile suites
+func TestBenchmarkSuite(t *testing.T) {
+	fakeProbe(t, func(tg Target, q Query, _ time.Duration, _ string) Result {
+		return Result{Server: tg.String(), RTT: time.Millisecond}
+	})
+
+	suite := config2.Suite{Name: "web", Queries: []config2.SuiteQuery{{Name: "a.test"}, {Name: "b.test", Type: "aaaa"}}}
+	queries, err := SuiteQueries(suite)
+	if err != nil {
+		t.Fatalf("SuiteQueries failed: %v", err)
+	}
+
+	opts := BenchOptions{
+		Queries:        queries,
+		ProfileQueries: map[string][]Query{"corp": {{Name: "intranet.corp", Type: dns.TypeMX}}},
+		Repeat:         2,
+		Concurrency:    2,
+	}
+	results := Benchmark(context.Background(), []Target{{Profile: "web", Server: "192.0.2.1"}, {Profile: "corp", Server: "10.0.0.53"}}, opts)
+
+	var got []string
+	for _, r := range results[0].Samples {
+		got = append(got, r.Query)
+	}
+	if strings.Join(got, ",") != "a.test A,b.test AAAA,a.test A,b.test AAAA" {
+		t.Fatalf("unexpected queries %v", got)
+	}
+	if len(results[1].Samples) != 2 || results[1].Samples[0].Query != "intranet.corp MX" {
+		t.Fatalf("profile suite not used: %+v", results[1].Samples)
+	}
+
+	if _, err := SuiteQueries(config2.Suite{Queries: []config2.SuiteQuery{{Name: "x.test", Type: "BOGUS"}}}); err == nil {
+		t.Fatal("expected error for unknown record type")
+	}
+}
@@ -25,7 +25,7 @@ func TestFindFastestProfile(t *testing.T) {
 		{Name: "cloudflare", Servers: []string{"1.1.1.1"}},
 	}
 
-	fastest := FindFastestProfile(profiles)
+	fastest := FindFastestProfile(profiles, RankLossWeighted)
 
 	if fastest == nil {
 		t.Fatal("No fastest profile returned")
@@ -33,3 +33,18 @@ func TestFindFastestProfile(t *testing.T) {
 
 	t.Logf("Fastest profile: %s", fastest.Name)
 }
+
+// TestServerAddr: verifies port 53 is added correctly for IPv4 and IPv6 literals
+func TestServerAddr(t *testing.T) {
+	cases := map[string]string{
+		"8.8.8.8":              "8.8.8.8:53",
+		"2606:4700:4700::1111": "[2606:4700:4700::1111]:53",
+		"127.0.0.1:5353":       "127.0.0.1:5353",
+		"[::1]:5353":           "[::1]:5353",
+	}
+	for in, want := range cases {
+		if got := serverAddr(in); got != want {
+			t.Errorf("serverAddr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
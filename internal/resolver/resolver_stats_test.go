@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	config2 "github.com/Mreza2020/DNS-Switcher/internal/config"
+)
+
+// samples builds results from RTTs in milliseconds; negative values are failures
+func samples(ms ...int) []Result {
+	var out []Result
+	for _, m := range ms {
+		if m < 0 {
+			out = append(out, Result{Error: fmt.Errorf("i/o timeout")})
+			continue
+		}
+		out = append(out, Result{RTT: time.Duration(m) * time.Millisecond})
+	}
+	return out
+}
+
+// TestComputeStats: verifies percentiles, jitter, deviation and loss
+func TestComputeStats(t *testing.T) {
+	st := ComputeStats(samples(10, 30, -1, 20, 40, 50, 60, 70, 80, 90, 100))
+
+	ms := time.Millisecond
+	if st.Sent != 11 || st.Received != 10 {
+		t.Fatalf("unexpected counts: %+v", st)
+	}
+	if st.Loss < 0.09 || st.Loss > 0.091 {
+		t.Fatalf("unexpected loss %v", st.Loss)
+	}
+	if st.Min != 10*ms || st.Median != 50*ms || st.P90 != 90*ms || st.P99 != 100*ms || st.Mean != 55*ms {
+		t.Fatalf("unexpected percentiles: %+v", st)
+	}
+	// |30-10| + |20-30| + |40-20| + 6*10 = 110 over 9 steps
+	if st.Jitter != 110*ms/9 {
+		t.Fatalf("unexpected jitter %v", st.Jitter)
+	}
+	if st.StdDev < 28*ms || st.StdDev > 29*ms {
+		t.Fatalf("unexpected stddev %v", st.StdDev)
+	}
+
+	if st := ComputeStats(samples(-1, -1)); st.Received != 0 || st.Loss != 1 {
+		t.Fatalf("expected total loss, got %+v", st)
+	}
+}
+
+// TestScoreLossyServer: a server answering 1 of 5 queries must not win because its one answer was fast
+func TestScoreLossyServer(t *testing.T) {
+	lossy := samples(5, -1, -1, -1, -1)
+	steady := samples(20, 22, 21, 25, 20)
+
+	for _, metric := range []RankMetric{RankP50, RankP90, RankLossWeighted} {
+		ls, lok := Score(lossy, metric)
+		ss, sok := Score(steady, metric)
+		if !sok {
+			t.Fatalf("%s: steady server not ranked", metric)
+		}
+		if lok && ls <= ss {
+			t.Fatalf("%s: lossy server (%v) beat steady server (%v)", metric, ls, ss)
+		}
+	}
+
+	// the plain mean ignores failures
+	if ls, _ := Score(lossy, RankMean); ls != 5*time.Millisecond {
+		t.Fatalf("unexpected mean score %v", ls)
+	}
+}
+
+// TestRankProfiles: verifies ordering and that silent profiles are left out
+func TestRankProfiles(t *testing.T) {
+	results := []ProfileResult{
+		{Profile: config2.Profile{Name: "lossy"}, Servers: []ServerResult{{Samples: samples(5, -1, -1, -1, -1)}}},
+		{Profile: config2.Profile{Name: "dead"}, Servers: []ServerResult{{Samples: samples(-1, -1)}}},
+		{Profile: config2.Profile{Name: "steady"}, Servers: []ServerResult{{Samples: samples(20, 21)}, {Samples: samples(22, 20)}}},
+	}
+
+	ranked := RankProfiles(results, RankLossWeighted)
+	if len(ranked) != 2 || ranked[0].Profile.Name != "steady" || ranked[1].Profile.Name != "lossy" {
+		t.Fatalf("unexpected ranking: %+v", ranked)
+	}
+
+	ranked = RankProfiles(results, RankMean)
+	if ranked[0].Profile.Name != "lossy" {
+		t.Fatalf("expected mean ranking to ignore loss, got %+v", ranked)
+	}
+}
+
+// TestParseRankMetric: verifies metric names
+func TestParseRankMetric(t *testing.T) {
+	if m, err := ParseRankMetric("p90"); err != nil || m != RankP90 {
+		t.Fatalf("unexpected result %v, %v", m, err)
+	}
+	if _, err := ParseRankMetric("fastest"); err == nil {
+		t.Fatal("expected error for unknown metric")
+	}
+}
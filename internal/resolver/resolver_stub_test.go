@@ -0,0 +1,98 @@
+package resolver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// answerExample is a stub handler answering A queries for example.com
+// with 93.184.216.34 and everything else with NXDOMAIN.
+func answerExample(w dns.ResponseWriter, q *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(q)
+	if q.Question[0].Name == "example.com." && q.Question[0].Qtype == dns.TypeA {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("93.184.216.34"),
+		})
+	} else {
+		resp.Rcode = dns.RcodeNameError
+	}
+	w.WriteMsg(resp)
+}
+
+// startUDPStub runs a local DNS server on 127.0.0.1 and returns its address
+func startUDPStub(t *testing.T, handler dns.HandlerFunc) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+// startTLSStub runs a local DNS-over-TLS server with a self-signed
+// certificate for "dns.test" and 127.0.0.1, and returns its address and cert.
+func startTLSStub(t *testing.T, handler dns.HandlerFunc) (string, *x509.Certificate) {
+	cert := selfSigned(t)
+	return startTLSStubWith(t, handler, cert), cert.Leaf
+}
+
+// selfSigned returns a self-signed certificate for "dns.test" and 127.0.0.1
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.test"},
+		DNSNames:              []string{"dns.test"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// startTLSStubWith runs a local DNS-over-TLS server presenting cert and
+// returns its address
+func startTLSStubWith(t *testing.T, handler dns.HandlerFunc, cert tls.Certificate) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{Listener: ln, Net: "tcp-tls", Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+
+	return ln.Addr().String()
+}
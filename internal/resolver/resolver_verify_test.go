@@ -0,0 +1,169 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// lyingResolver answers example.com with another address and every other name
+// with an ad server address instead of NXDOMAIN
+func lyingResolver(w dns.ResponseWriter, q *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(q)
+	name := q.Question[0].Name
+	ip := "10.10.34.34"
+	if name != "example.com." {
+		ip = "198.51.100.7"
+	}
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP(ip),
+	})
+	w.WriteMsg(resp)
+}
+
+// injectingPath sends a forged answer followed by the genuine one, like an
+// on-path injector racing the real resolver
+func injectingPath(w dns.ResponseWriter, q *dns.Msg) {
+	forged := new(dns.Msg)
+	forged.SetReply(q)
+	forged.Answer = append(forged.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("10.10.34.35"),
+	})
+	w.WriteMsg(forged)
+	time.Sleep(20 * time.Millisecond)
+	answerExample(w, q)
+}
+
+// verdicts returns the verdict of every check of a server, keyed by check
+func verdicts(results []VerifyResult, server string) map[Check]Verdict {
+	out := map[Check]Verdict{}
+	for _, r := range results {
+		if r.Result.Server == server {
+			out[r.Check] = r.Verdict
+		}
+	}
+	return out
+}
+
+// TestVerify: verifies honest, lying and injecting servers are told apart
+func TestVerify(t *testing.T) {
+	ref := startUDPStub(t, answerExample)
+	honest := startUDPStub(t, answerExample)
+	liar := startUDPStub(t, lyingResolver)
+	injector := startUDPStub(t, injectingPath)
+
+	opts := VerifyOptions{
+		Queries:         []Query{{Name: "example.com"}},
+		Reference:       Target{Server: ref},
+		Timeout:         2 * time.Second,
+		NXZone:          "example.com",
+		InjectionWindow: 200 * time.Millisecond,
+	}
+	targets := []Target{{Profile: "isp", Server: honest}, {Profile: "isp", Server: liar}, {Server: injector}}
+	results := Verify(context.Background(), targets, opts)
+
+	if got := verdicts(results, honest); got[CheckAnswer] != VerdictOK || got[CheckNXDomain] != VerdictOK || got[CheckInjection] != VerdictOK {
+		t.Fatalf("honest server flagged: %v", got)
+	}
+	if got := verdicts(results, liar); got[CheckAnswer] != VerdictMismatch || got[CheckNXDomain] != VerdictNXRewrite {
+		t.Fatalf("lying server not flagged: %v", got)
+	}
+	if got := verdicts(results, injector); got[CheckInjection] != VerdictInjected || !got[CheckInjection].Suspicious() {
+		t.Fatalf("injection not detected: %v", got)
+	}
+
+	for _, r := range results {
+		if r.Result.Server == liar && r.Check == CheckAnswer {
+			if strings.Join(r.Answers, ",") != "10.10.34.34" || strings.Join(r.Reference, ",") != "93.184.216.34" {
+				t.Fatalf("unexpected answers %+v", r)
+			}
+		}
+	}
+}
+
+// answerTable answers the A queries of the names in table with their address
+func answerTable(table map[string]string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, q *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(q)
+		if ip, ok := table[q.Question[0].Name]; ok {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			})
+		}
+		w.WriteMsg(resp)
+	}
+}
+
+// TestVerifyGeoAnswers: verifies neighbouring addresses and a single
+// disagreeing canary are not reported as tampering, several are
+func TestVerifyGeoAnswers(t *testing.T) {
+	ref := startUDPStub(t, answerTable(map[string]string{
+		"a.example.": "93.184.216.34", "b.example.": "203.0.113.10", "c.example.": "198.51.100.20"}))
+	cdn := startUDPStub(t, answerTable(map[string]string{
+		"a.example.": "93.184.216.99", "b.example.": "203.0.113.10", "c.example.": "198.51.100.20"}))
+	geo := startUDPStub(t, answerTable(map[string]string{
+		"a.example.": "192.0.2.1", "b.example.": "203.0.113.10", "c.example.": "198.51.100.20"}))
+	liar := startUDPStub(t, answerTable(map[string]string{
+		"a.example.": "10.10.34.34", "b.example.": "10.10.34.34", "c.example.": "198.51.100.20"}))
+
+	opts := VerifyOptions{
+		Queries:   []Query{{Name: "a.example"}, {Name: "b.example"}, {Name: "c.example"}},
+		Reference: Target{Server: ref},
+		Timeout:   2 * time.Second,
+	}
+	results := Verify(context.Background(), []Target{{Server: cdn}, {Server: geo}, {Server: liar}}, opts)
+
+	want := map[string][]Verdict{
+		cdn:  {VerdictOK, VerdictOK, VerdictOK},
+		geo:  {VerdictDiffers, VerdictOK, VerdictOK},
+		liar: {VerdictMismatch, VerdictMismatch, VerdictOK},
+	}
+	got := map[string][]Verdict{}
+	for _, r := range results {
+		got[r.Result.Server] = append(got[r.Result.Server], r.Verdict)
+	}
+	for server, verdicts := range want {
+		if !slices.Equal(got[server], verdicts) {
+			t.Fatalf("%s: expected %v, got %v", server, verdicts, got[server])
+		}
+	}
+	if VerdictDiffers.Suspicious() {
+		t.Fatal("a single disagreeing canary must not be suspicious")
+	}
+}
+
+// TestVerifyResultJSON: verifies results export with errors as strings
+func TestVerifyResultJSON(t *testing.T) {
+	results := Verify(context.Background(), []Target{{Server: "127.0.0.1:1"}}, VerifyOptions{
+		Queries:   []Query{{Name: "example.com"}},
+		Reference: Target{Server: "127.0.0.1:1"},
+		Timeout:   200 * time.Millisecond,
+	})
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded[0]["verdict"] != "error" {
+		t.Fatalf("unexpected JSON %s", b)
+	}
+	result, _ := decoded[0]["result"].(map[string]interface{})
+	if result["server"] != "127.0.0.1:1" || result["error"] == "" || result["error"] == nil {
+		t.Fatalf("result not exported: %s", b)
+	}
+}
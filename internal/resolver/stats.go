@@ -0,0 +1,210 @@
+package resolver
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Stats summarizes a set of samples. Latency figures are computed over the
+// successful samples only; Loss is the share of samples that failed.
+type Stats struct {
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Loss     float64       `json:"loss"` // 0..1
+	Min      time.Duration `json:"min_ns"`
+	Median   time.Duration `json:"p50_ns"`
+	P90      time.Duration `json:"p90_ns"`
+	P99      time.Duration `json:"p99_ns"`
+	Mean     time.Duration `json:"mean_ns"`
+	StdDev   time.Duration `json:"stddev_ns"`
+	Jitter   time.Duration `json:"jitter_ns"` // mean difference between consecutive successful samples
+}
+
+// String formats the statistics in one line
+func (s Stats) String() string {
+	if s.Received == 0 {
+		return fmt.Sprintf("loss 100%% (0/%d)", s.Sent)
+	}
+	return fmt.Sprintf("min %v, p50 %v, p90 %v, p99 %v, stddev %v, jitter %v, loss %.0f%% (%d/%d)",
+		s.Min, s.Median, s.P90, s.P99, s.StdDev, s.Jitter, s.Loss*100, s.Received, s.Sent)
+}
+
+// ComputeStats summarizes samples, which are expected in probe order
+func ComputeStats(samples []Result) Stats {
+	st := Stats{Sent: len(samples)}
+
+	var rtts []time.Duration
+	for _, r := range samples {
+		if r.Error == nil {
+			rtts = append(rtts, r.RTT)
+		}
+	}
+	st.Received = len(rtts)
+	if st.Sent > 0 {
+		st.Loss = float64(st.Sent-st.Received) / float64(st.Sent)
+	}
+	if len(rtts) == 0 {
+		return st
+	}
+
+	var sum, jitter time.Duration
+	for i, d := range rtts {
+		sum += d
+		if i > 0 {
+			diff := d - rtts[i-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			jitter += diff
+		}
+	}
+	st.Mean = sum / time.Duration(len(rtts))
+	if len(rtts) > 1 {
+		st.Jitter = jitter / time.Duration(len(rtts)-1)
+	}
+
+	var variance float64
+	for _, d := range rtts {
+		diff := float64(d - st.Mean)
+		variance += diff * diff
+	}
+	st.StdDev = time.Duration(math.Sqrt(variance / float64(len(rtts))))
+
+	sorted := append([]time.Duration(nil), rtts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	st.Min = sorted[0]
+	st.Median = percentile(sorted, 50)
+	st.P90 = percentile(sorted, 90)
+	st.P99 = percentile(sorted, 99)
+
+	return st
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Stats summarizes the samples of the server
+func (s ServerResult) Stats() Stats {
+	return ComputeStats(s.Samples)
+}
+
+// UncachedStats summarizes the cache-busting samples of the server
+func (s ServerResult) UncachedStats() Stats {
+	return ComputeStats(s.Uncached)
+}
+
+// Samples returns the samples of every server of the profile
+func (p ProfileResult) Samples() []Result {
+	var all []Result
+	for _, s := range p.Servers {
+		all = append(all, s.Samples...)
+	}
+	return all
+}
+
+// UncachedSamples returns the cache-busting samples of every server of the profile
+func (p ProfileResult) UncachedSamples() []Result {
+	var all []Result
+	for _, s := range p.Servers {
+		all = append(all, s.Uncached...)
+	}
+	return all
+}
+
+// Stats summarizes the samples of all servers of the profile together
+func (p ProfileResult) Stats() Stats {
+	return ComputeStats(p.Samples())
+}
+
+// UncachedStats summarizes the cache-busting samples of all servers of the profile
+func (p ProfileResult) UncachedStats() Stats {
+	return ComputeStats(p.UncachedSamples())
+}
+
+// RankMetric selects how profiles are compared
+type RankMetric string
+
+const (
+	// RankP50 and RankP90 use percentiles in which failed samples count as
+	// infinitely slow, so a profile losing half of its queries has no p50.
+	RankP50 RankMetric = "p50"
+	RankP90 RankMetric = "p90"
+	// RankMean uses the mean of the successful samples only
+	RankMean RankMetric = "mean"
+	// RankLossWeighted divides the mean by the answer rate, approximating the
+	// time until an answer when lost queries have to be retried
+	RankLossWeighted RankMetric = "loss-weighted"
+)
+
+// RankMetrics lists the valid metrics, default first
+var RankMetrics = []RankMetric{RankLossWeighted, RankP50, RankP90, RankMean}
+
+// ParseRankMetric validates a metric name
+func ParseRankMetric(s string) (RankMetric, error) {
+	for _, m := range RankMetrics {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown rank metric '%s' (available: %v)", s, RankMetrics)
+}
+
+// Score returns the value samples are ranked by (lower is better).
+// ok is false when the samples cannot be ranked, e.g. nothing was answered.
+func Score(samples []Result, metric RankMetric) (score time.Duration, ok bool) {
+	st := ComputeStats(samples)
+	if st.Received == 0 {
+		return 0, false
+	}
+
+	switch metric {
+	case RankP50, RankP90:
+		p := 50.0
+		if metric == RankP90 {
+			p = 90
+		}
+		// failed samples sort after every answer
+		all := make([]time.Duration, 0, len(samples))
+		for _, r := range samples {
+			if r.Error != nil {
+				all = append(all, time.Duration(math.MaxInt64))
+			} else {
+				all = append(all, r.RTT)
+			}
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+		score = percentile(all, p)
+		return score, score != time.Duration(math.MaxInt64)
+	case RankMean:
+		return st.Mean, true
+	default:
+		return time.Duration(float64(st.Mean) / (1 - st.Loss)), true
+	}
+}
+
+// RankedProfile is a profile result with its score
+type RankedProfile struct {
+	ProfileResult
+	Score time.Duration
+}
+
+// RankProfiles orders the rankable profiles from best to worst by metric.
+// Profiles that cannot be ranked are left out.
+func RankProfiles(results []ProfileResult, metric RankMetric) []RankedProfile {
+	var ranked []RankedProfile
+	for _, r := range results {
+		if score, ok := Score(r.Samples(), metric); ok {
+			ranked = append(ranked, RankedProfile{ProfileResult: r, Score: score})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score < ranked[j].Score })
+	return ranked
+}
@@ -0,0 +1,382 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Mreza2020/DNS-Switcher/internal/config"
+	"github.com/miekg/dns"
+)
+
+// Check names what a VerifyResult checked
+type Check string
+
+const (
+	// CheckAnswer compares the answer of a canary domain with the reference
+	CheckAnswer Check = "answer"
+	// CheckNXDomain asks for a name that does not exist, which must stay NXDOMAIN
+	CheckNXDomain Check = "nxdomain"
+	// CheckInjection listens for more than one UDP response to the same query
+	CheckInjection Check = "injection"
+)
+
+// Verdict is the outcome of a check
+type Verdict string
+
+const (
+	VerdictOK         Verdict = "ok"
+	VerdictMismatch   Verdict = "mismatch"         // no address in common with the reference
+	VerdictDiffers    Verdict = "differs"          // a mismatch or block, but too few canaries disagree to point to tampering
+	VerdictBlocked    Verdict = "blocked"          // no answer where the reference has one
+	VerdictNXRewrite  Verdict = "nxdomain-rewrite" // answer for a name that does not exist
+	VerdictInjected   Verdict = "injected"         // a forged response arrived before the real one
+	VerdictUnverified Verdict = "unverified"       // the reference did not answer
+	VerdictError      Verdict = "error"            // the tested server did not answer
+)
+
+// Suspicious reports whether the verdict points to tampering
+func (v Verdict) Suspicious() bool {
+	switch v {
+	case VerdictMismatch, VerdictBlocked, VerdictNXRewrite, VerdictInjected:
+		return true
+	}
+	return false
+}
+
+// VerifyResult is the outcome of one check of one server. Result holds the
+// measurement of the query, Answers the records the server returned and
+// Reference those of the reference upstream (for CheckInjection: those of
+// the last response, the genuine one).
+type VerifyResult struct {
+	Profile   string   `json:"profile,omitempty"`
+	Check     Check    `json:"check"`
+	Verdict   Verdict  `json:"verdict"`
+	Result    Result   `json:"result"`
+	Answers   []string `json:"answers,omitempty"`
+	Reference []string `json:"reference,omitempty"`
+	Detail    string   `json:"detail,omitempty"`
+}
+
+// VerifyOptions configure Verify
+type VerifyOptions struct {
+	Queries   []Query       // canary domains
+	Reference Target        // trusted upstream, ideally DoH or DoT
+	Timeout   time.Duration // per query
+	DoHMethod string
+	// NXZone is the zone random non-existent names are asked under;
+	// empty skips CheckNXDomain
+	NXZone string
+	// InjectionWindow is how long to keep listening for further UDP responses
+	// after the first one; 0 skips CheckInjection
+	InjectionWindow time.Duration
+	// MinDisagreements is how many canaries of a target must be mismatched
+	// or blocked before CheckAnswer flags them; fewer are reported as
+	// VerdictDiffers. 0 means DefaultMinDisagreements. It never exceeds the
+	// number of queries.
+	MinDisagreements int
+}
+
+// DefaultMinDisagreements is the default VerifyOptions.MinDisagreements.
+// Geo-balanced names legitimately resolve differently from the reference
+// now and then, tampering rarely stops at a single canary.
+const DefaultMinDisagreements = 2
+
+// ReferenceTarget picks the upstream of a profile used as reference,
+// preferring the encrypted ones which are harder to tamper with
+func ReferenceTarget(p config.Profile) (Target, bool) {
+	targets := TargetsOf(p)
+	for _, t := range targets {
+		if t.IsDoH() || t.DoT != nil {
+			return t, true
+		}
+	}
+	if len(targets) == 0 {
+		return Target{}, false
+	}
+	return targets[0], true
+}
+
+// reference is the answer of the reference upstream to one query
+type reference struct {
+	msg *dns.Msg
+	err error
+}
+
+// Verify queries every canary domain through every target and compares the
+// answers with those of the reference upstream. Plain DNS targets are also
+// checked for injected responses, and every target for NXDOMAIN rewriting.
+// Targets not reached before ctx is done are left out.
+func Verify(ctx context.Context, targets []Target, opts VerifyOptions) []VerifyResult {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	if opts.MinDisagreements <= 0 {
+		opts.MinDisagreements = DefaultMinDisagreements
+	}
+	opts.MinDisagreements = min(opts.MinDisagreements, len(opts.Queries))
+
+	refs := make([]reference, len(opts.Queries))
+	for i, q := range opts.Queries {
+		msg, res := Exchange(opts.Reference, q, opts.Timeout, opts.DoHMethod)
+		refs[i] = reference{msg: msg, err: res.Error}
+		if msg == nil && res.Error == nil {
+			refs[i].err = fmt.Errorf("no response")
+		}
+	}
+
+	var out []VerifyResult
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		var answers []int
+		for i, q := range opts.Queries {
+			answers = append(answers, len(out))
+			out = append(out, verifyAnswer(t, q, refs[i], opts))
+			if opts.InjectionWindow > 0 && !t.IsDoH() && t.DoT == nil {
+				out = append(out, verifyInjection(t, q, opts))
+			}
+		}
+		tolerateDisagreements(out, answers, opts.MinDisagreements)
+		if opts.NXZone != "" {
+			out = append(out, verifyNXDomain(t, opts))
+		}
+	}
+	return out
+}
+
+// verifyAnswer compares the answer of t to q with the reference answer
+func verifyAnswer(t Target, q Query, ref reference, opts VerifyOptions) VerifyResult {
+	msg, res := Exchange(t, q, opts.Timeout, opts.DoHMethod)
+	res.Query = q.String()
+	vr := VerifyResult{Profile: t.Profile, Check: CheckAnswer, Result: res}
+
+	if msg == nil {
+		vr.Verdict = VerdictError
+		vr.Detail = errString(res.Error)
+		return vr
+	}
+	vr.Answers = answerSet(msg, q)
+	if ref.msg == nil {
+		vr.Verdict = VerdictUnverified
+		vr.Detail = "reference: " + errString(ref.err)
+		return vr
+	}
+	vr.Reference = answerSet(ref.msg, q)
+
+	switch {
+	case len(vr.Answers) > 0 && ref.msg.Rcode == dns.RcodeNameError:
+		vr.Verdict = VerdictNXRewrite
+		vr.Detail = "reference says NXDOMAIN"
+	case len(vr.Answers) == 0 && len(vr.Reference) > 0:
+		vr.Verdict = VerdictBlocked
+		vr.Detail = "rcode " + dns.RcodeToString[msg.Rcode]
+	case len(vr.Answers) > 0 && len(vr.Reference) > 0 && !overlaps(vr.Answers, vr.Reference):
+		if !sameNetworks(vr.Answers, vr.Reference) {
+			vr.Verdict = VerdictMismatch
+			break
+		}
+		vr.Verdict = VerdictOK
+		vr.Detail = "same networks as the reference"
+	default:
+		vr.Verdict = VerdictOK
+	}
+	return vr
+}
+
+// tolerateDisagreements turns the mismatched and blocked answers among
+// out[answers] into VerdictDiffers when fewer than threshold of them disagree
+func tolerateDisagreements(out []VerifyResult, answers []int, threshold int) {
+	var disagree []int
+	for _, i := range answers {
+		if out[i].Verdict == VerdictMismatch || out[i].Verdict == VerdictBlocked {
+			disagree = append(disagree, i)
+		}
+	}
+	if len(disagree) >= threshold {
+		return
+	}
+	for _, i := range disagree {
+		out[i].Detail = strings.TrimPrefix(out[i].Detail+"; ", "; ") +
+			fmt.Sprintf("%s, but only %d of %d canaries disagree", out[i].Verdict, len(disagree), len(answers))
+		out[i].Verdict = VerdictDiffers
+	}
+}
+
+// verifyNXDomain asks t and the reference for a random name that does not exist
+func verifyNXDomain(t Target, opts VerifyOptions) VerifyResult {
+	q := CacheBustQuery(opts.NXZone)
+	msg, res := Exchange(t, q, opts.Timeout, opts.DoHMethod)
+	res.Query = q.String()
+	vr := VerifyResult{Profile: t.Profile, Check: CheckNXDomain, Result: res}
+
+	if msg == nil {
+		vr.Verdict = VerdictError
+		vr.Detail = errString(res.Error)
+		return vr
+	}
+	vr.Answers = answerSet(msg, q)
+	if len(vr.Answers) == 0 {
+		vr.Verdict = VerdictOK
+		return vr
+	}
+
+	// a wildcard in the zone answers every name, check the reference agrees it does not exist
+	refMsg, refRes := Exchange(opts.Reference, q, opts.Timeout, opts.DoHMethod)
+	switch {
+	case refMsg == nil:
+		vr.Verdict = VerdictUnverified
+		vr.Detail = "reference: " + errString(refRes.Error)
+	case len(answerSet(refMsg, q)) > 0:
+		vr.Reference = answerSet(refMsg, q)
+		vr.Verdict = VerdictOK
+		vr.Detail = "zone has a wildcard"
+	default:
+		vr.Verdict = VerdictNXRewrite
+		vr.Detail = "answer for a name that does not exist"
+	}
+	return vr
+}
+
+// verifyInjection sends q to t over UDP and reports a forged response when
+// several responses arrive and the first one differs from the last
+func verifyInjection(t Target, q Query, opts VerifyOptions) VerifyResult {
+	start := time.Now()
+	responses, err := udpResponses(t.Server, q, opts.Timeout, opts.InjectionWindow)
+	res := Result{Server: t.String(), Query: q.String(), Protocol: "do53", RTT: time.Since(start), Error: err}
+	vr := VerifyResult{Profile: t.Profile, Check: CheckInjection, Result: res}
+
+	if err != nil {
+		vr.Verdict = VerdictError
+		vr.Detail = err.Error()
+		return vr
+	}
+
+	first, last := responses[0], responses[len(responses)-1]
+	vr.Answers = answerSet(first, q)
+	vr.Verdict = VerdictOK
+	if len(responses) > 1 {
+		genuine := answerSet(last, q)
+		if first.Rcode != last.Rcode || strings.Join(vr.Answers, ",") != strings.Join(genuine, ",") {
+			vr.Verdict = VerdictInjected
+			vr.Reference = genuine
+		}
+		vr.Detail = fmt.Sprintf("%d responses", len(responses))
+	}
+	return vr
+}
+
+// udpResponses sends q to server over UDP and returns every response with
+// the query ID, reading on for window after the first one arrived
+func udpResponses(server string, q Query, timeout, window time.Duration) ([]*dns.Msg, error) {
+	conn, err := net.DialTimeout("udp", serverAddr(server), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	m := q.msg()
+	wire, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(wire); err != nil {
+		return nil, err
+	}
+
+	var responses []*dns.Msg
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if len(responses) > 0 {
+				return responses, nil
+			}
+			return nil, err
+		}
+		r := new(dns.Msg)
+		if r.Unpack(buf[:n]) != nil || r.Id != m.Id {
+			continue
+		}
+		if len(responses) == 0 {
+			conn.SetReadDeadline(time.Now().Add(window))
+		}
+		responses = append(responses, r)
+	}
+}
+
+// answerSet returns the sorted data of the answer records of the query type,
+// e.g. the addresses of an A query
+func answerSet(r *dns.Msg, q Query) []string {
+	qtype := q.Type
+	if qtype == 0 {
+		qtype = dns.TypeA
+	}
+	var out []string
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		switch v := rr.(type) {
+		case *dns.A:
+			out = append(out, v.A.String())
+		case *dns.AAAA:
+			out = append(out, v.AAAA.String())
+		default:
+			out = append(out, strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String())))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// overlaps reports whether a and b have an entry in common
+func overlaps(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, s := range a {
+		set[s] = true
+	}
+	for _, s := range b {
+		if set[s] {
+			return true
+		}
+	}
+	return false
+}
+
+// sameNetworks reports whether a and b share an address network: a /24
+// for IPv4, a /48 for IPv6. CDNs answer from the addresses close to the
+// asking resolver, which are rarely identical but often neighbours.
+func sameNetworks(a, b []string) bool {
+	return overlaps(networks(a), networks(b))
+}
+
+// networks returns the networks of the addresses in list, skipping other records
+func networks(list []string) []string {
+	var out []string
+	for _, s := range list {
+		ip := net.ParseIP(s)
+		switch {
+		case ip == nil:
+			continue
+		case ip.To4() != nil:
+			out = append(out, ip.Mask(net.CIDRMask(24, 32)).String()+"/24")
+		default:
+			out = append(out, ip.Mask(net.CIDRMask(48, 128)).String()+"/48")
+		}
+	}
+	return out
+}
+
+// errString returns the message of err, "no response" for nil
+func errString(err error) string {
+	if err == nil {
+		return "no response"
+	}
+	return err.Error()
+}
@@ -0,0 +1,130 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Settings are the values the commands run with. They are loaded explicitly
+// by the command layer and handed to the packages that need them.
+type Settings struct {
+	ProfilesPath   string // profiles.yaml, see config.Path
+	SnapshotPath   string // snapshot history, see platform_all.SnapshotPath
+	Domain         string // single test domain replacing the built-in suite, may be empty
+	Backend        string // DNS backend name or "auto"
+	NonInteractive bool   // fail instead of prompting for an interface
+	ConfigFile     string // settings file that was read, empty when none
+}
+
+// Keys of the settings file; the flags use the same names
+const (
+	KeyProfiles       = "profiles"
+	KeySnapshots      = "snapshots"
+	KeyDomain         = "domain"
+	KeyBackend        = "backend"
+	KeyNonInteractive = "non-interactive" // boolean, the environment variable takes "true" or "1"
+)
+
+// Env maps every key to the environment variable overriding it
+var Env = map[string]string{
+	KeyProfiles:       "DNS_SWITCHER_PATH",
+	KeySnapshots:      "DNS_SWITCHER_SNAPSHOTS",
+	KeyDomain:         "DomainTesting",
+	KeyBackend:        "DNS_SWITCHER_BACKEND",
+	KeyNonInteractive: "DNS_SWITCHER_NON_INTERACTIVE",
+}
+
+// ConfigEnv names the environment variable pointing to the settings file
+const ConfigEnv = "DNS_SWITCHER_CONFIG"
+
+// ConfigDir returns the per-user configuration directory of the tool:
+// $XDG_CONFIG_HOME/dns-switcher (~/.config/dns-switcher) on Linux,
+// %AppData%\dns-switcher on Windows.
+func ConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "dns-switcher")
+}
+
+// Defaults returns the settings used when no layer sets a value
+func Defaults() Settings {
+	dir := ConfigDir()
+	return Settings{
+		ProfilesPath: filepath.Join(dir, "profiles.yaml"),
+		SnapshotPath: filepath.Join(dir, "snapshots.json"),
+		Backend:      "auto",
+	}
+}
+
+// Load merges the layers, each one overriding the previous:
+//  1. Defaults
+//  2. the settings file: configFile, else $DNS_SWITCHER_CONFIG, else
+//     config.yaml in ConfigDir (which may be missing)
+//  3. the environment variables of Env
+//  4. the flags of flags (named like the keys) that were set on the command line
+//
+// Relative paths in the settings file are resolved against its directory.
+// flags may be nil.
+func Load(configFile string, flags *pflag.FlagSet) (Settings, error) {
+	def := Defaults()
+
+	v := viper.New()
+	v.SetDefault(KeyProfiles, def.ProfilesPath)
+	v.SetDefault(KeySnapshots, def.SnapshotPath)
+	v.SetDefault(KeyDomain, def.Domain)
+	v.SetDefault(KeyBackend, def.Backend)
+	v.SetDefault(KeyNonInteractive, def.NonInteractive)
+
+	explicit := true
+	if configFile == "" {
+		configFile = os.Getenv(ConfigEnv)
+	}
+	if configFile == "" {
+		configFile = filepath.Join(ConfigDir(), "config.yaml")
+		explicit = false
+	}
+
+	s := Settings{}
+	v.SetConfigFile(configFile)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return Settings{}, fmt.Errorf("cannot read settings %s: %v", configFile, err)
+		}
+	} else {
+		s.ConfigFile = configFile
+		for _, key := range []string{KeyProfiles, KeySnapshots} {
+			if p := v.GetString(key); v.InConfig(key) && p != "" && !filepath.IsAbs(p) {
+				v.Set(key, filepath.Join(filepath.Dir(configFile), p))
+			}
+		}
+	}
+
+	for key, env := range Env {
+		if val, ok := os.LookupEnv(env); ok && val != "" {
+			v.Set(key, val)
+		}
+	}
+
+	if flags != nil {
+		for key := range Env {
+			if f := flags.Lookup(key); f != nil && f.Changed {
+				v.Set(key, f.Value.String())
+			}
+		}
+	}
+
+	s.ProfilesPath = v.GetString(KeyProfiles)
+	s.SnapshotPath = v.GetString(KeySnapshots)
+	s.Domain = v.GetString(KeyDomain)
+	s.Backend = v.GetString(KeyBackend)
+	s.NonInteractive = v.GetBool(KeyNonInteractive)
+	return s, nil
+}
@@ -0,0 +1,89 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// isolate points the user config directory to a temp dir and clears the environment
+func isolate(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+	t.Setenv(ConfigEnv, "")
+	for _, env := range Env {
+		t.Setenv(env, "")
+	}
+	return filepath.Join(dir, "dns-switcher")
+}
+
+// TestLoadDefaults: verifies a missing settings file is not an error
+func TestLoadDefaults(t *testing.T) {
+	dir := isolate(t)
+
+	s, err := Load("", nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if s.ProfilesPath != filepath.Join(dir, "profiles.yaml") || s.Backend != "auto" || s.Domain != "" || s.ConfigFile != "" {
+		t.Fatalf("unexpected defaults %+v", s)
+	}
+}
+
+// TestLoadLayers: verifies file < env < flags and relative paths in the file
+func TestLoadLayers(t *testing.T) {
+	dir := isolate(t)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "config.yaml")
+	content := "profiles: my-profiles.yaml\ndomain: file.test\nbackend: nmcli\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Load("", nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if s.ConfigFile != file || s.ProfilesPath != filepath.Join(dir, "my-profiles.yaml") || s.Domain != "file.test" || s.Backend != "nmcli" {
+		t.Fatalf("settings file not applied: %+v", s)
+	}
+
+	t.Setenv(Env[KeyDomain], "env.test")
+	t.Setenv(Env[KeyBackend], "resolvconf")
+	t.Setenv(Env[KeyNonInteractive], "1")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String(KeyDomain, "", "")
+	flags.String(KeyBackend, "auto", "")
+	if err := flags.Parse([]string{"--domain", "flag.test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load("", flags)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if s.Domain != "flag.test" {
+		t.Fatalf("flag did not override env: %+v", s)
+	}
+	if s.Backend != "resolvconf" {
+		t.Fatalf("unset flag default overrode env: %+v", s)
+	}
+	if !s.NonInteractive {
+		t.Fatalf("boolean env not applied: %+v", s)
+	}
+}
+
+// TestLoadExplicitFile: verifies an explicitly named settings file must exist
+func TestLoadExplicitFile(t *testing.T) {
+	isolate(t)
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml"), nil); err == nil {
+		t.Fatal("expected error for missing settings file")
+	}
+}